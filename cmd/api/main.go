@@ -6,7 +6,9 @@ import (
 
 	"github.com/EngenMe/go-api-dod/config"
 	"github.com/EngenMe/go-api-dod/internal/api"
+	"github.com/EngenMe/go-api-dod/internal/data/models"
 	"github.com/EngenMe/go-api-dod/internal/data/store"
+	"github.com/EngenMe/go-api-dod/internal/utils"
 )
 
 func main() {
@@ -27,8 +29,46 @@ func main() {
 		log.Fatalf("Failed to run migrations: %v", err)
 	}
 
+	// Hash refresh tokens still stored in plaintext by older releases
+	tokenHasher := utils.NewTokenHasher(cfg.Auth.TokenPepper)
+	if err := db.MigrateRefreshTokenHashes(tokenHasher); err != nil {
+		log.Fatalf("Failed to migrate refresh tokens: %v", err)
+	}
+
+	// Clients of older releases had a role, which no longer applies
+	adminClients, err := db.MigrateClientRoles()
+	if err != nil {
+		log.Fatalf("Failed to migrate OAuth client roles: %v", err)
+	}
+	for _, clientID := range adminClients {
+		log.Printf(
+			"OAuth client %s lost the admin role, it now only has the permissions of its scopes",
+			clientID,
+		)
+	}
+
+	// Encrypt TOTP secrets still stored in plaintext by older releases
+	secretBox, err := utils.NewSecretBox(cfg.Auth.MFAEncryptionKey)
+	if err != nil {
+		log.Fatalf("Failed to initialize MFA secret encryption: %v", err)
+	}
+	if err := db.MigrateMFASecrets(secretBox); err != nil {
+		log.Fatalf("Failed to migrate MFA secrets: %v", err)
+	}
+
+	// Grant the admin role to the configured bootstrap accounts
+	userStore := store.NewUserStore(db.DB, secretBox, nil)
+	for _, email := range cfg.Auth.AdminEmails {
+		if err := userStore.SetRoleByEmail(email, models.RoleAdmin); err != nil {
+			log.Fatalf("Failed to promote %s to admin: %v", email, err)
+		}
+	}
+
 	// Initialize and start an API server
-	server := api.NewServer(cfg, db)
+	server, err := api.NewServer(cfg, db)
+	if err != nil {
+		log.Fatalf("Failed to initialize server: %v", err)
+	}
 	addr := fmt.Sprintf(":%d", cfg.Server.Port)
 	log.Printf("Starting server on %s", addr)
 	if err := server.Run(addr); err != nil {
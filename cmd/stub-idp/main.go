@@ -0,0 +1,209 @@
+// Command stub-idp runs a minimal OpenID Connect identity provider for trying
+// out and testing federated login locally. Every authorization request is
+// approved at once for a single configured user.
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"flag"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/EngenMe/go-api-dod/internal/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// authorization is an issued, not yet redeemed authorization code
+type authorization struct {
+	RedirectURI   string
+	Nonce         string
+	CodeChallenge string
+	ExpiresAt     time.Time
+}
+
+// stubIdP holds the provider's configuration and issued codes
+type stubIdP struct {
+	Issuer        string
+	ClientID      string
+	ClientSecret  string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Key           *utils.SigningKey
+
+	mu    sync.Mutex
+	codes map[string]authorization
+}
+
+func main() {
+	idp := &stubIdP{codes: make(map[string]authorization)}
+	addr := flag.String("addr", ":9000", "listen address")
+	flag.StringVar(&idp.Issuer, "issuer", "http://localhost:9000", "issuer URL")
+	flag.StringVar(&idp.ClientID, "client-id", "go-api-dod", "accepted client ID")
+	flag.StringVar(&idp.ClientSecret, "client-secret", "stub-secret", "accepted client secret, empty for a public client")
+	flag.StringVar(&idp.Subject, "subject", "stub-user", "subject of the signed in user")
+	flag.StringVar(&idp.Email, "email", "stub.user@example.com", "email of the signed in user")
+	flag.BoolVar(&idp.EmailVerified, "email-verified", true, "whether the email is verified")
+	flag.Parse()
+
+	// A fresh key on every start is enough for a stub
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		log.Fatalf("Failed to generate key: %v", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		log.Fatalf("Failed to encode key: %v", err)
+	}
+	idp.Key, err = utils.ParseSigningKey(
+		"",
+		utils.AlgorithmRS256,
+		pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}),
+	)
+	if err != nil {
+		log.Fatalf("Failed to load key: %v", err)
+	}
+
+	router := gin.Default()
+	router.GET("/.well-known/openid-configuration", idp.discovery)
+	router.GET("/jwks", idp.jwks)
+	router.GET("/authorize", idp.authorize)
+	router.POST("/token", idp.token)
+
+	log.Printf("Stub identity provider %s listening on %s", idp.Issuer, *addr)
+	if err := router.Run(*addr); err != nil {
+		log.Fatalf("Failed to start stub identity provider: %v", err)
+	}
+}
+
+// discovery serves the discovery document
+func (p *stubIdP) discovery(c *gin.Context) {
+	c.JSON(
+		http.StatusOK, gin.H{
+			"issuer":                                p.Issuer,
+			"authorization_endpoint":                p.Issuer + "/authorize",
+			"token_endpoint":                        p.Issuer + "/token",
+			"jwks_uri":                              p.Issuer + "/jwks",
+			"response_types_supported":              []string{"code"},
+			"subject_types_supported":               []string{"public"},
+			"id_token_signing_alg_values_supported": []string{utils.AlgorithmRS256},
+			"code_challenge_methods_supported":      []string{utils.PKCEMethodS256},
+		},
+	)
+}
+
+// jwks serves the public key that verifies ID tokens
+func (p *stubIdP) jwks(c *gin.Context) {
+	jwk, _ := p.Key.JWK()
+	c.JSON(http.StatusOK, utils.JWKSet{Keys: []utils.JWK{jwk}})
+}
+
+// authorize approves the request and redirects back with a code
+func (p *stubIdP) authorize(c *gin.Context) {
+	if c.Query("client_id") != p.ClientID {
+		c.String(http.StatusBadRequest, "unknown client")
+		return
+	}
+	redirectURI, err := url.Parse(c.Query("redirect_uri"))
+	if err != nil || !redirectURI.IsAbs() {
+		c.String(http.StatusBadRequest, "invalid redirect_uri")
+		return
+	}
+	if c.Query("code_challenge_method") != utils.PKCEMethodS256 {
+		c.String(http.StatusBadRequest, "S256 code challenge required")
+		return
+	}
+
+	code, err := utils.GenerateRandomToken(16)
+	if err != nil {
+		c.String(http.StatusInternalServerError, "failed to generate code")
+		return
+	}
+
+	p.mu.Lock()
+	p.codes[code] = authorization{
+		RedirectURI:   redirectURI.String(),
+		Nonce:         c.Query("nonce"),
+		CodeChallenge: c.Query("code_challenge"),
+		ExpiresAt:     time.Now().Add(time.Minute),
+	}
+	p.mu.Unlock()
+
+	query := redirectURI.Query()
+	query.Set("code", code)
+	query.Set("state", c.Query("state"))
+	redirectURI.RawQuery = query.Encode()
+	c.Redirect(http.StatusFound, redirectURI.String())
+}
+
+// token redeems a code for an ID token
+func (p *stubIdP) token(c *gin.Context) {
+	clientID, secret, ok := c.Request.BasicAuth()
+	if ok {
+		clientID, _ = url.QueryUnescape(clientID)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		clientID = c.PostForm("client_id")
+		secret = c.PostForm("client_secret")
+	}
+	if clientID != p.ClientID || secret != p.ClientSecret {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_client"})
+		return
+	}
+
+	p.mu.Lock()
+	auth, ok := p.codes[c.PostForm("code")]
+	delete(p.codes, c.PostForm("code"))
+	p.mu.Unlock()
+	if !ok ||
+		c.PostForm("grant_type") != "authorization_code" ||
+		time.Now().After(auth.ExpiresAt) ||
+		c.PostForm("redirect_uri") != auth.RedirectURI ||
+		!utils.VerifyPKCE(c.PostForm("code_verifier"), auth.CodeChallenge) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_grant"})
+		return
+	}
+
+	now := time.Now()
+	token := jwt.NewWithClaims(
+		p.Key.Method, jwt.MapClaims{
+			"iss":            p.Issuer,
+			"sub":            p.Subject,
+			"aud":            p.ClientID,
+			"iat":            now.Unix(),
+			"exp":            now.Add(5 * time.Minute).Unix(),
+			"nonce":          auth.Nonce,
+			"email":          p.Email,
+			"email_verified": p.EmailVerified,
+		},
+	)
+	token.Header["kid"] = p.Key.ID
+	idToken, err := token.SignedString(p.Key.SignKey)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+		return
+	}
+
+	accessToken, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+		return
+	}
+
+	c.JSON(
+		http.StatusOK, gin.H{
+			"access_token": accessToken,
+			"token_type":   "Bearer",
+			"expires_in":   300,
+			"id_token":     idToken,
+		},
+	)
+}
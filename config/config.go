@@ -2,8 +2,10 @@ package config
 
 import (
 	"errors"
+	"net/url"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/joho/godotenv"
@@ -14,12 +16,14 @@ type Config struct {
 	Server   ServerConfig
 	Database DatabaseConfig
 	Auth     AuthConfig
+	Mail     MailConfig
 }
 
 // ServerConfig holds server-specific configuration
 type ServerConfig struct {
-	Port int
-	Mode string // development, production
+	Port    int
+	Mode    string // development, production
+	BaseURL string // public URL of the API, used in discovery documents
 }
 
 // DatabaseConfig holds database-specific configuration
@@ -34,11 +38,75 @@ type DatabaseConfig struct {
 
 // AuthConfig holds authentication-specific configuration
 type AuthConfig struct {
-	JWTSecret              string
-	AccessTokenExpiration  time.Duration
-	RefreshTokenExpiration time.Duration
-	TokenIssuer            string
-	BcryptCost             int
+	SigningKey                      SigningKeyConfig
+	VerificationKeys                []SigningKeyConfig
+	TokenPepper                     string
+	MFAEncryptionKey                string
+	AccessTokenExpiration           time.Duration
+	RefreshTokenExpiration          time.Duration
+	MFATokenExpiration              time.Duration
+	ImpersonationExpiration         time.Duration // lifetime of admin impersonation tokens
+	ReauthenticationWindow          time.Duration // how long a login stands in for the password
+	TokenRevocationCacheTTL         time.Duration // how long revocations may take to reach every instance
+	AuthorizationCodeExpiration     time.Duration
+	LockoutThreshold                int           // failed logins before the account locks
+	LockoutBaseDuration             time.Duration // doubled for every further failure
+	LockoutMaxDuration              time.Duration
+	IPLoginFailureLimit             int // failed logins per IP and window
+	IPLoginFailureWindow            time.Duration
+	TokenIssuer                     string
+	MFAIssuer                       string // account issuer shown by authenticator apps
+	PasswordHashAlgorithm           string // argon2id or bcrypt
+	BcryptCost                      int
+	Argon2Memory                    uint32 // KiB
+	Argon2Iterations                uint32
+	Argon2Parallelism               uint8
+	PasswordMinLength               int
+	PasswordMaxLength               int
+	PasswordRequiredClasses         int // of lower, upper, digit and symbol; 0 disables
+	PasswordForbidEmail             bool
+	BreachedPasswordsFile           string // SHA-1 prefix list; empty disables the check
+	PasswordResetExpiration         time.Duration
+	PasswordResetURL                string // link sent in reset emails, gets ?token=
+	RequireEmailVerification        bool   // block login until the email is verified
+	EmailVerificationExpiration     time.Duration
+	EmailVerificationURL            string // link sent in verification emails, gets ?token=
+	EmailVerificationResendInterval time.Duration
+	MagicLinkEnabled                bool // passwordless login by emailed link
+	MagicLinkExpiration             time.Duration
+	MagicLinkURL                    string   // link sent in magic link emails, gets ?token=
+	NewDeviceNotifications          bool     // email users about sign-ins from new devices
+	AdminEmails                     []string // promoted to admin on startup
+	OIDCProviders                   []OIDCProviderConfig
+}
+
+// MailConfig holds outgoing email configuration
+type MailConfig struct {
+	Driver   string // log, file
+	From     string
+	FilePath string // file driver only
+}
+
+// OIDCProviderConfig describes an external OpenID Connect identity provider
+// that users can sign in with
+type OIDCProviderConfig struct {
+	Name         string // used in the login URLs
+	Issuer       string // discovery is fetched from Issuer/.well-known/openid-configuration
+	ClientID     string
+	ClientSecret string // empty for public clients
+	Scopes       []string
+	CreateUsers  bool // create unknown users on their first login
+	// Link unknown identities to the account with the same verified email.
+	// Admin accounts are never linked this way.
+	LinkExistingUsers bool
+}
+
+// SigningKeyConfig describes a JWT key
+type SigningKeyConfig struct {
+	ID        string
+	Algorithm string // HS256, RS256, ES256 or EdDSA
+	Secret    string // HS256 only
+	KeyFile   string // PEM file, asymmetric algorithms only
 }
 
 // Load loads the configuration from environment variables
@@ -55,6 +123,13 @@ func Load() (Config, error) {
 	}
 	cfg.Server.Port = port
 	cfg.Server.Mode = getEnv("SERVER_MODE", "development")
+	cfg.Server.BaseURL = strings.TrimSuffix(
+		getEnv("SERVER_BASE_URL", "http://localhost:8080"),
+		"/",
+	)
+	if !isHTTPURL(cfg.Server.BaseURL) {
+		return cfg, errors.New("invalid SERVER_BASE_URL")
+	}
 
 	// Database configuration
 	cfg.Database.Host = getEnv("DB_HOST", "localhost")
@@ -69,9 +144,41 @@ func Load() (Config, error) {
 	cfg.Database.SSLMode = getEnv("DB_SSLMODE", "disable")
 
 	// Auth configuration
-	cfg.Auth.JWTSecret = getEnv("JWT_SECRET", "")
-	if cfg.Auth.JWTSecret == "" {
-		return cfg, errors.New("JWT_SECRET is required")
+	cfg.Auth.SigningKey = SigningKeyConfig{
+		ID:        getEnv("JWT_KEY_ID", ""),
+		Algorithm: getEnv("JWT_ALGORITHM", "HS256"),
+		Secret:    getEnv("JWT_SECRET", ""),
+		KeyFile:   getEnv("JWT_PRIVATE_KEY_FILE", ""),
+	}
+	switch cfg.Auth.SigningKey.Algorithm {
+	case "HS256":
+		if cfg.Auth.SigningKey.Secret == "" {
+			return cfg, errors.New("JWT_SECRET is required")
+		}
+	case "RS256", "ES256", "EdDSA":
+		if cfg.Auth.SigningKey.KeyFile == "" {
+			return cfg, errors.New("JWT_PRIVATE_KEY_FILE is required")
+		}
+	default:
+		return cfg, errors.New("invalid JWT_ALGORITHM")
+	}
+
+	verificationKeys, err := parseVerificationKeys(
+		getEnv("JWT_VERIFICATION_KEYS", ""),
+	)
+	if err != nil {
+		return cfg, err
+	}
+	cfg.Auth.VerificationKeys = verificationKeys
+
+	cfg.Auth.TokenPepper = getEnv("TOKEN_PEPPER", "")
+	if cfg.Auth.TokenPepper == "" {
+		return cfg, errors.New("TOKEN_PEPPER is required")
+	}
+
+	cfg.Auth.MFAEncryptionKey = getEnv("MFA_ENCRYPTION_KEY", "")
+	if cfg.Auth.MFAEncryptionKey == "" {
+		return cfg, errors.New("MFA_ENCRYPTION_KEY is required")
 	}
 
 	accessTokenExpiration, err := strconv.Atoi(
@@ -96,7 +203,122 @@ func Load() (Config, error) {
 	}
 	cfg.Auth.RefreshTokenExpiration = time.Duration(refreshTokenExpiration) * 24 * time.Hour
 
-	cfg.Auth.TokenIssuer = getEnv("TOKEN_ISSUER", "go-api-dod")
+	mfaTokenExpiration, err := strconv.Atoi(
+		getEnv(
+			"MFA_TOKEN_EXPIRATION_MINUTES",
+			"5",
+		),
+	)
+	if err != nil {
+		return cfg, errors.New("invalid MFA_TOKEN_EXPIRATION_MINUTES")
+	}
+	cfg.Auth.MFATokenExpiration = time.Duration(mfaTokenExpiration) * time.Minute
+
+	impersonationExpiration, err := strconv.Atoi(
+		getEnv(
+			"IMPERSONATION_EXPIRATION_MINUTES",
+			"10",
+		),
+	)
+	if err != nil || impersonationExpiration < 1 {
+		return cfg, errors.New("invalid IMPERSONATION_EXPIRATION_MINUTES")
+	}
+	cfg.Auth.ImpersonationExpiration = time.Duration(impersonationExpiration) * time.Minute
+
+	reauthenticationWindow, err := strconv.Atoi(
+		getEnv(
+			"REAUTHENTICATION_WINDOW_MINUTES",
+			"5",
+		),
+	)
+	if err != nil || reauthenticationWindow < 0 {
+		return cfg, errors.New("invalid REAUTHENTICATION_WINDOW_MINUTES")
+	}
+	cfg.Auth.ReauthenticationWindow = time.Duration(reauthenticationWindow) * time.Minute
+
+	tokenRevocationCacheTTL, err := strconv.Atoi(
+		getEnv(
+			"TOKEN_REVOCATION_CACHE_SECONDS",
+			"5",
+		),
+	)
+	if err != nil || tokenRevocationCacheTTL < 0 {
+		return cfg, errors.New("invalid TOKEN_REVOCATION_CACHE_SECONDS")
+	}
+	cfg.Auth.TokenRevocationCacheTTL = time.Duration(tokenRevocationCacheTTL) * time.Second
+
+	authorizationCodeExpiration, err := strconv.Atoi(
+		getEnv(
+			"AUTHORIZATION_CODE_EXPIRATION_SECONDS",
+			"60",
+		),
+	)
+	if err != nil || authorizationCodeExpiration < 1 {
+		return cfg, errors.New("invalid AUTHORIZATION_CODE_EXPIRATION_SECONDS")
+	}
+	cfg.Auth.AuthorizationCodeExpiration = time.Duration(authorizationCodeExpiration) * time.Second
+
+	lockoutThreshold, err := strconv.Atoi(getEnv("LOCKOUT_THRESHOLD", "5"))
+	if err != nil || lockoutThreshold < 1 {
+		return cfg, errors.New("invalid LOCKOUT_THRESHOLD")
+	}
+	cfg.Auth.LockoutThreshold = lockoutThreshold
+
+	lockoutBaseDuration, err := strconv.Atoi(
+		getEnv(
+			"LOCKOUT_BASE_DURATION_SECONDS",
+			"60",
+		),
+	)
+	if err != nil || lockoutBaseDuration < 1 {
+		return cfg, errors.New("invalid LOCKOUT_BASE_DURATION_SECONDS")
+	}
+	cfg.Auth.LockoutBaseDuration = time.Duration(lockoutBaseDuration) * time.Second
+
+	lockoutMaxDuration, err := strconv.Atoi(
+		getEnv(
+			"LOCKOUT_MAX_DURATION_MINUTES",
+			"60",
+		),
+	)
+	if err != nil || lockoutMaxDuration < 1 {
+		return cfg, errors.New("invalid LOCKOUT_MAX_DURATION_MINUTES")
+	}
+	cfg.Auth.LockoutMaxDuration = time.Duration(lockoutMaxDuration) * time.Minute
+
+	ipLoginFailureLimit, err := strconv.Atoi(
+		getEnv(
+			"IP_LOGIN_FAILURE_LIMIT",
+			"20",
+		),
+	)
+	if err != nil || ipLoginFailureLimit < 1 {
+		return cfg, errors.New("invalid IP_LOGIN_FAILURE_LIMIT")
+	}
+	cfg.Auth.IPLoginFailureLimit = ipLoginFailureLimit
+
+	ipLoginFailureWindow, err := strconv.Atoi(
+		getEnv(
+			"IP_LOGIN_FAILURE_WINDOW_MINUTES",
+			"15",
+		),
+	)
+	if err != nil || ipLoginFailureWindow < 1 {
+		return cfg, errors.New("invalid IP_LOGIN_FAILURE_WINDOW_MINUTES")
+	}
+	cfg.Auth.IPLoginFailureWindow = time.Duration(ipLoginFailureWindow) * time.Minute
+
+	// OpenID Connect expects the URL the discovery document is served under.
+	// An explicit TOKEN_ISSUER, such as the go-api-dod of older releases,
+	// still wins so that upgrading keeps the iss of issued tokens.
+	cfg.Auth.TokenIssuer = strings.TrimSuffix(
+		getEnv("TOKEN_ISSUER", cfg.Server.BaseURL),
+		"/",
+	)
+	if cfg.Auth.TokenIssuer == "" {
+		return cfg, errors.New("invalid TOKEN_ISSUER")
+	}
+	cfg.Auth.MFAIssuer = getEnv("MFA_ISSUER", "go-api-dod")
 
 	bcryptCost, err := strconv.Atoi(getEnv("BCRYPT_COST", "10"))
 	if err != nil {
@@ -104,6 +326,174 @@ func Load() (Config, error) {
 	}
 	cfg.Auth.BcryptCost = bcryptCost
 
+	cfg.Auth.PasswordHashAlgorithm = getEnv("PASSWORD_HASH_ALGORITHM", "argon2id")
+	if cfg.Auth.PasswordHashAlgorithm != "argon2id" &&
+		cfg.Auth.PasswordHashAlgorithm != "bcrypt" {
+		return cfg, errors.New("invalid PASSWORD_HASH_ALGORITHM")
+	}
+
+	argon2Memory, err := strconv.ParseUint(
+		getEnv("ARGON2_MEMORY_KB", "65536"),
+		10,
+		32,
+	)
+	if err != nil {
+		return cfg, errors.New("invalid ARGON2_MEMORY_KB")
+	}
+	cfg.Auth.Argon2Memory = uint32(argon2Memory)
+
+	argon2Iterations, err := strconv.ParseUint(
+		getEnv("ARGON2_ITERATIONS", "3"),
+		10,
+		32,
+	)
+	if err != nil || argon2Iterations < 1 {
+		return cfg, errors.New("invalid ARGON2_ITERATIONS")
+	}
+	cfg.Auth.Argon2Iterations = uint32(argon2Iterations)
+
+	argon2Parallelism, err := strconv.ParseUint(
+		getEnv("ARGON2_PARALLELISM", "2"),
+		10,
+		8,
+	)
+	if err != nil || argon2Parallelism < 1 {
+		return cfg, errors.New("invalid ARGON2_PARALLELISM")
+	}
+	cfg.Auth.Argon2Parallelism = uint8(argon2Parallelism)
+
+	passwordMinLength, err := strconv.Atoi(getEnv("PASSWORD_MIN_LENGTH", "8"))
+	if err != nil || passwordMinLength < 1 {
+		return cfg, errors.New("invalid PASSWORD_MIN_LENGTH")
+	}
+	cfg.Auth.PasswordMinLength = passwordMinLength
+
+	passwordMaxLength, err := strconv.Atoi(getEnv("PASSWORD_MAX_LENGTH", "128"))
+	if err != nil || passwordMaxLength < passwordMinLength {
+		return cfg, errors.New("invalid PASSWORD_MAX_LENGTH")
+	}
+	cfg.Auth.PasswordMaxLength = passwordMaxLength
+
+	passwordRequiredClasses, err := strconv.Atoi(
+		getEnv(
+			"PASSWORD_REQUIRED_CHARACTER_CLASSES",
+			"0",
+		),
+	)
+	if err != nil || passwordRequiredClasses < 0 || passwordRequiredClasses > 4 {
+		return cfg, errors.New("invalid PASSWORD_REQUIRED_CHARACTER_CLASSES")
+	}
+	cfg.Auth.PasswordRequiredClasses = passwordRequiredClasses
+
+	passwordForbidEmail, err := strconv.ParseBool(
+		getEnv("PASSWORD_FORBID_EMAIL", "true"),
+	)
+	if err != nil {
+		return cfg, errors.New("invalid PASSWORD_FORBID_EMAIL")
+	}
+	cfg.Auth.PasswordForbidEmail = passwordForbidEmail
+	cfg.Auth.BreachedPasswordsFile = getEnv("BREACHED_PASSWORDS_FILE", "")
+
+	passwordResetExpiration, err := strconv.Atoi(
+		getEnv(
+			"PASSWORD_RESET_EXPIRATION_MINUTES",
+			"30",
+		),
+	)
+	if err != nil {
+		return cfg, errors.New("invalid PASSWORD_RESET_EXPIRATION_MINUTES")
+	}
+	cfg.Auth.PasswordResetExpiration = time.Duration(passwordResetExpiration) * time.Minute
+	cfg.Auth.PasswordResetURL = getEnv(
+		"PASSWORD_RESET_URL",
+		"http://localhost:3000/reset-password",
+	)
+
+	requireEmailVerification, err := strconv.ParseBool(
+		getEnv("REQUIRE_EMAIL_VERIFICATION", "false"),
+	)
+	if err != nil {
+		return cfg, errors.New("invalid REQUIRE_EMAIL_VERIFICATION")
+	}
+	cfg.Auth.RequireEmailVerification = requireEmailVerification
+
+	emailVerificationExpiration, err := strconv.Atoi(
+		getEnv(
+			"EMAIL_VERIFICATION_EXPIRATION_HOURS",
+			"24",
+		),
+	)
+	if err != nil {
+		return cfg, errors.New("invalid EMAIL_VERIFICATION_EXPIRATION_HOURS")
+	}
+	cfg.Auth.EmailVerificationExpiration = time.Duration(emailVerificationExpiration) * time.Hour
+	cfg.Auth.EmailVerificationURL = getEnv(
+		"EMAIL_VERIFICATION_URL",
+		"http://localhost:8080/api/v1/verify-email",
+	)
+
+	emailVerificationResendInterval, err := strconv.Atoi(
+		getEnv(
+			"EMAIL_VERIFICATION_RESEND_INTERVAL_SECONDS",
+			"60",
+		),
+	)
+	if err != nil || emailVerificationResendInterval < 1 {
+		return cfg, errors.New("invalid EMAIL_VERIFICATION_RESEND_INTERVAL_SECONDS")
+	}
+	cfg.Auth.EmailVerificationResendInterval = time.Duration(emailVerificationResendInterval) * time.Second
+
+	magicLinkEnabled, err := strconv.ParseBool(
+		getEnv("MAGIC_LINK_ENABLED", "false"),
+	)
+	if err != nil {
+		return cfg, errors.New("invalid MAGIC_LINK_ENABLED")
+	}
+	cfg.Auth.MagicLinkEnabled = magicLinkEnabled
+
+	magicLinkExpiration, err := strconv.Atoi(
+		getEnv(
+			"MAGIC_LINK_EXPIRATION_MINUTES",
+			"15",
+		),
+	)
+	if err != nil || magicLinkExpiration < 1 {
+		return cfg, errors.New("invalid MAGIC_LINK_EXPIRATION_MINUTES")
+	}
+	cfg.Auth.MagicLinkExpiration = time.Duration(magicLinkExpiration) * time.Minute
+	cfg.Auth.MagicLinkURL = getEnv(
+		"MAGIC_LINK_URL",
+		"http://localhost:3000/login/magic-link",
+	)
+
+	newDeviceNotifications, err := strconv.ParseBool(
+		getEnv("NEW_DEVICE_NOTIFICATIONS", "true"),
+	)
+	if err != nil {
+		return cfg, errors.New("invalid NEW_DEVICE_NOTIFICATIONS")
+	}
+	cfg.Auth.NewDeviceNotifications = newDeviceNotifications
+
+	for _, email := range strings.Split(getEnv("ADMIN_EMAILS", ""), ",") {
+		if email = strings.TrimSpace(email); email != "" {
+			cfg.Auth.AdminEmails = append(cfg.Auth.AdminEmails, email)
+		}
+	}
+
+	oidcProviders, err := parseOIDCProviders(getEnv("OIDC_PROVIDERS", ""))
+	if err != nil {
+		return cfg, err
+	}
+	cfg.Auth.OIDCProviders = oidcProviders
+
+	// Mail configuration
+	cfg.Mail.Driver = getEnv("MAIL_DRIVER", "log")
+	if cfg.Mail.Driver != "log" && cfg.Mail.Driver != "file" {
+		return cfg, errors.New("invalid MAIL_DRIVER")
+	}
+	cfg.Mail.From = getEnv("MAIL_FROM", "no-reply@localhost")
+	cfg.Mail.FilePath = getEnv("MAIL_FILE_PATH", "mail.log")
+
 	return cfg, nil
 }
 
@@ -114,3 +504,101 @@ func getEnv(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+// isHTTPURL reports whether value is an absolute http or https URL
+func isHTTPURL(value string) bool {
+	u, err := url.Parse(value)
+	return err == nil &&
+		(u.Scheme == "http" || u.Scheme == "https") &&
+		u.Host != ""
+}
+
+// parseVerificationKeys parses a comma separated list of keys in the form
+// "kid:ALGORITHM:source", where source is the secret for HS256 keys and the
+// path of a PEM file for asymmetric keys
+func parseVerificationKeys(value string) ([]SigningKeyConfig, error) {
+	var keys []SigningKeyConfig
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		parts := strings.SplitN(entry, ":", 3)
+		if len(parts) != 3 || parts[0] == "" || parts[2] == "" {
+			return nil, errors.New("invalid JWT_VERIFICATION_KEYS")
+		}
+
+		key := SigningKeyConfig{
+			ID:        parts[0],
+			Algorithm: parts[1],
+		}
+		switch key.Algorithm {
+		case "HS256":
+			key.Secret = parts[2]
+		case "RS256", "ES256", "EdDSA":
+			key.KeyFile = parts[2]
+		default:
+			return nil, errors.New("invalid JWT_VERIFICATION_KEYS algorithm")
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// parseOIDCProviders reads the providers named in a comma separated list.
+// Each provider is configured by OIDC_<NAME>_* variables, where NAME is the
+// upper-cased name with dashes replaced by underscores.
+func parseOIDCProviders(value string) ([]OIDCProviderConfig, error) {
+	var providers []OIDCProviderConfig
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		for _, r := range name {
+			if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-') {
+				return nil, errors.New("invalid OIDC_PROVIDERS name " + name)
+			}
+		}
+
+		prefix := "OIDC_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+		provider := OIDCProviderConfig{
+			Name:         name,
+			Issuer:       strings.TrimSuffix(getEnv(prefix+"ISSUER", ""), "/"),
+			ClientID:     getEnv(prefix+"CLIENT_ID", ""),
+			ClientSecret: getEnv(prefix+"CLIENT_SECRET", ""),
+			Scopes:       strings.Fields(getEnv(prefix+"SCOPES", "openid email profile")),
+		}
+		if provider.Issuer == "" {
+			return nil, errors.New(prefix + "ISSUER is required")
+		}
+		if provider.ClientID == "" {
+			return nil, errors.New(prefix + "CLIENT_ID is required")
+		}
+		hasOpenID := false
+		for _, scope := range provider.Scopes {
+			hasOpenID = hasOpenID || scope == "openid"
+		}
+		if !hasOpenID {
+			return nil, errors.New(prefix + "SCOPES must include openid")
+		}
+
+		createUsers, err := strconv.ParseBool(getEnv(prefix+"CREATE_USERS", "true"))
+		if err != nil {
+			return nil, errors.New("invalid " + prefix + "CREATE_USERS")
+		}
+		provider.CreateUsers = createUsers
+
+		linkExistingUsers, err := strconv.ParseBool(getEnv(prefix+"LINK_EXISTING_USERS", "false"))
+		if err != nil {
+			return nil, errors.New("invalid " + prefix + "LINK_EXISTING_USERS")
+		}
+		provider.LinkExistingUsers = linkExistingUsers
+
+		providers = append(providers, provider)
+	}
+
+	return providers, nil
+}
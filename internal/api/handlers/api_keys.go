@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/EngenMe/go-api-dod/internal/api/middleware"
+	"github.com/EngenMe/go-api-dod/internal/data/models"
+	"github.com/EngenMe/go-api-dod/internal/data/store"
+	"github.com/EngenMe/go-api-dod/internal/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// APIKeyHandler provides handlers for managing the API keys of the
+// authenticated user
+type APIKeyHandler struct {
+	APIKeyStore *store.APIKeyStore
+}
+
+// NewAPIKeyHandler creates a new APIKeyHandler
+func NewAPIKeyHandler(apiKeyStore *store.APIKeyStore) *APIKeyHandler {
+	return &APIKeyHandler{
+		APIKeyStore: apiKeyStore,
+	}
+}
+
+// apiKeyResponse builds the JSON representation of an API key, which never
+// includes the key itself
+func apiKeyResponse(apiKey *models.APIKey) gin.H {
+	return gin.H{
+		"id":           apiKey.ID,
+		"name":         apiKey.Name,
+		"prefix":       apiKey.Prefix,
+		"scope":        apiKey.Scope,
+		"expires_at":   apiKey.ExpiresAt,
+		"last_used_at": apiKey.LastUsedAt,
+		"created_at":   apiKey.CreatedAt,
+	}
+}
+
+// CreateAPIKey handles issuing a new API key. The key is only returned once.
+func (h *APIKeyHandler) CreateAPIKey(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	// Parse request body
+	var req struct {
+		Name          string `json:"name" binding:"required,max=100"`
+		Scope         string `json:"scope"`
+		ExpiresInDays int    `json:"expires_in_days" binding:"min=0"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// A key never gets more than the session creating it
+	var available []string
+	for _, scope := range middleware.Scopes(c) {
+		if utils.HasScope(utils.MachineScopes, scope) {
+			available = append(available, scope)
+		}
+	}
+	scopes, err := utils.NarrowScope(req.Scope, available)
+	if err != nil || len(scopes) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid scope"})
+		return
+	}
+
+	key, prefix, err := utils.GenerateAPIKey()
+	if err != nil {
+		c.JSON(
+			http.StatusInternalServerError,
+			gin.H{"error": "Failed to generate API key"},
+		)
+		return
+	}
+
+	apiKey := &models.APIKey{
+		UserID: userID,
+		Name:   req.Name,
+		Prefix: prefix,
+		Key:    key,
+		Scope:  utils.FormatScope(scopes),
+	}
+	if req.ExpiresInDays > 0 {
+		expiresAt := time.Now().AddDate(0, 0, req.ExpiresInDays)
+		apiKey.ExpiresAt = &expiresAt
+	}
+
+	if err := h.APIKeyStore.Create(apiKey); err != nil {
+		c.JSON(
+			http.StatusInternalServerError,
+			gin.H{"error": "Failed to create API key"},
+		)
+		return
+	}
+
+	response := apiKeyResponse(apiKey)
+	response["key"] = apiKey.Key
+	c.JSON(http.StatusCreated, response)
+}
+
+// ListAPIKeys handles listing the unrevoked API keys of the user
+func (h *APIKeyHandler) ListAPIKeys(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	apiKeys, err := h.APIKeyStore.GetByUserID(userID)
+	if err != nil {
+		c.JSON(
+			http.StatusInternalServerError,
+			gin.H{"error": "Failed to list API keys"},
+		)
+		return
+	}
+
+	response := []gin.H{}
+	for i := range apiKeys {
+		response = append(response, apiKeyResponse(&apiKeys[i]))
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// RevokeAPIKey handles revoking one API key of the user
+func (h *APIKeyHandler) RevokeAPIKey(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	// Parse API key ID from URL
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid API key ID"})
+		return
+	}
+
+	// Keys of other users are reported as missing
+	apiKey, err := h.APIKeyStore.GetByID(id)
+	if err != nil {
+		c.JSON(
+			http.StatusInternalServerError,
+			gin.H{"error": "Failed to get API key"},
+		)
+		return
+	}
+	if apiKey == nil || apiKey.UserID != userID || apiKey.RevokedAt != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+		return
+	}
+
+	if err := h.APIKeyStore.Revoke(apiKey.ID); err != nil {
+		c.JSON(
+			http.StatusInternalServerError,
+			gin.H{"error": "Failed to revoke API key"},
+		)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "API key revoked successfully"})
+}
@@ -1,45 +1,224 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
+	"log"
 	"net/http"
 	"time"
 
+	"github.com/EngenMe/go-api-dod/config"
+	"github.com/EngenMe/go-api-dod/internal/api/middleware"
 	"github.com/EngenMe/go-api-dod/internal/data/models"
 	"github.com/EngenMe/go-api-dod/internal/data/store"
+	"github.com/EngenMe/go-api-dod/internal/mail"
 	"github.com/EngenMe/go-api-dod/internal/utils"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 )
 
 // AuthHandler provides handlers for authentication
 type AuthHandler struct {
-	UserStore         *store.UserStore
-	RefreshTokenStore *store.RefreshTokenStore
-	PasswordHasher    *utils.PasswordHasher
-	TokenManager      *utils.TokenManager
+	Config               config.AuthConfig
+	UserStore            *store.UserStore
+	RefreshTokenStore    *store.RefreshTokenStore
+	UserTokenStore       *store.UserTokenStore
+	MFARecoveryCodeStore *store.MFARecoveryCodeStore
+	SecurityEventStore   *store.SecurityEventStore
+	RevokedTokenStore    *store.RevokedTokenStore
+	LoginEventStore      *store.LoginEventStore
+	APIKeyStore          *store.APIKeyStore
+	PasswordHasher       *utils.PasswordHasher
+	PasswordPolicy       *utils.PasswordPolicy
+	TokenManager         *utils.TokenManager
+	Mailer               mail.Mailer
+	ResendLimiter        *utils.RateLimiter
+	LoginFailureLimiter  *utils.RateLimiter
 }
 
 // NewAuthHandler creates a new AuthHandler
 func NewAuthHandler(
+	cfg config.AuthConfig,
 	userStore *store.UserStore,
 	refreshTokenStore *store.RefreshTokenStore,
+	userTokenStore *store.UserTokenStore,
+	mfaRecoveryCodeStore *store.MFARecoveryCodeStore,
+	securityEventStore *store.SecurityEventStore,
+	revokedTokenStore *store.RevokedTokenStore,
+	loginEventStore *store.LoginEventStore,
+	apiKeyStore *store.APIKeyStore,
 	passwordHasher *utils.PasswordHasher,
+	passwordPolicy *utils.PasswordPolicy,
 	tokenManager *utils.TokenManager,
+	mailer mail.Mailer,
 ) *AuthHandler {
 	return &AuthHandler{
-		UserStore:         userStore,
-		RefreshTokenStore: refreshTokenStore,
-		PasswordHasher:    passwordHasher,
-		TokenManager:      tokenManager,
+		Config:               cfg,
+		UserStore:            userStore,
+		RefreshTokenStore:    refreshTokenStore,
+		UserTokenStore:       userTokenStore,
+		MFARecoveryCodeStore: mfaRecoveryCodeStore,
+		SecurityEventStore:   securityEventStore,
+		RevokedTokenStore:    revokedTokenStore,
+		LoginEventStore:      loginEventStore,
+		APIKeyStore:          apiKeyStore,
+		PasswordHasher:       passwordHasher,
+		PasswordPolicy:       passwordPolicy,
+		TokenManager:         tokenManager,
+		Mailer:               mailer,
+		ResendLimiter: utils.NewRateLimiter(
+			1,
+			cfg.EmailVerificationResendInterval,
+		),
+		LoginFailureLimiter: utils.NewRateLimiter(
+			cfg.IPLoginFailureLimit,
+			cfg.IPLoginFailureWindow,
+		),
 	}
 }
 
+// tokenPair holds a freshly issued access and refresh token
+type tokenPair struct {
+	AccessToken  string
+	RefreshToken string
+	Scopes       []string
+}
+
+// maxUserAgentLength is the longest user agent stored with a session
+const maxUserAgentLength = 512
+
+// clientUserAgent returns the user agent of the request, cut to the length
+// that is stored
+func clientUserAgent(c *gin.Context) string {
+	userAgent := c.Request.UserAgent()
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = userAgent[:maxUserAgentLength]
+	}
+	return userAgent
+}
+
+// issueTokens generates an access/refresh token pair limited to the scopes
+// and stores the refresh token along with the client that requested it.
+// clientID names the OAuth client the tokens are issued to, if any. When
+// parent is set, the parent is rotated and the new refresh token joins its
+// family; otherwise a new session started by method begins.
+func (h *AuthHandler) issueTokens(
+	c *gin.Context,
+	user *models.User,
+	scopes []string,
+	method models.LoginMethod,
+	clientID string,
+	parent *models.RefreshToken,
+) (*tokenPair, error) {
+	// The access token names the session, the family of the refresh token
+	refreshToken := &models.RefreshToken{
+		ID:          uuid.New(),
+		UserID:      user.ID,
+		UserAgent:   clientUserAgent(c),
+		IPAddress:   c.ClientIP(),
+		CreatedFrom: method,
+		ClientID:    clientID,
+		ExpiresAt:   time.Now().Add(h.TokenManager.RefreshTokenExpiresIn),
+	}
+	refreshToken.FamilyID = refreshToken.ID
+	if parent != nil {
+		refreshToken.FamilyID = parent.FamilyID
+	}
+
+	// Generate an access token
+	accessToken, err := h.TokenManager.GenerateAccessToken(
+		user.ID,
+		user.Email,
+		string(user.Role),
+		scopes,
+		clientID,
+		refreshToken.FamilyID.String(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate access token: %w", err)
+	}
+
+	// Generate refresh token
+	refreshTokenString, err := h.TokenManager.GenerateRefreshToken(
+		user.ID,
+		user.Email,
+		scopes,
+		clientID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate refresh token: %w", err)
+	}
+
+	// Store refresh token in database
+	refreshToken.Token = refreshTokenString
+	if parent != nil {
+		err = h.RefreshTokenStore.Rotate(parent, refreshToken)
+	} else {
+		err = h.RefreshTokenStore.Create(refreshToken)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &tokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshTokenString,
+		Scopes:       scopes,
+	}, nil
+}
+
+// tokenResponse builds the JSON body returned for an issued token pair
+func (h *AuthHandler) tokenResponse(tokens *tokenPair) gin.H {
+	return gin.H{
+		"access_token":  tokens.AccessToken,
+		"refresh_token": tokens.RefreshToken,
+		"token_type":    "Bearer",
+		"expires_in":    int(h.TokenManager.AccessTokenExpiresIn.Seconds()),
+		"scope":         utils.FormatScope(tokens.Scopes),
+	}
+}
+
+// handleRefreshTokenReuse revokes the whole family of a stolen refresh token
+// and records the incident
+func (h *AuthHandler) handleRefreshTokenReuse(
+	c *gin.Context,
+	token *models.RefreshToken,
+) {
+	if err := h.RefreshTokenStore.RevokeFamily(token.FamilyID); err != nil {
+		log.Printf(
+			"[SECURITY] failed to revoke refresh token family %s: %v",
+			token.FamilyID, err,
+		)
+	}
+
+	userID := token.UserID
+	event := &models.SecurityEvent{
+		UserID:    &userID,
+		Type:      models.SecurityEventRefreshTokenReuse,
+		IPAddress: c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+		Details: fmt.Sprintf(
+			"token %s of family %s presented after rotation",
+			token.ID, token.FamilyID,
+		),
+	}
+	if err := h.SecurityEventStore.Create(event); err != nil {
+		log.Printf("[SECURITY] failed to record security event: %v", err)
+	}
+
+	log.Printf(
+		"[SECURITY] %s: user=%s family=%s ip=%s",
+		event.Type, token.UserID, token.FamilyID, event.IPAddress,
+	)
+}
+
 // Signup handles user registration
 func (h *AuthHandler) Signup(c *gin.Context) {
 	// Parse request body
 	var req struct {
 		Email    string `json:"email" binding:"required,email"`
-		Password string `json:"password" binding:"required,min=8"`
+		Password string `json:"password" binding:"required"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -47,6 +226,10 @@ func (h *AuthHandler) Signup(c *gin.Context) {
 		return
 	}
 
+	if rejectWeakPassword(c, h.PasswordPolicy, req.Password, req.Email) {
+		return
+	}
+
 	// Check if a user already exists
 	existingUser, err := h.UserStore.GetByEmail(req.Email)
 	if err != nil {
@@ -88,56 +271,28 @@ func (h *AuthHandler) Signup(c *gin.Context) {
 		return
 	}
 
-	// Generate an access token
-	accessToken, err := h.TokenManager.GenerateAccessToken(user.ID, user.Email)
-	if err != nil {
-		c.JSON(
-			http.StatusInternalServerError,
-			gin.H{"error": "Failed to generate access token"},
-		)
-		return
-	}
-
-	// Generate refresh token
-	refreshTokenString, err := h.TokenManager.GenerateRefreshToken(
-		user.ID,
-		user.Email,
-	)
-	if err != nil {
-		c.JSON(
-			http.StatusInternalServerError,
-			gin.H{"error": "Failed to generate refresh token"},
-		)
-		return
+	// Ask the user to confirm their email address
+	if err := h.sendVerificationEmail(&user); err != nil {
+		log.Printf("failed to send verification email: %v", err)
 	}
 
-	// Store refresh token in database
-	expiresAt := time.Now().Add(h.TokenManager.RefreshTokenExpiresIn)
-	refreshToken := &models.RefreshToken{
-		UserID:    user.ID,
-		Token:     refreshTokenString,
-		ExpiresAt: expiresAt,
-	}
-	if err := h.RefreshTokenStore.Create(refreshToken); err != nil {
+	// Unverified accounts cannot log in, so don't hand out tokens either
+	if h.Config.RequireEmailVerification {
 		c.JSON(
-			http.StatusInternalServerError,
-			gin.H{"error": "Failed to store refresh token"},
+			http.StatusCreated, gin.H{
+				"message": "Please verify your email address before logging in",
+				"user":    userResponse(&user),
+			},
 		)
 		return
 	}
 
-	// Return tokens
-	c.JSON(
-		http.StatusCreated, gin.H{
-			"access_token":  accessToken,
-			"refresh_token": refreshTokenString,
-			"token_type":    "Bearer",
-			"expires_in":    int(h.TokenManager.AccessTokenExpiresIn.Seconds()),
-			"user": gin.H{
-				"id":    user.ID,
-				"email": user.Email,
-			},
-		},
+	h.respondWithTokens(
+		c,
+		&user,
+		utils.DefaultScopes,
+		models.LoginMethodPassword,
+		http.StatusCreated,
 	)
 }
 
@@ -147,6 +302,7 @@ func (h *AuthHandler) Login(c *gin.Context) {
 	var req struct {
 		Email    string `json:"email" binding:"required,email"`
 		Password string `json:"password" binding:"required"`
+		Scope    string `json:"scope"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -154,6 +310,16 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		return
 	}
 
+	scopes, err := utils.NarrowScope(req.Scope, utils.DefaultScopes)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid scope"})
+		return
+	}
+
+	if h.rejectThrottledIP(c) {
+		return
+	}
+
 	// Get user
 	user, err := h.UserStore.GetByEmail(req.Email)
 	if err != nil {
@@ -164,6 +330,7 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		return
 	}
 	if user == nil {
+		h.recordLoginFailure(c, nil)
 		c.JSON(
 			http.StatusUnauthorized,
 			gin.H{"error": "Invalid email or password"},
@@ -171,8 +338,25 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		return
 	}
 
+	if h.rejectLockedUser(c, user) {
+		h.recordLoginEvent(
+			c,
+			user,
+			models.LoginMethodPassword,
+			models.LoginFailureAccountLocked,
+		)
+		return
+	}
+
 	// Check password
 	if !h.PasswordHasher.Check(req.Password, user.Password) {
+		h.recordLoginFailure(c, user)
+		h.recordLoginEvent(
+			c,
+			user,
+			models.LoginMethodPassword,
+			models.LoginFailureInvalidPassword,
+		)
 		c.JSON(
 			http.StatusUnauthorized,
 			gin.H{"error": "Invalid email or password"},
@@ -180,57 +364,186 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		return
 	}
 
-	// Generate an access token
-	accessToken, err := h.TokenManager.GenerateAccessToken(user.ID, user.Email)
-	if err != nil {
+	// Upgrade hashes made with a legacy algorithm or outdated cost
+	if h.PasswordHasher.NeedsRehash(user.Password) {
+		h.rehashPassword(user, req.Password)
+	}
+
+	if h.Config.RequireEmailVerification && !user.IsEmailVerified() {
+		h.recordLoginEvent(
+			c,
+			user,
+			models.LoginMethodPassword,
+			models.LoginFailureEmailNotVerified,
+		)
 		c.JSON(
-			http.StatusInternalServerError,
-			gin.H{"error": "Failed to generate access token"},
+			http.StatusForbidden,
+			gin.H{"error": "Email address not verified"},
 		)
 		return
 	}
 
-	// Generate refresh token
-	refreshTokenString, err := h.TokenManager.GenerateRefreshToken(
+	h.completeLogin(c, user, scopes, models.LoginMethodPassword)
+}
+
+// rehashPassword stores a fresh hash of a verified password. Failures are
+// only logged since the login itself succeeded.
+func (h *AuthHandler) rehashPassword(user *models.User, password string) {
+	hashedPassword, err := h.PasswordHasher.Hash(password)
+	if err != nil {
+		log.Printf("failed to rehash password: %v", err)
+		return
+	}
+
+	user.Password = hashedPassword
+	if err := h.UserStore.UpdatePassword(user.ID, hashedPassword); err != nil {
+		log.Printf("failed to store rehashed password: %v", err)
+	}
+}
+
+// completeLogin finishes a login whose first factor, checked by method,
+// succeeded. Users with MFA get a challenge token to redeem at /login/mfa,
+// everybody else gets tokens limited to the requested scopes.
+func (h *AuthHandler) completeLogin(
+	c *gin.Context,
+	user *models.User,
+	scopes []string,
+	method models.LoginMethod,
+) {
+	// Failures only reset once every factor succeeded
+	if !user.IsMFAEnabled() {
+		h.recordLoginSuccess(user)
+		h.recordLoginEvent(c, user, method, "")
+		h.respondWithTokens(c, user, scopes, method, http.StatusOK)
+		return
+	}
+
+	mfaToken, err := h.TokenManager.GenerateMFAToken(
 		user.ID,
 		user.Email,
+		scopes,
+		string(method),
 	)
 	if err != nil {
 		c.JSON(
 			http.StatusInternalServerError,
-			gin.H{"error": "Failed to generate refresh token"},
+			gin.H{"error": "Failed to generate MFA token"},
 		)
 		return
 	}
 
-	// Store refresh token in database
-	expiresAt := time.Now().Add(h.TokenManager.RefreshTokenExpiresIn)
-	refreshToken := &models.RefreshToken{
-		UserID:    user.ID,
-		Token:     refreshTokenString,
-		ExpiresAt: expiresAt,
-	}
-	if err := h.RefreshTokenStore.Create(refreshToken); err != nil {
+	c.JSON(
+		http.StatusOK, gin.H{
+			"mfa_required": true,
+			"mfa_token":    mfaToken,
+			"expires_in":   int(h.TokenManager.MFATokenExpiresIn.Seconds()),
+		},
+	)
+}
+
+// respondWithTokens starts a new session for the user and returns its tokens
+func (h *AuthHandler) respondWithTokens(
+	c *gin.Context,
+	user *models.User,
+	scopes []string,
+	method models.LoginMethod,
+	status int,
+) {
+	// Generate tokens
+	tokens, err := h.issueTokens(c, user, scopes, method, "", nil)
+	if err != nil {
 		c.JSON(
 			http.StatusInternalServerError,
-			gin.H{"error": "Failed to store refresh token"},
+			gin.H{"error": "Failed to issue tokens"},
 		)
 		return
 	}
 
 	// Return tokens
-	c.JSON(
-		http.StatusOK, gin.H{
-			"access_token":  accessToken,
-			"refresh_token": refreshTokenString,
-			"token_type":    "Bearer",
-			"expires_in":    int(h.TokenManager.AccessTokenExpiresIn.Seconds()),
-			"user": gin.H{
-				"id":    user.ID,
-				"email": user.Email,
-			},
-		},
+	response := h.tokenResponse(tokens)
+	response["user"] = userResponse(user)
+	c.JSON(status, response)
+}
+
+// userResponse builds the user summary returned alongside tokens
+func userResponse(user *models.User) gin.H {
+	return gin.H{
+		"id":             user.ID,
+		"email":          user.Email,
+		"role":           user.Role,
+		"email_verified": user.IsEmailVerified(),
+		"mfa_enabled":    user.IsMFAEnabled(),
+	}
+}
+
+// Errors returned by refresh, mapped to responses by its callers
+var (
+	errMalformedRefreshToken = errors.New("invalid refresh token")
+	errInvalidRefreshToken   = errors.New("invalid or expired refresh token")
+	errRefreshTokenReused    = errors.New("refresh token reuse detected")
+	errInvalidScope          = errors.New("invalid scope")
+)
+
+// refresh rotates a refresh token into a new token pair, optionally narrowed
+// to the requested scope
+func (h *AuthHandler) refresh(
+	c *gin.Context,
+	refreshToken, scope string,
+) (*tokenPair, error) {
+	// Validate refresh token
+	claims, err := h.TokenManager.ValidateRefreshToken(refreshToken)
+	if err != nil {
+		return nil, errMalformedRefreshToken
+	}
+
+	// A refresh may narrow the granted scopes but never widen them
+	scopes, err := utils.NarrowScope(scope, claims.Scopes())
+	if err != nil {
+		return nil, errInvalidScope
+	}
+
+	// Get refresh token from database
+	storedToken, err := h.RefreshTokenStore.GetByToken(refreshToken)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get refresh token: %w", err)
+	}
+	if storedToken == nil || storedToken.UserID != claims.UserID {
+		return nil, errInvalidRefreshToken
+	}
+
+	// A rotated token must never be presented again. Tokens revoked by a
+	// logout are merely invalid and fall through to the check below.
+	if storedToken.IsRotated() {
+		h.handleRefreshTokenReuse(c, storedToken)
+		return nil, errRefreshTokenReused
+	}
+	if !storedToken.IsValid() {
+		return nil, errInvalidRefreshToken
+	}
+
+	// Reload the user so the new access token reflects their current role
+	user, err := h.UserStore.GetByID(storedToken.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	if user == nil {
+		return nil, errInvalidRefreshToken
+	}
+
+	// Rotate the used refresh token into a new token pair
+	tokens, err := h.issueTokens(
+		c,
+		user,
+		scopes,
+		storedToken.CreatedFrom,
+		claims.AuthorizedParty,
+		storedToken,
 	)
+	if errors.Is(err, store.ErrRefreshTokenRevoked) {
+		// A concurrent logout revoked the token
+		return nil, errInvalidRefreshToken
+	}
+	return tokens, err
 }
 
 // RefreshToken handles token refresh
@@ -238,6 +551,7 @@ func (h *AuthHandler) RefreshToken(c *gin.Context) {
 	// Parse request body
 	var req struct {
 		RefreshToken string `json:"refresh_token" binding:"required"`
+		Scope        string `json:"scope"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -245,9 +559,10 @@ func (h *AuthHandler) RefreshToken(c *gin.Context) {
 		return
 	}
 
-	// Validate refresh token
+	// Refresh tokens issued to OAuth clients can only be redeemed by their
+	// client, at the token endpoint
 	claims, err := h.TokenManager.ValidateRefreshToken(req.RefreshToken)
-	if err != nil {
+	if err != nil || claims.AuthorizedParty != "" {
 		c.JSON(
 			http.StatusUnauthorized,
 			gin.H{"error": "Invalid refresh token"},
@@ -255,81 +570,132 @@ func (h *AuthHandler) RefreshToken(c *gin.Context) {
 		return
 	}
 
-	// Get refresh token from database
-	storedToken, err := h.RefreshTokenStore.GetByToken(req.RefreshToken)
-	if err != nil {
+	tokens, err := h.refresh(c, req.RefreshToken, req.Scope)
+	switch {
+	case err == nil:
+		c.JSON(http.StatusOK, h.tokenResponse(tokens))
+	case errors.Is(err, errMalformedRefreshToken):
+		c.JSON(
+			http.StatusUnauthorized,
+			gin.H{"error": "Invalid refresh token"},
+		)
+	case errors.Is(err, errInvalidScope):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid scope"})
+	case errors.Is(err, errInvalidRefreshToken):
+		c.JSON(
+			http.StatusUnauthorized,
+			gin.H{"error": "Invalid or expired refresh token"},
+		)
+	case errors.Is(err, errRefreshTokenReused):
+		c.JSON(
+			http.StatusUnauthorized,
+			gin.H{"error": "Refresh token reuse detected, all sessions of this login have been revoked"},
+		)
+	case errors.Is(err, store.ErrRefreshTokenRotated):
+		// A concurrent refresh with the same token won the race. The token
+		// was still valid when it was read, so this is no sign of theft.
+		c.JSON(
+			http.StatusConflict,
+			gin.H{"error": "Refresh token already used by a concurrent request"},
+		)
+	default:
 		c.JSON(
 			http.StatusInternalServerError,
-			gin.H{"error": "Failed to get refresh token"},
+			gin.H{"error": "Failed to refresh tokens"},
 		)
+	}
+}
+
+// Logout handles revoking the presented refresh token of the caller
+func (h *AuthHandler) Logout(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
 		return
 	}
 
-	if storedToken == nil || !storedToken.IsValid() {
+	// Parse request body
+	var req struct {
+		RefreshToken string `json:"refresh_token" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Validate refresh token
+	if _, err := h.TokenManager.ValidateRefreshToken(req.RefreshToken); err != nil {
 		c.JSON(
 			http.StatusUnauthorized,
-			gin.H{"error": "Invalid or expired refresh token"},
+			gin.H{"error": "Invalid refresh token"},
 		)
 		return
 	}
 
-	// Revoke the used refresh token
-	if err := h.RefreshTokenStore.Revoke(storedToken.ID); err != nil {
+	// Get refresh token from database
+	storedToken, err := h.RefreshTokenStore.GetByToken(req.RefreshToken)
+	if err != nil {
 		c.JSON(
 			http.StatusInternalServerError,
-			gin.H{"error": "Failed to revoke refresh token"},
+			gin.H{"error": "Failed to get refresh token"},
 		)
 		return
 	}
 
-	// Generate a new access token
-	accessToken, err := h.TokenManager.GenerateAccessToken(
-		claims.UserID,
-		claims.Email,
-	)
-	if err != nil {
+	// Tokens of other users are reported as missing to avoid leaking them
+	if storedToken == nil ||
+		storedToken.UserID != userID ||
+		storedToken.RevokedAt != nil {
 		c.JSON(
-			http.StatusInternalServerError,
-			gin.H{"error": "Failed to generate access token"},
+			http.StatusNotFound,
+			gin.H{"error": "Refresh token not found"},
 		)
 		return
 	}
 
-	// Generate a new refresh token
-	refreshTokenString, err := h.TokenManager.GenerateRefreshToken(
-		claims.UserID,
-		claims.Email,
-	)
-	if err != nil {
+	// Revoke the refresh token
+	if err := h.RefreshTokenStore.Revoke(storedToken.ID); err != nil {
 		c.JSON(
 			http.StatusInternalServerError,
-			gin.H{"error": "Failed to generate refresh token"},
+			gin.H{"error": "Failed to revoke refresh token"},
 		)
 		return
 	}
 
-	// Store a new refresh token in a database
-	expiresAt := time.Now().Add(h.TokenManager.RefreshTokenExpiresIn)
-	refreshToken := &models.RefreshToken{
-		UserID:    claims.UserID,
-		Token:     refreshTokenString,
-		ExpiresAt: expiresAt,
+	// Revoke the access token of the request as well
+	if claims := middleware.Claims(c); claims != nil && claims.ID != "" {
+		err := h.RevokedTokenStore.Revoke(claims.ID, claims.ExpiresAt.Time)
+		if err != nil {
+			c.JSON(
+				http.StatusInternalServerError,
+				gin.H{"error": "Failed to revoke access token"},
+			)
+			return
+		}
 	}
-	if err := h.RefreshTokenStore.Create(refreshToken); err != nil {
+
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out successfully"})
+}
+
+// LogoutAll handles revoking every refresh token of the caller
+func (h *AuthHandler) LogoutAll(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	if err := h.RefreshTokenStore.RevokeAllForUser(userID); err != nil {
 		c.JSON(
 			http.StatusInternalServerError,
-			gin.H{"error": "Failed to store refresh token"},
+			gin.H{"error": "Failed to revoke refresh tokens"},
 		)
 		return
 	}
 
-	// Return new tokens
 	c.JSON(
-		http.StatusOK, gin.H{
-			"access_token":  accessToken,
-			"refresh_token": refreshTokenString,
-			"token_type":    "Bearer",
-			"expires_in":    int(h.TokenManager.AccessTokenExpiresIn.Seconds()),
-		},
+		http.StatusOK,
+		gin.H{"message": "Logged out of all sessions successfully"},
 	)
 }
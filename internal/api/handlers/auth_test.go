@@ -0,0 +1,374 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/EngenMe/go-api-dod/config"
+	"github.com/EngenMe/go-api-dod/internal/data/store"
+	"github.com/EngenMe/go-api-dod/internal/utils"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// authFixture is an AuthHandler wired for the token endpoints
+type authFixture struct {
+	handler *AuthHandler
+	mock    sqlmock.Sqlmock
+	userID  uuid.UUID
+	router  *gin.Engine
+}
+
+func newAuthFixture(t *testing.T) *authFixture {
+	db, mock := newTestDB(t)
+	tokenHasher := utils.NewTokenHasher("pepper")
+	revokedTokenStore := store.NewRevokedTokenStore(db, time.Minute, 15*time.Minute)
+	f := &authFixture{
+		handler: &AuthHandler{
+			UserStore: store.NewUserStore(db, newTestSecretBox(t), revokedTokenStore),
+			RefreshTokenStore: store.NewRefreshTokenStore(
+				db,
+				tokenHasher,
+				revokedTokenStore,
+			),
+			MFARecoveryCodeStore: store.NewMFARecoveryCodeStore(
+				db,
+				tokenHasher,
+			),
+			SecurityEventStore: store.NewSecurityEventStore(db),
+			RevokedTokenStore:  revokedTokenStore,
+			LoginEventStore:    store.NewLoginEventStore(db),
+			PasswordHasher: utils.NewPasswordHasher(
+				utils.AlgorithmBcrypt,
+				4,
+				utils.Argon2Params{},
+			),
+			TokenManager:        newTestTokenManager(t),
+			LoginFailureLimiter: utils.NewRateLimiter(10, time.Minute),
+			Config: config.AuthConfig{
+				LockoutThreshold:       5,
+				ReauthenticationWindow: 5 * time.Minute,
+			},
+		},
+		mock:   mock,
+		userID: uuid.New(),
+		router: gin.New(),
+	}
+
+	claims := &utils.Claims{
+		UserID:    f.userID,
+		Email:     "user@example.com",
+		Role:      "user",
+		TokenType: utils.AccessToken,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.NewString(),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+		},
+	}
+	authorized := f.router.Group("/", authenticateAs(claims))
+	authorized.POST("/logout", f.handler.Logout)
+	authorized.POST("/logout/all", f.handler.LogoutAll)
+	f.router.POST("/refresh", f.handler.RefreshToken)
+	f.router.POST("/login/mfa", f.handler.LoginMFA)
+	f.router.POST("/anonymous/logout", f.handler.Logout)
+	f.router.POST("/anonymous/logout/all", f.handler.LogoutAll)
+
+	return f
+}
+
+// refreshToken issues a refresh token JWT for the user
+func (f *authFixture) refreshToken(t *testing.T, userID uuid.UUID) string {
+	token, err := f.handler.TokenManager.GenerateRefreshToken(
+		userID,
+		"user@example.com",
+		utils.DefaultScopes,
+		"",
+	)
+	if err != nil {
+		t.Fatalf("failed to generate refresh token: %v", err)
+	}
+	return token
+}
+
+// expectStoredToken answers the refresh token lookup with the given row, or
+// with no row at all when rows is nil
+func (f *authFixture) expectStoredToken(rows *sqlmock.Rows) {
+	if rows == nil {
+		rows = sqlmock.NewRows([]string{"id"})
+	}
+	f.mock.ExpectQuery(`FROM refresh_tokens\s+WHERE token_hash = \$1`).
+		WillReturnRows(rows)
+}
+
+func TestLogoutRequiresAuthentication(t *testing.T) {
+	f := newAuthFixture(t)
+
+	for _, path := range []string{"/anonymous/logout", "/anonymous/logout/all"} {
+		w, _ := performRequest(
+			t, f.router, http.MethodPost, path,
+			gin.H{"refresh_token": f.refreshToken(t, f.userID)},
+		)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("POST %s = %d, want 401", path, w.Code)
+		}
+	}
+}
+
+func TestLogoutRejectsInvalidRefreshToken(t *testing.T) {
+	f := newAuthFixture(t)
+
+	w, body := performRequest(
+		t, f.router, http.MethodPost, "/logout",
+		gin.H{"refresh_token": "not-a-jwt"},
+	)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want 401", w.Code)
+	}
+	if body["error"] != "Invalid refresh token" {
+		t.Errorf("error = %v", body["error"])
+	}
+}
+
+func TestLogoutRequiresRefreshToken(t *testing.T) {
+	f := newAuthFixture(t)
+
+	w, _ := performRequest(t, f.router, http.MethodPost, "/logout", gin.H{})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400", w.Code)
+	}
+}
+
+func TestLogoutReportsUnusableTokensAsNotFound(t *testing.T) {
+	tests := []struct {
+		name string
+		rows func(f *authFixture) *sqlmock.Rows
+	}{
+		{
+			name: "unknown token",
+			rows: func(f *authFixture) *sqlmock.Rows { return nil },
+		},
+		{
+			name: "token of another user",
+			rows: func(f *authFixture) *sqlmock.Rows {
+				return sqlmock.NewRows([]string{"id", "user_id"}).
+					AddRow(uuid.New(), uuid.New())
+			},
+		},
+		{
+			name: "already revoked token",
+			rows: func(f *authFixture) *sqlmock.Rows {
+				return sqlmock.NewRows([]string{"id", "user_id", "revoked_at"}).
+					AddRow(uuid.New(), f.userID, time.Now())
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				f := newAuthFixture(t)
+				f.expectStoredToken(tt.rows(f))
+
+				w, body := performRequest(
+					t, f.router, http.MethodPost, "/logout",
+					gin.H{"refresh_token": f.refreshToken(t, f.userID)},
+				)
+				if w.Code != http.StatusNotFound {
+					t.Fatalf("status = %d, want 404", w.Code)
+				}
+				if body["error"] != "Refresh token not found" {
+					t.Errorf("error = %v", body["error"])
+				}
+			},
+		)
+	}
+}
+
+func TestLogoutRevokesRefreshAndAccessToken(t *testing.T) {
+	f := newAuthFixture(t)
+	tokenID := uuid.New()
+	f.expectStoredToken(
+		sqlmock.NewRows([]string{"id", "user_id"}).AddRow(tokenID, f.userID),
+	)
+	f.mock.ExpectExec(`UPDATE refresh_tokens\s+SET revoked_at`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), tokenID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	f.mock.ExpectExec(`INSERT INTO revoked_tokens`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	w, body := performRequest(
+		t, f.router, http.MethodPost, "/logout",
+		gin.H{"refresh_token": f.refreshToken(t, f.userID)},
+	)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %v", w.Code, body)
+	}
+}
+
+func TestLogoutAllRevokesEverySession(t *testing.T) {
+	f := newAuthFixture(t)
+	f.mock.ExpectBegin()
+	f.mock.ExpectExec(`UPDATE refresh_tokens\s+SET revoked_at .*\s+WHERE user_id = \$3`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), f.userID).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	f.mock.ExpectExec(`UPDATE users\s+SET tokens_valid_after`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	f.mock.ExpectCommit()
+
+	w, body := performRequest(t, f.router, http.MethodPost, "/logout/all", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %v", w.Code, body)
+	}
+}
+
+func TestLogoutAllRevokesAccessTokensRightAway(t *testing.T) {
+	f := newAuthFixture(t)
+	expectEmptyRevocations(f.mock)
+	f.mock.ExpectBegin()
+	f.mock.ExpectExec(`UPDATE refresh_tokens`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	f.mock.ExpectExec(`UPDATE users\s+SET tokens_valid_after`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	f.mock.ExpectCommit()
+
+	isRevoked := func(token string) bool {
+		t.Helper()
+		claims, err := f.handler.TokenManager.ValidateAccessToken(token)
+		if err != nil {
+			t.Fatalf("failed to validate access token: %v", err)
+		}
+		revoked, err := f.handler.RevokedTokenStore.IsRevoked(claims)
+		if err != nil {
+			t.Fatalf("IsRevoked: %v", err)
+		}
+		return revoked
+	}
+	accessToken := func() string {
+		token, err := f.handler.TokenManager.GenerateAccessToken(
+			f.userID, "user@example.com", "user", utils.DefaultScopes, "", "",
+		)
+		if err != nil {
+			t.Fatalf("failed to generate access token: %v", err)
+		}
+		return token
+	}
+
+	before := accessToken()
+	if isRevoked(before) {
+		t.Fatal("token revoked before the logout")
+	}
+	// Token issue times have whole-second precision, so the logout has to
+	// happen in a later second than the token it revokes
+	time.Sleep(time.Until(time.Now().Truncate(time.Second).Add(time.Second)))
+	w, body := performRequest(t, f.router, http.MethodPost, "/logout/all", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %v", w.Code, body)
+	}
+	after := accessToken()
+
+	// The cache is still fresh, so no reload from the database is needed
+	if !isRevoked(before) {
+		t.Error("token issued before the logout is still valid")
+	}
+	if isRevoked(after) {
+		t.Error("token issued after the logout was revoked")
+	}
+}
+
+func TestRefreshWithRevokedTokenLeavesFamilyAlone(t *testing.T) {
+	f := newAuthFixture(t)
+	f.expectStoredToken(
+		sqlmock.NewRows([]string{"id", "user_id", "family_id", "expires_at", "revoked_at"}).
+			AddRow(uuid.New(), f.userID, uuid.New(), time.Now().Add(time.Hour), time.Now()),
+	)
+
+	// Any family revocation would be an unexpected query
+	w, body := performRequest(
+		t, f.router, http.MethodPost, "/refresh",
+		gin.H{"refresh_token": f.refreshToken(t, f.userID)},
+	)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want 401: %v", w.Code, body)
+	}
+	if body["error"] != "Invalid or expired refresh token" {
+		t.Errorf("error = %v", body["error"])
+	}
+}
+
+func TestRefreshRejectsTokensOfOAuthClients(t *testing.T) {
+	f := newAuthFixture(t)
+	token, err := f.handler.TokenManager.GenerateRefreshToken(
+		f.userID,
+		"user@example.com",
+		utils.DefaultScopes,
+		"billing",
+	)
+	if err != nil {
+		t.Fatalf("failed to generate refresh token: %v", err)
+	}
+
+	// Looking up the token would be an unexpected query
+	w, body := performRequest(
+		t, f.router, http.MethodPost, "/refresh",
+		gin.H{"refresh_token": token},
+	)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want 401: %v", w.Code, body)
+	}
+}
+
+func TestRefreshWithRotatedTokenRevokesFamily(t *testing.T) {
+	f := newAuthFixture(t)
+	familyID := uuid.New()
+	now := time.Now()
+	f.expectStoredToken(
+		sqlmock.NewRows([]string{"id", "user_id", "family_id", "revoked_at", "rotated_at"}).
+			AddRow(uuid.New(), f.userID, familyID, now, now),
+	)
+	f.mock.ExpectBegin()
+	f.mock.ExpectExec(`UPDATE refresh_tokens\s+SET revoked_at .*\s+WHERE family_id = \$3`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), familyID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	f.mock.ExpectExec(`INSERT INTO revoked_sessions`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	f.mock.ExpectCommit()
+	f.mock.ExpectExec(`INSERT INTO security_events`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	w, _ := performRequest(
+		t, f.router, http.MethodPost, "/refresh",
+		gin.H{"refresh_token": f.refreshToken(t, f.userID)},
+	)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want 401", w.Code)
+	}
+}
+
+func TestRefreshLosingConcurrentRotationIsNoTheft(t *testing.T) {
+	f := newAuthFixture(t)
+	f.expectStoredToken(
+		sqlmock.NewRows([]string{"id", "user_id", "family_id", "expires_at"}).
+			AddRow(uuid.New(), f.userID, uuid.New(), time.Now().Add(time.Hour)),
+	)
+	f.mock.ExpectQuery(`FROM users`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email", "role"}).
+			AddRow(f.userID, "user@example.com", "user"))
+	f.mock.ExpectBegin()
+	f.mock.ExpectExec(`UPDATE refresh_tokens`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	f.mock.ExpectQuery(`SELECT rotated_at`).
+		WillReturnRows(sqlmock.NewRows([]string{"rotated_at"}).AddRow(time.Now()))
+	f.mock.ExpectRollback()
+
+	w, body := performRequest(
+		t, f.router, http.MethodPost, "/refresh",
+		gin.H{"refresh_token": f.refreshToken(t, f.userID)},
+	)
+	if w.Code != http.StatusConflict {
+		t.Fatalf("status = %d, want 409: %v", w.Code, body)
+	}
+}
@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/EngenMe/go-api-dod/internal/api/middleware"
+	"github.com/EngenMe/go-api-dod/internal/data/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// currentUserID returns the authenticated user's ID set by RequireAuth
+func currentUserID(c *gin.Context) (uuid.UUID, bool) {
+	value, exists := c.Get("userID")
+	if !exists {
+		return uuid.Nil, false
+	}
+
+	userID, ok := value.(uuid.UUID)
+	if !ok || userID == uuid.Nil {
+		return uuid.Nil, false
+	}
+
+	return userID, true
+}
+
+// authorizeUserAccess lets users act on their own record and requires the
+// permission for anybody else's, writing a 403 response if it is missing
+func authorizeUserAccess(
+	c *gin.Context,
+	id uuid.UUID,
+	permission models.Permission,
+) bool {
+	if userID, ok := currentUserID(c); ok && userID == id {
+		return true
+	}
+	if middleware.Can(c, permission) {
+		return true
+	}
+
+	c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+	return false
+}
+
+// isClient reports whether the caller is an OAuth client acting on its own
+// behalf
+func isClient(c *gin.Context) bool {
+	claims := middleware.Claims(c)
+	return claims != nil && claims.ClientID != ""
+}
+
+// rejectClientOnAdmin keeps OAuth clients away from admin accounts, writing a
+// 403 response, so that a leaked client secret cannot take one over
+func rejectClientOnAdmin(c *gin.Context, user *models.User) bool {
+	if !isClient(c) || user.Role == models.RoleUser {
+		return false
+	}
+
+	c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+	return true
+}
+
+// setRetryAfter sets the Retry-After header, rounded up to whole seconds
+func setRetryAfter(c *gin.Context, retryAfter time.Duration) {
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+}
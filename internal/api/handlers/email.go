@@ -0,0 +1,14 @@
+package handlers
+
+import "log"
+
+// sendInBackground sends an email without holding up the response, so that
+// neither mail server latency nor the response time, which would differ for
+// unknown addresses, shows to the client. Failures are only logged.
+func sendInBackground(description string, send func() error) {
+	go func() {
+		if err := send(); err != nil {
+			log.Printf("failed to send %s: %v", description, err)
+		}
+	}()
+}
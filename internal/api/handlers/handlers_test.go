@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/EngenMe/go-api-dod/internal/data/models"
+	"github.com/EngenMe/go-api-dod/internal/utils"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// newTestDB returns a database handle whose queries are answered by the
+// returned mock. Unmet expectations fail the test.
+func newTestDB(t *testing.T) (*gorm.DB, sqlmock.Sqlmock) {
+	t.Helper()
+
+	sqlDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sql mock: %v", err)
+	}
+	db, err := gorm.Open(
+		postgres.New(postgres.Config{Conn: sqlDB}),
+		&gorm.Config{Logger: logger.Default.LogMode(logger.Silent)},
+	)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+
+	t.Cleanup(
+		func() {
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		},
+	)
+	return db, mock
+}
+
+// newTestTokenManager returns a TokenManager signing with a fixed HS256 key
+func newTestTokenManager(t *testing.T) *utils.TokenManager {
+	t.Helper()
+
+	key, err := utils.NewHMACSigningKey("test", strings.Repeat("k", 32))
+	if err != nil {
+		t.Fatalf("failed to create signing key: %v", err)
+	}
+	keyring, err := utils.NewKeyring(key)
+	if err != nil {
+		t.Fatalf("failed to create keyring: %v", err)
+	}
+
+	return utils.NewTokenManager(
+		keyring,
+		"http://localhost:8080",
+		15*time.Minute,
+		24*time.Hour,
+		5*time.Minute,
+	)
+}
+
+// newTestSecretBox returns a SecretBox with a fixed key
+func newTestSecretBox(t *testing.T) *utils.SecretBox {
+	t.Helper()
+
+	secretBox, err := utils.NewSecretBox("test")
+	if err != nil {
+		t.Fatalf("failed to create secret box: %v", err)
+	}
+	return secretBox
+}
+
+// expectEmptyRevocations answers the reload of the revocation cache with no
+// revocations
+func expectEmptyRevocations(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery(`FROM revoked_tokens`).
+		WillReturnRows(sqlmock.NewRows([]string{"jti"}))
+	mock.ExpectQuery(`FROM revoked_sessions`).
+		WillReturnRows(sqlmock.NewRows([]string{"family_id"}))
+	mock.ExpectQuery(`FROM users\s+WHERE tokens_valid_after`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectQuery(`FROM oauth_clients\s+WHERE revoked_at`).
+		WillReturnRows(sqlmock.NewRows([]string{"client_id"}))
+}
+
+// authenticateAs sets the request context the way RequireAuth does for an
+// access token with the claims
+func authenticateAs(claims *utils.Claims) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("claims", claims)
+		c.Set("userID", claims.UserID)
+		c.Set("email", claims.Email)
+		c.Set("role", models.Role(claims.Role))
+		c.Set("scopes", claims.Scopes())
+		c.Next()
+	}
+}
+
+// performRequest sends a JSON request through the router and decodes the
+// JSON response body, if any
+func performRequest(
+	t *testing.T,
+	router http.Handler,
+	method, path string,
+	body interface{},
+) (*httptest.ResponseRecorder, map[string]interface{}) {
+	t.Helper()
+
+	var reader bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&reader).Encode(body); err != nil {
+			t.Fatalf("failed to encode request: %v", err)
+		}
+	}
+	req := httptest.NewRequest(method, path, &reader)
+	req.Header.Set("Content-Type", "application/json")
+	return performHTTPRequest(router, req)
+}
+
+// performHTTPRequest sends the request through the router and decodes the
+// JSON response body, if any
+func performHTTPRequest(
+	router http.Handler,
+	req *http.Request,
+) (*httptest.ResponseRecorder, map[string]interface{}) {
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	var response map[string]interface{}
+	if w.Body.Len() > 0 {
+		_ = json.Unmarshal(w.Body.Bytes(), &response)
+	}
+	return w, response
+}
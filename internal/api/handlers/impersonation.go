@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/EngenMe/go-api-dod/internal/data/models"
+	"github.com/EngenMe/go-api-dod/internal/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Impersonate handles an admin taking on the identity of a user to reproduce
+// their issues. The access token names the admin in its act claim, expires
+// quickly, cannot manage credentials or sessions and comes without a refresh
+// token. Every impersonation is recorded as a security event.
+func (h *AuthHandler) Impersonate(c *gin.Context) {
+	adminID, ok := currentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	adminEmail := c.GetString("email")
+
+	// Parse user ID from URL
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	if id == adminID {
+		c.JSON(
+			http.StatusBadRequest,
+			gin.H{"error": "Cannot impersonate yourself"},
+		)
+		return
+	}
+
+	// Get user
+	user, err := h.UserStore.GetByID(id)
+	if err != nil {
+		c.JSON(
+			http.StatusInternalServerError,
+			gin.H{"error": "Failed to get user"},
+		)
+		return
+	}
+	if user == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	// Admin rights are never passed on through impersonation
+	if user.Role == models.RoleAdmin {
+		c.JSON(
+			http.StatusForbidden,
+			gin.H{"error": "Admins cannot be impersonated"},
+		)
+		return
+	}
+
+	// The audit record must exist before the token does
+	event := &models.SecurityEvent{
+		UserID:    &user.ID,
+		Type:      models.SecurityEventImpersonation,
+		IPAddress: c.ClientIP(),
+		UserAgent: clientUserAgent(c),
+		Details: fmt.Sprintf(
+			"impersonated by admin %s (%s) for %s",
+			adminID, adminEmail, h.Config.ImpersonationExpiration,
+		),
+	}
+	if err := h.SecurityEventStore.Create(event); err != nil {
+		c.JSON(
+			http.StatusInternalServerError,
+			gin.H{"error": "Failed to record impersonation"},
+		)
+		return
+	}
+
+	log.Printf(
+		"[SECURITY] %s: user=%s admin=%s ip=%s",
+		event.Type, user.ID, adminID, event.IPAddress,
+	)
+
+	accessToken, err := h.TokenManager.GenerateImpersonationToken(
+		user.ID,
+		user.Email,
+		string(user.Role),
+		utils.MachineScopes,
+		utils.Actor{Subject: adminID.String(), Email: adminEmail},
+		h.Config.ImpersonationExpiration,
+	)
+	if err != nil {
+		c.JSON(
+			http.StatusInternalServerError,
+			gin.H{"error": "Failed to generate access token"},
+		)
+		return
+	}
+
+	c.JSON(
+		http.StatusOK, gin.H{
+			"access_token": accessToken,
+			"token_type":   "Bearer",
+			"expires_in":   int(h.Config.ImpersonationExpiration.Seconds()),
+			"scope":        utils.FormatScope(utils.MachineScopes),
+			"user":         userResponse(user),
+			"impersonated_by": gin.H{
+				"id":    adminID,
+				"email": adminEmail,
+			},
+		},
+	)
+}
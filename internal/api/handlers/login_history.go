@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/EngenMe/go-api-dod/internal/data/models"
+	"github.com/EngenMe/go-api-dod/internal/mail"
+
+	"github.com/gin-gonic/gin"
+)
+
+// deviceFingerprint identifies the device a request comes from by its user
+// agent. The IP address is left out since it changes whenever a device
+// moves to another network.
+func deviceFingerprint(c *gin.Context) string {
+	sum := sha256.Sum256([]byte(c.Request.UserAgent()))
+	return hex.EncodeToString(sum[:])
+}
+
+// recordLoginEvent appends a login by method to the user's history. It
+// succeeded unless a failure reason is given. Failures to record are only
+// logged since they must not change the outcome of the login.
+func (h *AuthHandler) recordLoginEvent(
+	c *gin.Context,
+	user *models.User,
+	method models.LoginMethod,
+	reason models.LoginFailureReason,
+) {
+	event := &models.LoginEvent{
+		UserID:            user.ID,
+		Method:            method,
+		Success:           reason == "",
+		Reason:            reason,
+		IPAddress:         c.ClientIP(),
+		UserAgent:         clientUserAgent(c),
+		DeviceFingerprint: deviceFingerprint(c),
+	}
+
+	if err := h.LoginEventStore.Create(event); err != nil {
+		log.Printf("failed to record login event: %v", err)
+	}
+
+	// The lookup and the email must not hold up the login
+	if event.Success && h.Config.NewDeviceNotifications {
+		email, recorded := user.Email, *event
+		sendInBackground(
+			"new sign-in email", func() error {
+				return h.notifyNewDevice(email, &recorded)
+			},
+		)
+	}
+}
+
+// notifyNewDevice emails the user when a successful login, already recorded
+// as event, comes from a device they never logged in from. The very first
+// login is not reported.
+func (h *AuthHandler) notifyNewDevice(
+	email string,
+	event *models.LoginEvent,
+) error {
+	known, hasHistory, err := h.LoginEventStore.KnownDevice(
+		event.UserID,
+		event.DeviceFingerprint,
+		event.ID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to look up login device: %w", err)
+	}
+	if known || !hasHistory {
+		return nil
+	}
+
+	userAgent := event.UserAgent
+	if userAgent == "" {
+		userAgent = "unknown device"
+	}
+
+	return h.Mailer.Send(
+		mail.Message{
+			To:      email,
+			Subject: "New sign-in to your account",
+			Body: fmt.Sprintf(
+				"Your account was just signed in to from a new device.\n\n"+
+					"Device: %s\nIP address: %s\nTime: %s\n\n"+
+					"If it was you, you can ignore this email. If not, "+
+					"change your password and log out of all sessions.",
+				userAgent,
+				event.IPAddress,
+				event.CreatedAt.UTC().Format("2006-01-02 15:04 MST"),
+			),
+		},
+	)
+}
+
+// loginEventResponse builds the JSON representation of a login event
+func loginEventResponse(event *models.LoginEvent) gin.H {
+	return gin.H{
+		"id":         event.ID,
+		"success":    event.Success,
+		"method":     event.Method,
+		"reason":     event.Reason,
+		"ip_address": event.IPAddress,
+		"user_agent": event.UserAgent,
+		"created_at": event.CreatedAt,
+	}
+}
+
+// ListLoginHistory handles listing the successful and failed logins to the
+// user's account, newest first
+func (h *AuthHandler) ListLoginHistory(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	// Parse pagination parameters
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if err != nil || limit < 1 || limit > 100 {
+		limit = 20
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+
+	events, err := h.LoginEventStore.ListByUserID(userID, limit, offset)
+	if err != nil {
+		c.JSON(
+			http.StatusInternalServerError,
+			gin.H{"error": "Failed to list login history"},
+		)
+		return
+	}
+
+	response := []gin.H{}
+	for i := range events {
+		response = append(response, loginEventResponse(&events[i]))
+	}
+
+	c.JSON(http.StatusOK, response)
+}
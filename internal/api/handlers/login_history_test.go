@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"database/sql/driver"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/EngenMe/go-api-dod/config"
+	"github.com/EngenMe/go-api-dod/internal/data/models"
+	"github.com/EngenMe/go-api-dod/internal/data/store"
+	"github.com/EngenMe/go-api-dod/internal/mail"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// channelMailer hands sent messages to the test
+type channelMailer chan mail.Message
+
+func (m channelMailer) Send(msg mail.Message) error {
+	m <- msg
+	return nil
+}
+
+// sameArg matches any argument the first time and the same value afterwards
+type sameArg struct {
+	value driver.Value
+}
+
+func (a *sameArg) Match(v driver.Value) bool {
+	if a.value == nil {
+		a.value = v
+		return true
+	}
+	return a.value == v
+}
+
+func TestLoginFromNewDeviceIsRecordedBeforeTheNotification(t *testing.T) {
+	db, mock := newTestDB(t)
+	mailer := make(channelMailer, 1)
+	h := &AuthHandler{
+		Config:          config.AuthConfig{NewDeviceNotifications: true},
+		LoginEventStore: store.NewLoginEventStore(db),
+		Mailer:          mailer,
+	}
+	user := &models.User{ID: uuid.New(), Email: "user@example.com"}
+
+	// The new event itself does not make the device known
+	eventID := &sameArg{}
+	mock.ExpectExec(`INSERT INTO login_events`).
+		WithArgs(eventID, user.ID, sqlmock.AnyArg(), true, sqlmock.AnyArg(),
+			sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`FROM login_events\s+WHERE user_id = \$1 AND success AND id <> \$3`).
+		WithArgs(user.ID, sqlmock.AnyArg(), eventID).
+		WillReturnRows(
+			sqlmock.NewRows([]string{"total", "from_device"}).AddRow(3, 0),
+		)
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/login", nil)
+	h.recordLoginEvent(c, user, models.LoginMethodPassword, "")
+
+	select {
+	case msg := <-mailer:
+		if msg.To != "user@example.com" {
+			t.Errorf("email sent to %s", msg.To)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no new sign-in email was sent")
+	}
+}
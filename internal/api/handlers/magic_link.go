@@ -0,0 +1,211 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/EngenMe/go-api-dod/internal/data/models"
+	"github.com/EngenMe/go-api-dod/internal/mail"
+	"github.com/EngenMe/go-api-dod/internal/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// rejectDisabledMagicLink answers 404 if magic link login is turned off
+func (h *AuthHandler) rejectDisabledMagicLink(c *gin.Context) bool {
+	if h.Config.MagicLinkEnabled {
+		return false
+	}
+
+	c.JSON(
+		http.StatusNotFound,
+		gin.H{"error": "Magic link login is disabled"},
+	)
+	return true
+}
+
+// RequestMagicLink handles emailing a login link. Requests are throttled per
+// address, and the response does not reveal whether the email is registered.
+func (h *AuthHandler) RequestMagicLink(c *gin.Context) {
+	if h.rejectDisabledMagicLink(c) {
+		return
+	}
+
+	// Parse request body
+	var req struct {
+		Email string `json:"email" binding:"required,email"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	allowed, retryAfter := h.ResendLimiter.Allow(
+		"magic-link:" + strings.ToLower(req.Email),
+	)
+	if !allowed {
+		setRetryAfter(c, retryAfter)
+		c.JSON(
+			http.StatusTooManyRequests,
+			gin.H{"error": "Too many requests, please try again later"},
+		)
+		return
+	}
+
+	// Get user
+	user, err := h.UserStore.GetByEmail(req.Email)
+	if err != nil {
+		c.JSON(
+			http.StatusInternalServerError,
+			gin.H{"error": "Failed to get user"},
+		)
+		return
+	}
+
+	if user != nil {
+		if err := h.sendMagicLinkEmail(user); err != nil {
+			log.Printf("failed to send magic link email: %v", err)
+		}
+	}
+
+	c.JSON(
+		http.StatusAccepted,
+		gin.H{"message": "If an account with that email exists, a login link has been sent"},
+	)
+}
+
+// sendMagicLinkEmail replaces any outstanding login link of the user with a
+// new one and emails it
+func (h *AuthHandler) sendMagicLinkEmail(user *models.User) error {
+	err := h.UserTokenStore.InvalidateForUser(
+		user.ID,
+		models.UserTokenMagicLink,
+	)
+	if err != nil {
+		return err
+	}
+
+	token, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		return err
+	}
+
+	magicLinkToken := &models.UserToken{
+		UserID:    user.ID,
+		Purpose:   models.UserTokenMagicLink,
+		Token:     token,
+		Email:     user.Email,
+		ExpiresAt: time.Now().Add(h.Config.MagicLinkExpiration),
+	}
+	if err := h.UserTokenStore.Create(magicLinkToken); err != nil {
+		return err
+	}
+
+	link := h.Config.MagicLinkURL + "?token=" + url.QueryEscape(token)
+	return h.Mailer.Send(
+		mail.Message{
+			To:      user.Email,
+			Subject: "Your login link",
+			Body: fmt.Sprintf(
+				"Someone asked to log in to your account.\n\n"+
+					"Use this link within %d minutes to log in:\n%s\n\n"+
+					"If it wasn't you, you can ignore this email.",
+				int(h.Config.MagicLinkExpiration.Minutes()),
+				link,
+			),
+		},
+	)
+}
+
+// VerifyMagicLink handles logging in with the token of a login link. It
+// responds like Login, including the MFA challenge for users with MFA.
+func (h *AuthHandler) VerifyMagicLink(c *gin.Context) {
+	if h.rejectDisabledMagicLink(c) {
+		return
+	}
+
+	// Parse request body
+	var req struct {
+		Token string `json:"token" binding:"required"`
+		Scope string `json:"scope"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	scopes, err := utils.NarrowScope(req.Scope, utils.DefaultScopes)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid scope"})
+		return
+	}
+
+	// Consume the token so that it cannot be used twice
+	magicLinkToken, err := h.UserTokenStore.Consume(
+		req.Token,
+		models.UserTokenMagicLink,
+	)
+	if err != nil {
+		c.JSON(
+			http.StatusInternalServerError,
+			gin.H{"error": "Failed to verify login link"},
+		)
+		return
+	}
+	if magicLinkToken == nil {
+		c.JSON(
+			http.StatusBadRequest,
+			gin.H{"error": "Invalid or expired login link"},
+		)
+		return
+	}
+
+	// Get user
+	user, err := h.UserStore.GetByID(magicLinkToken.UserID)
+	if err != nil {
+		c.JSON(
+			http.StatusInternalServerError,
+			gin.H{"error": "Failed to get user"},
+		)
+		return
+	}
+	// The link only proves ownership of the address it was sent to
+	if user == nil || !strings.EqualFold(user.Email, magicLinkToken.Email) {
+		c.JSON(
+			http.StatusBadRequest,
+			gin.H{"error": "Invalid or expired login link"},
+		)
+		return
+	}
+
+	if h.rejectLockedUser(c, user) {
+		h.recordLoginEvent(
+			c,
+			user,
+			models.LoginMethodMagicLink,
+			models.LoginFailureAccountLocked,
+		)
+		return
+	}
+
+	// Following the link proves ownership of the address
+	if !user.IsEmailVerified() {
+		if err := h.UserStore.MarkEmailVerified(user.ID); err != nil {
+			c.JSON(
+				http.StatusInternalServerError,
+				gin.H{"error": "Failed to verify email"},
+			)
+			return
+		}
+		now := time.Now()
+		user.EmailVerifiedAt = &now
+	}
+
+	h.completeLogin(c, user, scopes, models.LoginMethodMagicLink)
+}
@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/EngenMe/go-api-dod/config"
+	"github.com/EngenMe/go-api-dod/internal/data/store"
+	"github.com/EngenMe/go-api-dod/internal/utils"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+func TestVerifyMagicLinkRejectsLinksSentToAnotherAddress(t *testing.T) {
+	db, mock := newTestDB(t)
+	h := &AuthHandler{
+		Config:         config.AuthConfig{MagicLinkEnabled: true},
+		UserStore:      store.NewUserStore(db, nil, nil),
+		UserTokenStore: store.NewUserTokenStore(db, utils.NewTokenHasher("pepper")),
+	}
+	router := gin.New()
+	router.POST("/login/magic-link/verify", h.VerifyMagicLink)
+
+	// The link went to the address the account had before an email change
+	userID := uuid.New()
+	mock.ExpectQuery(`UPDATE user_tokens\s+SET used_at`).
+		WillReturnRows(
+			sqlmock.NewRows([]string{"id", "user_id", "purpose", "email", "expires_at"}).
+				AddRow(uuid.New(), userID, "magic_link", "old@example.com", time.Now().Add(time.Minute)),
+		)
+	mock.ExpectQuery(`FROM users`).
+		WillReturnRows(
+			sqlmock.NewRows([]string{"id", "email", "role"}).
+				AddRow(userID, "new@example.com", "user"),
+		)
+
+	w, body := performRequest(
+		t, router, http.MethodPost, "/login/magic-link/verify",
+		gin.H{"token": "token"},
+	)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want 400: %v", w.Code, body)
+	}
+	if body["error"] != "Invalid or expired login link" {
+		t.Errorf("error = %v", body["error"])
+	}
+}
@@ -0,0 +1,241 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/EngenMe/go-api-dod/internal/api/middleware"
+	"github.com/EngenMe/go-api-dod/internal/data/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// currentUser loads the authenticated user, writing an error response if that
+// fails
+func (h *AuthHandler) currentUser(c *gin.Context) (*models.User, bool) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return nil, false
+	}
+
+	user, err := h.UserStore.GetByID(userID)
+	if err != nil {
+		c.JSON(
+			http.StatusInternalServerError,
+			gin.H{"error": "Failed to get user"},
+		)
+		return nil, false
+	}
+	if user == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return nil, false
+	}
+
+	return user, true
+}
+
+// confirmPassword re-checks the password of the authenticated user before a
+// sensitive change. Wrong guesses count towards the account lockout.
+func (h *AuthHandler) confirmPassword(
+	c *gin.Context,
+	user *models.User,
+	password string,
+) bool {
+	if h.rejectLockedUser(c, user) {
+		return false
+	}
+
+	if !h.PasswordHasher.Check(password, user.Password) {
+		h.recordLoginFailure(c, user)
+		c.JSON(
+			http.StatusForbidden,
+			gin.H{"error": "Current password is incorrect"},
+		)
+		return false
+	}
+
+	return true
+}
+
+// confirmIdentity re-authenticates the user before a sensitive change by the
+// password or, when none is given, by a login within ReauthenticationWindow.
+// Users who signed up with a magic link or an identity provider have a random
+// password they never saw.
+func (h *AuthHandler) confirmIdentity(
+	c *gin.Context,
+	user *models.User,
+	password string,
+) bool {
+	if password != "" {
+		return h.confirmPassword(c, user, password)
+	}
+
+	if h.rejectLockedUser(c, user) {
+		return false
+	}
+
+	recent, err := h.isRecentLogin(c, user)
+	if err != nil {
+		c.JSON(
+			http.StatusInternalServerError,
+			gin.H{"error": "Failed to get session"},
+		)
+		return false
+	}
+	if !recent {
+		c.JSON(
+			http.StatusForbidden,
+			gin.H{"error": "Current password or a recent login is required"},
+		)
+		return false
+	}
+
+	return true
+}
+
+// isRecentLogin reports whether the access token belongs to a session that
+// was started within ReauthenticationWindow. Refreshing tokens does not
+// count as a login.
+func (h *AuthHandler) isRecentLogin(
+	c *gin.Context,
+	user *models.User,
+) (bool, error) {
+	claims := middleware.Claims(c)
+	if claims == nil || claims.SessionID == "" ||
+		h.Config.ReauthenticationWindow == 0 {
+		return false, nil
+	}
+	familyID, err := uuid.Parse(claims.SessionID)
+	if err != nil {
+		return false, nil
+	}
+
+	startedAt, err := h.RefreshTokenStore.SessionStartedAt(user.ID, familyID)
+	if err != nil || startedAt == nil {
+		return false, err
+	}
+
+	return time.Since(*startedAt) < h.Config.ReauthenticationWindow, nil
+}
+
+// meResponse builds the profile returned by the /me endpoints
+func meResponse(user *models.User) gin.H {
+	response := userResponse(user)
+	response["created_at"] = user.CreatedAt
+	response["updated_at"] = user.UpdatedAt
+	return response
+}
+
+// GetMe handles retrieving the authenticated user
+func (h *AuthHandler) GetMe(c *gin.Context) {
+	user, ok := h.currentUser(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, meResponse(user))
+}
+
+// UpdateMe handles updating the profile of the authenticated user. Changing
+// the email requires the current password or a recent login and a new
+// verification.
+func (h *AuthHandler) UpdateMe(c *gin.Context) {
+	// Parse request body
+	var req struct {
+		Email           string `json:"email" binding:"omitempty,email"`
+		CurrentPassword string `json:"current_password"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, ok := h.currentUser(c)
+	if !ok {
+		return
+	}
+
+	if req.Email == "" || req.Email == user.Email {
+		c.JSON(http.StatusOK, meResponse(user))
+		return
+	}
+
+	if !h.confirmIdentity(c, user, req.CurrentPassword) {
+		return
+	}
+
+	// Check if the email is taken
+	existingUser, err := h.UserStore.GetByEmail(req.Email)
+	if err != nil {
+		c.JSON(
+			http.StatusInternalServerError,
+			gin.H{"error": "Failed to check user existence"},
+		)
+		return
+	}
+	if existingUser != nil {
+		c.JSON(
+			http.StatusConflict,
+			gin.H{"error": "User with this email already exists"},
+		)
+		return
+	}
+
+	// Update user
+	user.Email = req.Email
+	user.EmailVerifiedAt = nil
+	if err := h.UserStore.Update(user); err != nil {
+		c.JSON(
+			http.StatusInternalServerError,
+			gin.H{"error": "Failed to update user"},
+		)
+		return
+	}
+
+	// The new address has to be confirmed before it counts as verified
+	if err := h.sendVerificationEmail(user); err != nil {
+		log.Printf("failed to send verification email: %v", err)
+	}
+
+	c.JSON(http.StatusOK, meResponse(user))
+}
+
+// DeleteMe handles deleting the authenticated user's account, which requires
+// the current password or a recent login. The account is soft-deleted and
+// every session is ended.
+func (h *AuthHandler) DeleteMe(c *gin.Context) {
+	// Parse request body
+	var req struct {
+		CurrentPassword string `json:"current_password"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, ok := h.currentUser(c)
+	if !ok {
+		return
+	}
+
+	if !h.confirmIdentity(c, user, req.CurrentPassword) {
+		return
+	}
+
+	// Delete user
+	if err := h.UserStore.Delete(user.ID); err != nil {
+		c.JSON(
+			http.StatusInternalServerError,
+			gin.H{"error": "Failed to delete user"},
+		)
+		return
+	}
+
+	// Return success
+	c.JSON(http.StatusOK, gin.H{"message": "Account deleted successfully"})
+}
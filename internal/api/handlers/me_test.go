@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/EngenMe/go-api-dod/internal/utils"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// signedInFixture is an authFixture whose requests carry an access token of
+// a session started at the given time
+func signedInFixture(t *testing.T, startedAt time.Time) *authFixture {
+	f := newAuthFixture(t)
+	sessionID := uuid.New()
+	authorized := f.router.Group(
+		"/session",
+		authenticateAs(&utils.Claims{
+			UserID:    f.userID,
+			Email:     "user@example.com",
+			Role:      "user",
+			SessionID: sessionID.String(),
+		}),
+	)
+	authorized.PATCH("/me", f.handler.UpdateMe)
+	authorized.DELETE("/me", f.handler.DeleteMe)
+
+	f.mock.ExpectQuery(`FROM users\s+WHERE id = \$1`).
+		WillReturnRows(
+			sqlmock.NewRows([]string{"id", "email", "role"}).
+				AddRow(f.userID, "user@example.com", "user"),
+		)
+	f.mock.ExpectQuery(`SELECT created_at\s+FROM refresh_tokens`).
+		WithArgs(sessionID, f.userID).
+		WillReturnRows(sqlmock.NewRows([]string{"created_at"}).AddRow(startedAt))
+
+	return f
+}
+
+func TestDeleteMeAcceptsRecentLoginInsteadOfPassword(t *testing.T) {
+	f := signedInFixture(t, time.Now().Add(-time.Minute))
+
+	f.mock.ExpectBegin()
+	f.mock.ExpectExec(`UPDATE users\s+SET deleted_at = \$1,\s+tokens_valid_after`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	f.mock.ExpectExec(`UPDATE refresh_tokens`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	f.mock.ExpectCommit()
+
+	w, body := performRequest(t, f.router, http.MethodDelete, "/session/me", gin.H{})
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %v", w.Code, body)
+	}
+}
+
+func TestUpdateMeRequiresPasswordLongAfterLogin(t *testing.T) {
+	f := signedInFixture(t, time.Now().Add(-time.Hour))
+
+	w, body := performRequest(
+		t, f.router, http.MethodPatch, "/session/me",
+		gin.H{"email": "new@example.com"},
+	)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("status = %d, want 403: %v", w.Code, body)
+	}
+	if body["error"] != "Current password or a recent login is required" {
+		t.Errorf("error = %v", body["error"])
+	}
+}
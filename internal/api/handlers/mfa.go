@@ -0,0 +1,366 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/EngenMe/go-api-dod/internal/data/models"
+	"github.com/EngenMe/go-api-dod/internal/data/store"
+	"github.com/EngenMe/go-api-dod/internal/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// recoveryCodeCount is the number of recovery codes handed out on enrollment
+const recoveryCodeCount = 10
+
+// MFAHandler provides handlers for managing TOTP multi-factor authentication.
+// Users and their passwords are checked by the AuthHandler, so that wrong
+// passwords count towards the account lockout.
+type MFAHandler struct {
+	AuthHandler *AuthHandler
+	Issuer      string
+}
+
+// NewMFAHandler creates a new MFAHandler
+func NewMFAHandler(
+	authHandler *AuthHandler,
+	issuer string,
+) *MFAHandler {
+	return &MFAHandler{
+		AuthHandler: authHandler,
+		Issuer:      issuer,
+	}
+}
+
+// verifySecondFactor checks either a TOTP code or a recovery code of a user
+// with MFA enabled. Accepted TOTP codes and recovery codes cannot be reused.
+func verifySecondFactor(
+	userStore *store.UserStore,
+	mfaRecoveryCodeStore *store.MFARecoveryCodeStore,
+	user *models.User,
+	code, recoveryCode string,
+) (bool, error) {
+	if code != "" {
+		secret, err := userStore.MFASecret(user)
+		if err != nil {
+			return false, err
+		}
+		counter, ok := utils.ValidateTOTP(
+			secret,
+			code,
+			time.Now(),
+			user.MFALastCounter,
+		)
+		if !ok {
+			return false, nil
+		}
+		return userStore.AdvanceMFACounter(user.ID, counter)
+	}
+
+	if recoveryCode != "" {
+		return mfaRecoveryCodeStore.Consume(user.ID, recoveryCode)
+	}
+
+	return false, nil
+}
+
+// Enroll handles starting TOTP enrollment with a new secret
+func (h *MFAHandler) Enroll(c *gin.Context) {
+	user, ok := h.AuthHandler.currentUser(c)
+	if !ok {
+		return
+	}
+
+	if user.IsMFAEnabled() {
+		c.JSON(http.StatusConflict, gin.H{"error": "MFA is already enabled"})
+		return
+	}
+
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		c.JSON(
+			http.StatusInternalServerError,
+			gin.H{"error": "Failed to generate MFA secret"},
+		)
+		return
+	}
+
+	if err := h.AuthHandler.UserStore.SetMFASecret(user.ID, secret); err != nil {
+		c.JSON(
+			http.StatusInternalServerError,
+			gin.H{"error": "Failed to store MFA secret"},
+		)
+		return
+	}
+
+	c.JSON(
+		http.StatusOK, gin.H{
+			"secret":      secret,
+			"otpauth_url": utils.TOTPURI(h.Issuer, user.Email, secret),
+		},
+	)
+}
+
+// Confirm handles completing TOTP enrollment with a code from the app
+func (h *MFAHandler) Confirm(c *gin.Context) {
+	// Parse request body
+	var req struct {
+		Code string `json:"code" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, ok := h.AuthHandler.currentUser(c)
+	if !ok {
+		return
+	}
+
+	if user.IsMFAEnabled() {
+		c.JSON(http.StatusConflict, gin.H{"error": "MFA is already enabled"})
+		return
+	}
+	if user.MFASecret == "" {
+		c.JSON(
+			http.StatusBadRequest,
+			gin.H{"error": "MFA enrollment has not been started"},
+		)
+		return
+	}
+
+	secret, err := h.AuthHandler.UserStore.MFASecret(user)
+	if err != nil {
+		c.JSON(
+			http.StatusInternalServerError,
+			gin.H{"error": "Failed to read MFA secret"},
+		)
+		return
+	}
+
+	counter, valid := utils.ValidateTOTP(
+		secret,
+		req.Code,
+		time.Now(),
+		user.MFALastCounter,
+	)
+	if !valid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid MFA code"})
+		return
+	}
+
+	// Generate recovery codes
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		code, err := utils.GenerateRecoveryCode()
+		if err != nil {
+			c.JSON(
+				http.StatusInternalServerError,
+				gin.H{"error": "Failed to generate recovery codes"},
+			)
+			return
+		}
+		codes[i] = code
+	}
+
+	err = h.AuthHandler.MFARecoveryCodeStore.ReplaceForUser(user.ID, codes)
+	if err != nil {
+		c.JSON(
+			http.StatusInternalServerError,
+			gin.H{"error": "Failed to store recovery codes"},
+		)
+		return
+	}
+
+	if err := h.AuthHandler.UserStore.EnableMFA(user.ID, counter); err != nil {
+		c.JSON(
+			http.StatusInternalServerError,
+			gin.H{"error": "Failed to enable MFA"},
+		)
+		return
+	}
+
+	// Recovery codes are only ever shown once
+	c.JSON(
+		http.StatusOK, gin.H{
+			"message":        "MFA enabled successfully",
+			"recovery_codes": codes,
+		},
+	)
+}
+
+// Disable handles turning MFA off, which requires the password and a second
+// factor
+func (h *MFAHandler) Disable(c *gin.Context) {
+	// Parse request body
+	var req struct {
+		Password     string `json:"password" binding:"required"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, ok := h.AuthHandler.currentUser(c)
+	if !ok {
+		return
+	}
+
+	if !user.IsMFAEnabled() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "MFA is not enabled"})
+		return
+	}
+
+	if !h.AuthHandler.confirmPassword(c, user, req.Password) {
+		return
+	}
+
+	valid, err := verifySecondFactor(
+		h.AuthHandler.UserStore,
+		h.AuthHandler.MFARecoveryCodeStore,
+		user,
+		req.Code,
+		req.RecoveryCode,
+	)
+	if err != nil {
+		c.JSON(
+			http.StatusInternalServerError,
+			gin.H{"error": "Failed to verify MFA code"},
+		)
+		return
+	}
+	if !valid {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid MFA code"})
+		return
+	}
+
+	if err := h.AuthHandler.UserStore.DisableMFA(user.ID); err != nil {
+		c.JSON(
+			http.StatusInternalServerError,
+			gin.H{"error": "Failed to disable MFA"},
+		)
+		return
+	}
+
+	err = h.AuthHandler.MFARecoveryCodeStore.DeleteForUser(user.ID)
+	if err != nil {
+		c.JSON(
+			http.StatusInternalServerError,
+			gin.H{"error": "Failed to delete recovery codes"},
+		)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "MFA disabled successfully"})
+}
+
+// LoginMFA handles completing a login with a TOTP or recovery code
+func (h *AuthHandler) LoginMFA(c *gin.Context) {
+	// Parse request body
+	var req struct {
+		MFAToken     string `json:"mfa_token" binding:"required"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.Code == "" && req.RecoveryCode == "" {
+		c.JSON(
+			http.StatusBadRequest,
+			gin.H{"error": "Either code or recovery_code is required"},
+		)
+		return
+	}
+
+	if h.rejectThrottledIP(c) {
+		return
+	}
+
+	// Validate MFA challenge token
+	claims, err := h.TokenManager.ValidateMFAToken(req.MFAToken)
+	if err != nil || claims.ID == "" {
+		c.JSON(
+			http.StatusUnauthorized,
+			gin.H{"error": "Invalid or expired MFA token"},
+		)
+		return
+	}
+
+	// An MFA token is used up before the code is checked, so that concurrent
+	// requests cannot complete more than one login with it
+	consumed, err := h.RevokedTokenStore.Consume(
+		claims.ID,
+		claims.ExpiresAt.Time,
+	)
+	if err != nil {
+		c.JSON(
+			http.StatusInternalServerError,
+			gin.H{"error": "Failed to consume MFA token"},
+		)
+		return
+	}
+	if !consumed {
+		c.JSON(
+			http.StatusUnauthorized,
+			gin.H{"error": "Invalid or expired MFA token"},
+		)
+		return
+	}
+
+	// Get user
+	user, err := h.UserStore.GetByID(claims.UserID)
+	if err != nil {
+		c.JSON(
+			http.StatusInternalServerError,
+			gin.H{"error": "Failed to get user"},
+		)
+		return
+	}
+	if user == nil || !user.IsMFAEnabled() {
+		c.JSON(
+			http.StatusUnauthorized,
+			gin.H{"error": "Invalid or expired MFA token"},
+		)
+		return
+	}
+
+	method := models.LoginMethod(claims.Method)
+	if h.rejectLockedUser(c, user) {
+		h.recordLoginEvent(c, user, method, models.LoginFailureAccountLocked)
+		return
+	}
+
+	valid, err := verifySecondFactor(
+		h.UserStore,
+		h.MFARecoveryCodeStore,
+		user,
+		req.Code,
+		req.RecoveryCode,
+	)
+	if err != nil {
+		c.JSON(
+			http.StatusInternalServerError,
+			gin.H{"error": "Failed to verify MFA code"},
+		)
+		return
+	}
+	if !valid {
+		h.recordLoginFailure(c, user)
+		h.recordLoginEvent(c, user, method, models.LoginFailureInvalidMFACode)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid MFA code"})
+		return
+	}
+
+	h.recordLoginSuccess(user)
+	h.recordLoginEvent(c, user, method, "")
+	h.respondWithTokens(c, user, claims.Scopes(), method, http.StatusOK)
+}
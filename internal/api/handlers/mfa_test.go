@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/EngenMe/go-api-dod/internal/data/models"
+	"github.com/EngenMe/go-api-dod/internal/utils"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+)
+
+// mfaToken issues an MFA challenge token for the fixture user
+func (f *authFixture) mfaToken(t *testing.T) string {
+	token, err := f.handler.TokenManager.GenerateMFAToken(
+		f.userID,
+		"user@example.com",
+		utils.DefaultScopes,
+		string(models.LoginMethodPassword),
+	)
+	if err != nil {
+		t.Fatalf("failed to generate MFA token: %v", err)
+	}
+	return token
+}
+
+func TestLoginMFATokenCompletesOneLogin(t *testing.T) {
+	f := newAuthFixture(t)
+	mfaToken := f.mfaToken(t)
+
+	f.mock.ExpectExec(`INSERT INTO revoked_tokens`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	f.mock.ExpectQuery(`FROM users\s+WHERE id = \$1`).
+		WillReturnRows(
+			sqlmock.NewRows([]string{"id", "email", "role", "mfa_secret", "mfa_enabled_at"}).
+				AddRow(f.userID, "user@example.com", "user", "enc:v1:secret", time.Now()),
+		)
+	f.mock.ExpectExec(`UPDATE mfa_recovery_codes`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	f.mock.ExpectExec(`INSERT INTO login_events`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	f.mock.ExpectExec(`INSERT INTO refresh_tokens`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	request := gin.H{"mfa_token": mfaToken, "recovery_code": "abcde-fghij"}
+	w, body := performRequest(t, f.router, http.MethodPost, "/login/mfa", request)
+	if w.Code != http.StatusOK {
+		t.Fatalf("first login = %d, want 200: %v", w.Code, body)
+	}
+
+	// A replay, or a concurrent request losing the race, is turned away
+	// before the user or the code is looked at
+	f.mock.ExpectExec(`INSERT INTO revoked_tokens`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	w, body = performRequest(t, f.router, http.MethodPost, "/login/mfa", request)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("replayed login = %d, want 401", w.Code)
+	}
+	if body["error"] != "Invalid or expired MFA token" {
+		t.Errorf("error = %v", body["error"])
+	}
+}
+
+func TestLoginMFATokenIsUsedUpByAWrongCode(t *testing.T) {
+	f := newAuthFixture(t)
+	mfaToken := f.mfaToken(t)
+
+	f.mock.ExpectExec(`INSERT INTO revoked_tokens`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	f.mock.ExpectQuery(`FROM users\s+WHERE id = \$1`).
+		WillReturnRows(
+			sqlmock.NewRows([]string{"id", "email", "role", "mfa_secret", "mfa_enabled_at"}).
+				AddRow(f.userID, "user@example.com", "user", "enc:v1:secret", time.Now()),
+		)
+	f.mock.ExpectExec(`UPDATE mfa_recovery_codes`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	f.mock.ExpectQuery(`SET failed_login_attempts = failed_login_attempts \+ 1`).
+		WillReturnRows(sqlmock.NewRows([]string{"failed_login_attempts"}).AddRow(1))
+	f.mock.ExpectExec(`INSERT INTO login_events`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	request := gin.H{"mfa_token": mfaToken, "recovery_code": "wrong-code"}
+	w, _ := performRequest(t, f.router, http.MethodPost, "/login/mfa", request)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("wrong code = %d, want 401", w.Code)
+	}
+
+	f.mock.ExpectExec(`INSERT INTO revoked_tokens`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	w, body := performRequest(t, f.router, http.MethodPost, "/login/mfa", request)
+	if w.Code != http.StatusUnauthorized ||
+		body["error"] != "Invalid or expired MFA token" {
+		t.Fatalf("retry = %d %v, want the MFA token rejected", w.Code, body)
+	}
+}
+
+func TestDisableMFACountsWrongPasswords(t *testing.T) {
+	f := newAuthFixture(t)
+	h := NewMFAHandler(f.handler, "test")
+	f.router.POST(
+		"/mfa/disable",
+		authenticateAs(&utils.Claims{UserID: f.userID, Role: "user"}),
+		h.Disable,
+	)
+
+	hash, err := f.handler.PasswordHasher.Hash("correct horse")
+	if err != nil {
+		t.Fatalf("failed to hash password: %v", err)
+	}
+	f.mock.ExpectQuery(`FROM users\s+WHERE id = \$1`).
+		WillReturnRows(
+			sqlmock.NewRows([]string{"id", "email", "password", "role", "mfa_secret", "mfa_enabled_at"}).
+				AddRow(f.userID, "user@example.com", hash, "user", "enc:v1:secret", time.Now()),
+		)
+	f.mock.ExpectQuery(`SET failed_login_attempts = failed_login_attempts \+ 1`).
+		WillReturnRows(sqlmock.NewRows([]string{"failed_login_attempts"}).AddRow(1))
+
+	w, body := performRequest(
+		t, f.router, http.MethodPost, "/mfa/disable",
+		gin.H{"password": "guess", "code": "123456"},
+	)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("status = %d, want 403: %v", w.Code, body)
+	}
+}
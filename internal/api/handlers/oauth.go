@@ -0,0 +1,654 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/EngenMe/go-api-dod/internal/data/models"
+	"github.com/EngenMe/go-api-dod/internal/data/store"
+	"github.com/EngenMe/go-api-dod/internal/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// OAuthHandler provides the OAuth2 and OpenID Connect endpoints and the
+// management of registered OAuth clients. Users signing in through the
+// authorization endpoint are authenticated by the AuthHandler.
+type OAuthHandler struct {
+	AuthHandler            *AuthHandler
+	OAuthClientStore       *store.OAuthClientStore
+	AuthorizationCodeStore *store.AuthorizationCodeStore
+	RefreshTokenStore      *store.RefreshTokenStore
+	RevokedTokenStore      *store.RevokedTokenStore
+	TokenManager           *utils.TokenManager
+}
+
+// NewOAuthHandler creates a new OAuthHandler
+func NewOAuthHandler(
+	authHandler *AuthHandler,
+	oauthClientStore *store.OAuthClientStore,
+	authorizationCodeStore *store.AuthorizationCodeStore,
+	refreshTokenStore *store.RefreshTokenStore,
+	revokedTokenStore *store.RevokedTokenStore,
+	tokenManager *utils.TokenManager,
+) *OAuthHandler {
+	return &OAuthHandler{
+		AuthHandler:            authHandler,
+		OAuthClientStore:       oauthClientStore,
+		AuthorizationCodeStore: authorizationCodeStore,
+		RefreshTokenStore:      refreshTokenStore,
+		RevokedTokenStore:      revokedTokenStore,
+		TokenManager:           tokenManager,
+	}
+}
+
+// oauthError writes an OAuth2 error response (RFC 6749 section 5.2)
+func oauthError(c *gin.Context, status int, code, description string) {
+	c.Header("Cache-Control", "no-store")
+	if status == http.StatusUnauthorized {
+		c.Header("WWW-Authenticate", `Basic realm="oauth"`)
+	}
+	c.JSON(
+		status, gin.H{
+			"error":             code,
+			"error_description": description,
+		},
+	)
+}
+
+// clientCredentials returns the client ID and secret sent as HTTP Basic
+// credentials or as client_id and client_secret form parameters
+func clientCredentials(c *gin.Context) (string, string) {
+	clientID, secret, ok := c.Request.BasicAuth()
+	if !ok {
+		return c.PostForm("client_id"), c.PostForm("client_secret")
+	}
+
+	// Basic credentials are form-encoded (RFC 6749 section 2.3.1)
+	clientID, _ = url.QueryUnescape(clientID)
+	secret, _ = url.QueryUnescape(secret)
+	return clientID, secret
+}
+
+// authenticateClient authenticates the calling client with HTTP Basic
+// credentials or client_id and client_secret form parameters, writing an
+// invalid_client error if that fails
+func (h *OAuthHandler) authenticateClient(c *gin.Context) (
+	*models.OAuthClient,
+	bool,
+) {
+	clientID, secret := clientCredentials(c)
+	if clientID == "" || secret == "" {
+		oauthError(
+			c,
+			http.StatusUnauthorized,
+			"invalid_client",
+			"Client authentication required",
+		)
+		return nil, false
+	}
+
+	client, err := h.OAuthClientStore.Authenticate(clientID, secret)
+	if err != nil {
+		oauthError(
+			c,
+			http.StatusInternalServerError,
+			"server_error",
+			"Failed to authenticate client",
+		)
+		return nil, false
+	}
+	if client == nil {
+		oauthError(
+			c,
+			http.StatusUnauthorized,
+			"invalid_client",
+			"Invalid client credentials",
+		)
+		return nil, false
+	}
+
+	return client, true
+}
+
+// authenticateTokenClient authenticates the client of a grant that public
+// clients may use as well. Those identify themselves by client_id alone.
+func (h *OAuthHandler) authenticateTokenClient(c *gin.Context) (
+	*models.OAuthClient,
+	bool,
+) {
+	clientID, secret := clientCredentials(c)
+	client, err := h.OAuthClientStore.GetByClientID(clientID)
+	if err != nil {
+		oauthError(
+			c,
+			http.StatusInternalServerError,
+			"server_error",
+			"Failed to authenticate client",
+		)
+		return nil, false
+	}
+	if client == nil || !client.Public || secret != "" {
+		return h.authenticateClient(c)
+	}
+
+	return client, true
+}
+
+// Token handles the OAuth2 token endpoint
+func (h *OAuthHandler) Token(c *gin.Context) {
+	switch c.PostForm("grant_type") {
+	case "authorization_code":
+		h.authorizationCodeGrant(c)
+	case "client_credentials":
+		h.clientCredentialsGrant(c)
+	case "refresh_token":
+		h.refreshTokenGrant(c)
+	case "":
+		oauthError(
+			c,
+			http.StatusBadRequest,
+			"invalid_request",
+			"grant_type is required",
+		)
+	default:
+		oauthError(
+			c,
+			http.StatusBadRequest,
+			"unsupported_grant_type",
+			"Unsupported grant type",
+		)
+	}
+}
+
+// clientCredentialsGrant issues an access token to a client acting on its own
+// behalf (RFC 6749 section 4.4)
+func (h *OAuthHandler) clientCredentialsGrant(c *gin.Context) {
+	client, ok := h.authenticateClient(c)
+	if !ok {
+		return
+	}
+
+	// OpenID Connect scopes only make sense on behalf of a user
+	var available []string
+	for _, scope := range client.Scopes() {
+		if utils.HasScope(utils.MachineScopes, scope) {
+			available = append(available, scope)
+		}
+	}
+	scopes, err := utils.NarrowScope(c.PostForm("scope"), available)
+	if err != nil || len(scopes) == 0 {
+		oauthError(
+			c,
+			http.StatusBadRequest,
+			"invalid_scope",
+			"Requested scope exceeds the scopes of the client",
+		)
+		return
+	}
+
+	accessToken, err := h.TokenManager.GenerateClientAccessToken(
+		client.ClientID,
+		scopes,
+	)
+	if err != nil {
+		oauthError(
+			c,
+			http.StatusInternalServerError,
+			"server_error",
+			"Failed to generate access token",
+		)
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(
+		http.StatusOK, gin.H{
+			"access_token": accessToken,
+			"token_type":   "Bearer",
+			"expires_in":   int(h.TokenManager.AccessTokenExpiresIn.Seconds()),
+			"scope":        utils.FormatScope(scopes),
+		},
+	)
+}
+
+// refreshTokenGrant rotates a refresh token issued to the calling client into
+// a new token pair (RFC 6749 section 6)
+func (h *OAuthHandler) refreshTokenGrant(c *gin.Context) {
+	client, ok := h.authenticateTokenClient(c)
+	if !ok {
+		return
+	}
+
+	refreshToken := c.PostForm("refresh_token")
+	if refreshToken == "" {
+		oauthError(
+			c,
+			http.StatusBadRequest,
+			"invalid_request",
+			"refresh_token is required",
+		)
+		return
+	}
+
+	// Clients may only redeem their own refresh tokens
+	claims, err := h.TokenManager.ValidateRefreshToken(refreshToken)
+	if err != nil || claims.AuthorizedParty != client.ClientID {
+		oauthError(
+			c,
+			http.StatusBadRequest,
+			"invalid_grant",
+			"Invalid or expired refresh token",
+		)
+		return
+	}
+
+	tokens, err := h.AuthHandler.refresh(c, refreshToken, c.PostForm("scope"))
+	switch {
+	case err == nil:
+		c.Header("Cache-Control", "no-store")
+		c.JSON(http.StatusOK, h.AuthHandler.tokenResponse(tokens))
+	case errors.Is(err, errInvalidScope):
+		oauthError(
+			c,
+			http.StatusBadRequest,
+			"invalid_scope",
+			"Requested scope exceeds the granted scope",
+		)
+	case errors.Is(err, errMalformedRefreshToken),
+		errors.Is(err, errInvalidRefreshToken),
+		errors.Is(err, errRefreshTokenReused),
+		errors.Is(err, store.ErrRefreshTokenRotated):
+		oauthError(
+			c,
+			http.StatusBadRequest,
+			"invalid_grant",
+			"Invalid or expired refresh token",
+		)
+	default:
+		oauthError(
+			c,
+			http.StatusInternalServerError,
+			"server_error",
+			"Failed to refresh tokens",
+		)
+	}
+}
+
+// Introspect handles reporting the state of an access or refresh token to an
+// authenticated client (RFC 7662). Only clients registered for introspection
+// learn about tokens issued to others; to the rest those look inactive.
+func (h *OAuthHandler) Introspect(c *gin.Context) {
+	client, ok := h.authenticateClient(c)
+	if !ok {
+		return
+	}
+
+	token := c.PostForm("token")
+	if token == "" {
+		oauthError(
+			c,
+			http.StatusBadRequest,
+			"invalid_request",
+			"token is required",
+		)
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+
+	// Inactive, unknown and malformed tokens all look the same
+	claims, err := h.TokenManager.Validate(token)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"active": false})
+		return
+	}
+	if !client.Introspect && claims.Client() != client.ClientID {
+		c.JSON(http.StatusOK, gin.H{"active": false})
+		return
+	}
+
+	switch claims.TokenType {
+	case utils.AccessToken:
+		// Access tokens must not have been revoked
+		revoked, err := h.RevokedTokenStore.IsRevoked(claims)
+		if err != nil {
+			oauthError(
+				c,
+				http.StatusInternalServerError,
+				"server_error",
+				"Failed to check token revocation",
+			)
+			return
+		}
+		if revoked {
+			c.JSON(http.StatusOK, gin.H{"active": false})
+			return
+		}
+	case utils.RefreshToken:
+		// Refresh tokens must also still be live in the database
+		storedToken, err := h.RefreshTokenStore.GetByToken(token)
+		if err != nil {
+			oauthError(
+				c,
+				http.StatusInternalServerError,
+				"server_error",
+				"Failed to get refresh token",
+			)
+			return
+		}
+		if storedToken == nil || !storedToken.IsValid() {
+			c.JSON(http.StatusOK, gin.H{"active": false})
+			return
+		}
+	default:
+		c.JSON(http.StatusOK, gin.H{"active": false})
+		return
+	}
+
+	c.JSON(http.StatusOK, introspectionResponse(claims))
+}
+
+// introspectionResponse builds the RFC 7662 response for an active token
+func introspectionResponse(claims *utils.Claims) gin.H {
+	response := gin.H{
+		"active":     true,
+		"scope":      utils.FormatScope(claims.Scopes()),
+		"token_type": string(claims.TokenType) + "_token",
+		"iss":        claims.Issuer,
+	}
+
+	// Client tokens name the client as subject, user tokens the user
+	if claims.ClientID != "" {
+		response["sub"] = claims.Subject
+		response["client_id"] = claims.ClientID
+	} else {
+		response["sub"] = claims.UserID
+		response["username"] = claims.Email
+		if claims.AuthorizedParty != "" {
+			response["client_id"] = claims.AuthorizedParty
+		}
+	}
+	if claims.ExpiresAt != nil {
+		response["exp"] = claims.ExpiresAt.Unix()
+	}
+	if claims.IssuedAt != nil {
+		response["iat"] = claims.IssuedAt.Unix()
+	}
+	if claims.ID != "" {
+		response["jti"] = claims.ID
+	}
+	if claims.Actor != nil {
+		response["act"] = claims.Actor
+	}
+
+	return response
+}
+
+// Revoke handles revoking a token on behalf of an authenticated client
+// (RFC 7009). Clients may only revoke tokens issued to them. Revoking a
+// refresh token ends its whole session, revoking an access token denylists
+// its jti.
+func (h *OAuthHandler) Revoke(c *gin.Context) {
+	client, ok := h.authenticateClient(c)
+	if !ok {
+		return
+	}
+
+	token := c.PostForm("token")
+	if token == "" {
+		oauthError(
+			c,
+			http.StatusBadRequest,
+			"invalid_request",
+			"token is required",
+		)
+		return
+	}
+
+	// Invalid and unknown tokens need no revocation (RFC 7009 section 2.2)
+	claims, err := h.TokenManager.Validate(token)
+	if err != nil {
+		c.Status(http.StatusOK)
+		return
+	}
+
+	// RFC 7009 section 2.1
+	if claims.Client() != client.ClientID {
+		oauthError(
+			c,
+			http.StatusBadRequest,
+			"unauthorized_client",
+			"Token was not issued to this client",
+		)
+		return
+	}
+
+	switch claims.TokenType {
+	case utils.RefreshToken:
+		storedToken, err := h.RefreshTokenStore.GetByToken(token)
+		if err != nil {
+			oauthError(
+				c,
+				http.StatusInternalServerError,
+				"server_error",
+				"Failed to get refresh token",
+			)
+			return
+		}
+		if storedToken != nil {
+			err = h.RefreshTokenStore.RevokeFamily(storedToken.FamilyID)
+			if err != nil {
+				oauthError(
+					c,
+					http.StatusInternalServerError,
+					"server_error",
+					"Failed to revoke refresh token",
+				)
+				return
+			}
+		}
+	case utils.AccessToken:
+		// Tokens issued before jtis were introduced cannot be denylisted
+		if claims.ID == "" {
+			oauthError(
+				c,
+				http.StatusBadRequest,
+				"unsupported_token_type",
+				"Access token cannot be revoked",
+			)
+			return
+		}
+
+		err := h.RevokedTokenStore.Revoke(claims.ID, claims.ExpiresAt.Time)
+		if err != nil {
+			oauthError(
+				c,
+				http.StatusInternalServerError,
+				"server_error",
+				"Failed to revoke access token",
+			)
+			return
+		}
+	}
+
+	c.Status(http.StatusOK)
+}
+
+// oauthClientResponse builds the JSON representation of a client, which
+// never includes the secret
+func oauthClientResponse(client *models.OAuthClient) gin.H {
+	return gin.H{
+		"id":            client.ID,
+		"client_id":     client.ClientID,
+		"name":          client.Name,
+		"scope":         client.Scope,
+		"redirect_uris": client.RedirectURIList(),
+		"public":        client.Public,
+		"introspect":    client.Introspect,
+		"created_at":    client.CreatedAt,
+	}
+}
+
+// validRedirectURI reports whether uri may be registered as a redirect URI:
+// an absolute URL without a fragment (RFC 6749 section 3.1.2)
+func validRedirectURI(uri string) bool {
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		return false
+	}
+	return parsed.IsAbs() && parsed.Host != "" && parsed.Fragment == "" &&
+		!strings.ContainsAny(uri, " #")
+}
+
+// CreateClient handles registering a new OAuth client. The secret is only
+// returned once. Public clients get no secret and may only use the
+// authorization code flow.
+func (h *OAuthHandler) CreateClient(c *gin.Context) {
+	// Parse request body
+	var req struct {
+		Name         string   `json:"name" binding:"required,max=100"`
+		Scope        string   `json:"scope" binding:"required"`
+		RedirectURIs []string `json:"redirect_uris"`
+		Public       bool     `json:"public"`
+		Introspect   bool     `json:"introspect"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	available := append(
+		append([]string{}, utils.MachineScopes...),
+		utils.OIDCScopes...,
+	)
+	scopes, err := utils.NarrowScope(req.Scope, available)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid scope"})
+		return
+	}
+	for _, uri := range req.RedirectURIs {
+		if !validRedirectURI(uri) {
+			c.JSON(
+				http.StatusBadRequest,
+				gin.H{"error": "Invalid redirect URI"},
+			)
+			return
+		}
+	}
+	if req.Public && len(req.RedirectURIs) == 0 {
+		c.JSON(
+			http.StatusBadRequest,
+			gin.H{"error": "Public clients require a redirect URI"},
+		)
+		return
+	}
+
+	clientID, err := utils.GenerateRandomToken(16)
+	if err != nil {
+		c.JSON(
+			http.StatusInternalServerError,
+			gin.H{"error": "Failed to generate client ID"},
+		)
+		return
+	}
+	client := &models.OAuthClient{
+		ClientID:     clientID,
+		Name:         req.Name,
+		Scope:        utils.FormatScope(scopes),
+		RedirectURIs: strings.Join(req.RedirectURIs, " "),
+		Public:       req.Public,
+		Introspect:   req.Introspect,
+	}
+	if !client.Public {
+		client.Secret, err = utils.GenerateRandomToken(32)
+		if err != nil {
+			c.JSON(
+				http.StatusInternalServerError,
+				gin.H{"error": "Failed to generate client secret"},
+			)
+			return
+		}
+	}
+	if err := h.OAuthClientStore.Create(client); err != nil {
+		c.JSON(
+			http.StatusInternalServerError,
+			gin.H{"error": "Failed to create client"},
+		)
+		return
+	}
+
+	response := oauthClientResponse(client)
+	if !client.Public {
+		response["client_secret"] = client.Secret
+	}
+	c.JSON(http.StatusCreated, response)
+}
+
+// ListClients handles listing the registered OAuth clients
+func (h *OAuthHandler) ListClients(c *gin.Context) {
+	clients, err := h.OAuthClientStore.List()
+	if err != nil {
+		c.JSON(
+			http.StatusInternalServerError,
+			gin.H{"error": "Failed to list clients"},
+		)
+		return
+	}
+
+	response := []gin.H{}
+	for i := range clients {
+		response = append(response, oauthClientResponse(&clients[i]))
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// RevokeClient handles revoking an OAuth client
+func (h *OAuthHandler) RevokeClient(c *gin.Context) {
+	// Parse client ID from URL
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid client ID"})
+		return
+	}
+
+	client, err := h.OAuthClientStore.GetByID(id)
+	if err != nil {
+		c.JSON(
+			http.StatusInternalServerError,
+			gin.H{"error": "Failed to get client"},
+		)
+		return
+	}
+	if client == nil || client.RevokedAt != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Client not found"})
+		return
+	}
+
+	if err := h.OAuthClientStore.Revoke(client.ID); err != nil {
+		c.JSON(
+			http.StatusInternalServerError,
+			gin.H{"error": "Failed to revoke client"},
+		)
+		return
+	}
+
+	// Access tokens the client already holds, its own and those of users
+	// signed in to it, stop working as well, and so do the sessions
+	h.RevokedTokenStore.RevokeClient(client.ClientID, time.Now())
+	if err := h.RefreshTokenStore.RevokeAllForClient(client.ClientID); err != nil {
+		c.JSON(
+			http.StatusInternalServerError,
+			gin.H{"error": "Failed to revoke refresh tokens"},
+		)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Client revoked successfully"})
+}
@@ -0,0 +1,547 @@
+package handlers
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/EngenMe/go-api-dod/internal/api/middleware"
+	"github.com/EngenMe/go-api-dod/internal/data/models"
+	"github.com/EngenMe/go-api-dod/internal/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// authorizePage is the sign-in and consent page of the authorization endpoint.
+// Users enter their credentials on every authorization since the API keeps
+// no browser session.
+var authorizePage = template.Must(template.New("authorize").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Sign in to {{.ClientName}}</title>
+</head>
+<body>
+{{if .Fatal}}
+<h1>Authorization failed</h1>
+<p>{{.Error}}</p>
+{{else}}
+<h1>Sign in to {{.ClientName}}</h1>
+<p>{{.ClientName}} is requesting access to:</p>
+<ul>
+{{range .Scopes}}<li>{{.}}</li>
+{{end}}</ul>
+{{if .Error}}<p role="alert">{{.Error}}</p>{{end}}
+<form method="post">
+<input type="hidden" name="response_type" value="{{.Request.ResponseType}}">
+<input type="hidden" name="client_id" value="{{.Request.ClientID}}">
+<input type="hidden" name="redirect_uri" value="{{.Request.RedirectURI}}">
+<input type="hidden" name="scope" value="{{.Request.Scope}}">
+<input type="hidden" name="state" value="{{.Request.State}}">
+<input type="hidden" name="nonce" value="{{.Request.Nonce}}">
+<input type="hidden" name="code_challenge" value="{{.Request.CodeChallenge}}">
+<input type="hidden" name="code_challenge_method" value="{{.Request.CodeChallengeMethod}}">
+<label>Email <input type="email" name="email" value="{{.Email}}" autocomplete="username" required></label>
+<label>Password <input type="password" name="password" autocomplete="current-password"></label>
+<label>Authentication code <input type="text" name="code" inputmode="numeric" autocomplete="one-time-code"></label>
+<button type="submit" name="action" value="approve">Allow</button>
+<button type="submit" name="action" value="deny" formnovalidate>Deny</button>
+</form>
+{{end}}
+</body>
+</html>
+`))
+
+// authorizeRequest holds the parameters of an authorization request
+// (RFC 6749 section 4.1.1, RFC 7636 section 4.3)
+type authorizeRequest struct {
+	ResponseType        string `form:"response_type"`
+	ClientID            string `form:"client_id"`
+	RedirectURI         string `form:"redirect_uri"`
+	Scope               string `form:"scope"`
+	State               string `form:"state"`
+	Nonce               string `form:"nonce"`
+	CodeChallenge       string `form:"code_challenge"`
+	CodeChallengeMethod string `form:"code_challenge_method"`
+}
+
+// authorizePageData is rendered by authorizePage
+type authorizePageData struct {
+	ClientName string
+	Scopes     []string
+	Request    authorizeRequest
+	Email      string
+	Error      string
+	Fatal      bool
+}
+
+// renderAuthorizePage writes the authorization page. It may be neither
+// framed nor cached.
+func renderAuthorizePage(c *gin.Context, status int, data authorizePageData) {
+	c.Header("Cache-Control", "no-store")
+	c.Header("X-Frame-Options", "DENY")
+	c.Header("Content-Security-Policy", "frame-ancestors 'none'")
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Status(status)
+	if err := authorizePage.Execute(c.Writer, data); err != nil {
+		log.Printf("failed to render authorization page: %v", err)
+	}
+}
+
+// redirectWithError sends the user agent back to the client with an OAuth2
+// error (RFC 6749 section 4.1.2.1)
+func redirectWithError(
+	c *gin.Context,
+	req *authorizeRequest,
+	code, description string,
+) {
+	redirectToClient(
+		c, req.RedirectURI, url.Values{
+			"error":             {code},
+			"error_description": {description},
+			"state":             {req.State},
+		},
+	)
+}
+
+// redirectToClient sends the user agent to the redirect URI with the
+// parameters added to its query. Empty parameters are left out.
+func redirectToClient(c *gin.Context, redirectURI string, params url.Values) {
+	// Registered redirect URIs have been validated when registering
+	target, _ := url.Parse(redirectURI)
+	query := target.Query()
+	for key, values := range params {
+		if values[0] != "" {
+			query.Set(key, values[0])
+		}
+	}
+	target.RawQuery = query.Encode()
+
+	c.Header("Cache-Control", "no-store")
+	c.Redirect(http.StatusFound, target.String())
+}
+
+// validateAuthorizeRequest loads the client and checks the request. Errors
+// that make the redirect URI untrustworthy are shown to the user, all others
+// are reported to the client. It returns the granted scopes on success.
+func (h *OAuthHandler) validateAuthorizeRequest(
+	c *gin.Context,
+	req *authorizeRequest,
+) (*models.OAuthClient, []string, bool) {
+	if err := c.ShouldBind(req); err != nil {
+		renderAuthorizePage(
+			c, http.StatusBadRequest, authorizePageData{
+				Error: "Invalid authorization request",
+				Fatal: true,
+			},
+		)
+		return nil, nil, false
+	}
+
+	client, err := h.OAuthClientStore.GetByClientID(req.ClientID)
+	if err != nil {
+		renderAuthorizePage(
+			c, http.StatusInternalServerError, authorizePageData{
+				Error: "Failed to get client",
+				Fatal: true,
+			},
+		)
+		return nil, nil, false
+	}
+	if client == nil {
+		renderAuthorizePage(
+			c, http.StatusBadRequest, authorizePageData{
+				Error: "Unknown client",
+				Fatal: true,
+			},
+		)
+		return nil, nil, false
+	}
+
+	// The redirect URI is required so that it can be checked again when
+	// the code is exchanged
+	if !client.AllowsRedirectURI(req.RedirectURI) {
+		renderAuthorizePage(
+			c, http.StatusBadRequest, authorizePageData{
+				ClientName: client.Name,
+				Error:      "Redirect URI is not registered for this client",
+				Fatal:      true,
+			},
+		)
+		return nil, nil, false
+	}
+
+	if req.ResponseType != "code" {
+		redirectWithError(
+			c,
+			req,
+			"unsupported_response_type",
+			"Only the code response type is supported",
+		)
+		return nil, nil, false
+	}
+
+	// PKCE is required of every client
+	if req.CodeChallenge == "" ||
+		req.CodeChallengeMethod != utils.PKCEMethodS256 {
+		redirectWithError(
+			c,
+			req,
+			"invalid_request",
+			"A code_challenge with the S256 method is required",
+		)
+		return nil, nil, false
+	}
+
+	scopes, err := utils.NarrowScope(req.Scope, client.Scopes())
+	if err != nil || len(scopes) == 0 {
+		redirectWithError(
+			c,
+			req,
+			"invalid_scope",
+			"Requested scope exceeds the scopes of the client",
+		)
+		return nil, nil, false
+	}
+
+	return client, scopes, true
+}
+
+// Authorize handles showing the sign-in and consent page of the
+// authorization code flow
+func (h *OAuthHandler) Authorize(c *gin.Context) {
+	var req authorizeRequest
+	client, scopes, ok := h.validateAuthorizeRequest(c, &req)
+	if !ok {
+		return
+	}
+
+	renderAuthorizePage(
+		c, http.StatusOK, authorizePageData{
+			ClientName: client.Name,
+			Scopes:     scopes,
+			Request:    req,
+		},
+	)
+}
+
+// Consent handles the submitted sign-in and consent page. Approving signs the
+// user in and redirects to the client with an authorization code.
+func (h *OAuthHandler) Consent(c *gin.Context) {
+	var req authorizeRequest
+	client, scopes, ok := h.validateAuthorizeRequest(c, &req)
+	if !ok {
+		return
+	}
+
+	if c.PostForm("action") != "approve" {
+		redirectWithError(
+			c,
+			&req,
+			"access_denied",
+			"The user denied the request",
+		)
+		return
+	}
+
+	// Failures re-render the page so the user can try again
+	page := authorizePageData{
+		ClientName: client.Name,
+		Scopes:     scopes,
+		Request:    req,
+		Email:      c.PostForm("email"),
+	}
+
+	user, status, message := h.authenticateUser(
+		c,
+		page.Email,
+		c.PostForm("password"),
+		c.PostForm("code"),
+	)
+	if user == nil {
+		page.Error = message
+		renderAuthorizePage(c, status, page)
+		return
+	}
+
+	code, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		page.Error = "Failed to generate authorization code"
+		renderAuthorizePage(c, http.StatusInternalServerError, page)
+		return
+	}
+
+	now := time.Now()
+	authorizationCode := &models.AuthorizationCode{
+		Code:          code,
+		ClientID:      client.ClientID,
+		UserID:        user.ID,
+		RedirectURI:   req.RedirectURI,
+		Scope:         utils.FormatScope(scopes),
+		Nonce:         req.Nonce,
+		CodeChallenge: req.CodeChallenge,
+		AuthTime:      now,
+		ExpiresAt:     now.Add(h.AuthHandler.Config.AuthorizationCodeExpiration),
+	}
+	if err := h.AuthorizationCodeStore.Create(authorizationCode); err != nil {
+		page.Error = "Failed to create authorization code"
+		renderAuthorizePage(c, http.StatusInternalServerError, page)
+		return
+	}
+
+	redirectToClient(
+		c, req.RedirectURI, url.Values{
+			"code":  {code},
+			"state": {req.State},
+		},
+	)
+}
+
+// authenticateUser checks the credentials entered on the authorization page
+// with the same throttling, lockout and MFA rules as /login. On failure it
+// returns the status and message to show instead of the user.
+func (h *OAuthHandler) authenticateUser(
+	c *gin.Context,
+	email, password, code string,
+) (*models.User, int, string) {
+	auth := h.AuthHandler
+
+	if allowed, _ := auth.LoginFailureLimiter.Check(c.ClientIP()); !allowed {
+		return nil, http.StatusTooManyRequests,
+			"Too many failed login attempts, please try again later"
+	}
+
+	user, err := auth.UserStore.GetByEmail(email)
+	if err != nil {
+		return nil, http.StatusInternalServerError, "Failed to get user"
+	}
+	if user == nil {
+		auth.recordLoginFailure(c, nil)
+		return nil, http.StatusUnauthorized, "Invalid email or password"
+	}
+
+	if user.IsLocked() {
+		// Attempts against a locked account still count against the IP
+		auth.LoginFailureLimiter.Hit(c.ClientIP())
+		auth.recordLoginEvent(
+			c,
+			user,
+			models.LoginMethodOAuth,
+			models.LoginFailureAccountLocked,
+		)
+		return nil, http.StatusLocked,
+			"Account temporarily locked due to too many failed login attempts"
+	}
+
+	if !auth.PasswordHasher.Check(password, user.Password) {
+		auth.recordLoginFailure(c, user)
+		auth.recordLoginEvent(
+			c,
+			user,
+			models.LoginMethodOAuth,
+			models.LoginFailureInvalidPassword,
+		)
+		return nil, http.StatusUnauthorized, "Invalid email or password"
+	}
+
+	// Upgrade hashes made with a legacy algorithm or outdated cost
+	if auth.PasswordHasher.NeedsRehash(user.Password) {
+		auth.rehashPassword(user, password)
+	}
+
+	if user.IsMFAEnabled() {
+		if code == "" {
+			return nil, http.StatusUnauthorized,
+				"Enter the code from your authenticator app"
+		}
+
+		valid, err := verifySecondFactor(
+			auth.UserStore,
+			auth.MFARecoveryCodeStore,
+			user,
+			code,
+			"",
+		)
+		if err != nil {
+			return nil, http.StatusInternalServerError,
+				"Failed to verify MFA code"
+		}
+		if !valid {
+			auth.recordLoginFailure(c, user)
+			auth.recordLoginEvent(
+				c,
+				user,
+				models.LoginMethodOAuth,
+				models.LoginFailureInvalidMFACode,
+			)
+			return nil, http.StatusUnauthorized, "Invalid MFA code"
+		}
+	}
+
+	if auth.Config.RequireEmailVerification && !user.IsEmailVerified() {
+		auth.recordLoginEvent(
+			c,
+			user,
+			models.LoginMethodOAuth,
+			models.LoginFailureEmailNotVerified,
+		)
+		return nil, http.StatusForbidden, "Email address not verified"
+	}
+
+	auth.recordLoginSuccess(user)
+	auth.recordLoginEvent(c, user, models.LoginMethodOAuth, "")
+	return user, http.StatusOK, ""
+}
+
+// authorizationCodeGrant exchanges an authorization code for tokens
+// (RFC 6749 section 4.1.3). Confidential clients authenticate with their
+// secret, public clients prove possession of the PKCE verifier only.
+func (h *OAuthHandler) authorizationCodeGrant(c *gin.Context) {
+	client, ok := h.authenticateTokenClient(c)
+	if !ok {
+		return
+	}
+
+	code := c.PostForm("code")
+	verifier := c.PostForm("code_verifier")
+	if code == "" || verifier == "" {
+		oauthError(
+			c,
+			http.StatusBadRequest,
+			"invalid_request",
+			"code and code_verifier are required",
+		)
+		return
+	}
+
+	// Codes are single use, even when the exchange fails below
+	authorizationCode, err := h.AuthorizationCodeStore.Consume(code)
+	if err != nil {
+		oauthError(
+			c,
+			http.StatusInternalServerError,
+			"server_error",
+			"Failed to get authorization code",
+		)
+		return
+	}
+	if authorizationCode == nil ||
+		authorizationCode.ClientID != client.ClientID ||
+		authorizationCode.RedirectURI != c.PostForm("redirect_uri") ||
+		!utils.VerifyPKCE(verifier, authorizationCode.CodeChallenge) {
+		oauthError(
+			c,
+			http.StatusBadRequest,
+			"invalid_grant",
+			"Invalid or expired authorization code",
+		)
+		return
+	}
+
+	user, err := h.AuthHandler.UserStore.GetByID(authorizationCode.UserID)
+	if err != nil {
+		oauthError(
+			c,
+			http.StatusInternalServerError,
+			"server_error",
+			"Failed to get user",
+		)
+		return
+	}
+	if user == nil {
+		oauthError(
+			c,
+			http.StatusBadRequest,
+			"invalid_grant",
+			"Invalid or expired authorization code",
+		)
+		return
+	}
+
+	scopes := utils.ParseScope(authorizationCode.Scope)
+	tokens, err := h.AuthHandler.issueTokens(
+		c,
+		user,
+		scopes,
+		models.LoginMethodOAuth,
+		client.ClientID,
+		nil,
+	)
+	if err != nil {
+		oauthError(
+			c,
+			http.StatusInternalServerError,
+			"server_error",
+			"Failed to issue tokens",
+		)
+		return
+	}
+	response := h.AuthHandler.tokenResponse(tokens)
+
+	// OpenID Connect clients also get an ID token
+	if utils.HasScope(scopes, utils.ScopeOpenID) {
+		var email string
+		if utils.HasScope(scopes, utils.ScopeEmail) {
+			email = user.Email
+		}
+		idToken, err := h.TokenManager.GenerateIDToken(
+			user.ID,
+			client.ClientID,
+			authorizationCode.Nonce,
+			authorizationCode.AuthTime,
+			email,
+			user.IsEmailVerified(),
+		)
+		if err != nil {
+			oauthError(
+				c,
+				http.StatusInternalServerError,
+				"server_error",
+				"Failed to generate ID token",
+			)
+			return
+		}
+		response["id_token"] = idToken
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, response)
+}
+
+// UserInfo handles returning the claims about the authenticated user allowed
+// by the scopes of the access token (OpenID Connect Core section 5.3)
+func (h *OAuthHandler) UserInfo(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	user, err := h.AuthHandler.UserStore.GetByID(userID)
+	if err != nil {
+		c.JSON(
+			http.StatusInternalServerError,
+			gin.H{"error": "Failed to get user"},
+		)
+		return
+	}
+	if user == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	response := gin.H{"sub": user.ID}
+	scopes := middleware.Scopes(c)
+	if utils.HasScope(scopes, utils.ScopeProfile) {
+		response["updated_at"] = user.UpdatedAt.Unix()
+	}
+	if utils.HasScope(scopes, utils.ScopeEmail) {
+		response["email"] = user.Email
+		response["email_verified"] = user.IsEmailVerified()
+	}
+
+	c.JSON(http.StatusOK, response)
+}
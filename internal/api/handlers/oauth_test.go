@@ -0,0 +1,334 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/EngenMe/go-api-dod/internal/api/middleware"
+	"github.com/EngenMe/go-api-dod/internal/data/models"
+	"github.com/EngenMe/go-api-dod/internal/data/store"
+	"github.com/EngenMe/go-api-dod/internal/utils"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// oauthFixture is an OAuthHandler wired for the token endpoints
+type oauthFixture struct {
+	handler *OAuthHandler
+	hasher  *utils.TokenHasher
+	mock    sqlmock.Sqlmock
+	router  *gin.Engine
+}
+
+func newOAuthFixture(t *testing.T) *oauthFixture {
+	db, mock := newTestDB(t)
+	hasher := utils.NewTokenHasher("pepper")
+	revokedTokenStore := store.NewRevokedTokenStore(db, time.Minute, 15*time.Minute)
+	refreshTokenStore := store.NewRefreshTokenStore(db, hasher, revokedTokenStore)
+	tokenManager := newTestTokenManager(t)
+	f := &oauthFixture{
+		handler: &OAuthHandler{
+			AuthHandler: &AuthHandler{
+				RefreshTokenStore: refreshTokenStore,
+				TokenManager:      tokenManager,
+			},
+			OAuthClientStore:  store.NewOAuthClientStore(db, hasher),
+			RefreshTokenStore: refreshTokenStore,
+			RevokedTokenStore: revokedTokenStore,
+			TokenManager:      tokenManager,
+		},
+		hasher: hasher,
+		mock:   mock,
+		router: gin.New(),
+	}
+	f.router.POST("/oauth/token", f.handler.Token)
+	f.router.POST("/oauth/introspect", f.handler.Introspect)
+	f.router.POST("/oauth/revoke", f.handler.Revoke)
+	f.router.POST("/oauth/clients", f.handler.CreateClient)
+	f.router.DELETE("/oauth/clients/:id", f.handler.RevokeClient)
+
+	return f
+}
+
+// expectClient answers the lookup of the calling client, whose secret is
+// "secret"
+func (f *oauthFixture) expectClient(clientID string, introspect bool) {
+	f.mock.ExpectQuery(`FROM oauth_clients\s+WHERE client_id = \$1`).
+		WillReturnRows(
+			sqlmock.NewRows([]string{"id", "client_id", "secret_hash", "scope", "introspect"}).
+				AddRow(uuid.New(), clientID, f.hasher.Hash("secret"), "users:read users:write", introspect),
+		)
+}
+
+// post sends a token to an endpoint authenticated as the client
+func (f *oauthFixture) post(
+	t *testing.T,
+	path, clientID, token string,
+) (*httptest.ResponseRecorder, map[string]interface{}) {
+	t.Helper()
+	return f.postForm(t, path, clientID, url.Values{"token": {token}})
+}
+
+// postForm sends a form request authenticated as the client
+func (f *oauthFixture) postForm(
+	t *testing.T,
+	path, clientID string,
+	form url.Values,
+) (*httptest.ResponseRecorder, map[string]interface{}) {
+	t.Helper()
+
+	req := httptest.NewRequest(
+		http.MethodPost,
+		path,
+		strings.NewReader(form.Encode()),
+	)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(clientID, "secret")
+	return performHTTPRequest(f.router, req)
+}
+
+// clientToken issues a client credentials token to the client
+func (f *oauthFixture) clientToken(t *testing.T, clientID string) string {
+	token, err := f.handler.TokenManager.GenerateClientAccessToken(
+		clientID,
+		[]string{utils.ScopeUsersRead},
+	)
+	if err != nil {
+		t.Fatalf("failed to generate client token: %v", err)
+	}
+	return token
+}
+
+// userToken issues a user access token to the client, or to a first-party
+// login when clientID is empty
+func (f *oauthFixture) userToken(t *testing.T, clientID string) string {
+	token, err := f.handler.TokenManager.GenerateAccessToken(
+		uuid.New(),
+		"user@example.com",
+		"user",
+		utils.DefaultScopes,
+		clientID,
+		"",
+	)
+	if err != nil {
+		t.Fatalf("failed to generate access token: %v", err)
+	}
+	return token
+}
+
+func TestRevokeRefusesTokensOfOtherClients(t *testing.T) {
+	f := newOAuthFixture(t)
+
+	tokens := map[string]string{
+		"client token of another client": f.clientToken(t, "billing"),
+		"user token of another client":   f.userToken(t, "billing"),
+		"user token of a direct login":   f.userToken(t, ""),
+	}
+	for name, token := range tokens {
+		f.expectClient("app", false)
+
+		// A revocation would be an unexpected query
+		w, body := f.post(t, "/oauth/revoke", "app", token)
+		if w.Code != http.StatusBadRequest || body["error"] != "unauthorized_client" {
+			t.Errorf("%s: status = %d, body = %v", name, w.Code, body)
+		}
+	}
+}
+
+func TestRevokeOwnToken(t *testing.T) {
+	f := newOAuthFixture(t)
+	f.expectClient("app", false)
+	f.mock.ExpectExec(`INSERT INTO revoked_tokens`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	w, body := f.post(t, "/oauth/revoke", "app", f.userToken(t, "app"))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %v", w.Code, body)
+	}
+}
+
+func TestIntrospectHidesTokensOfOtherClients(t *testing.T) {
+	f := newOAuthFixture(t)
+
+	for _, token := range []string{f.clientToken(t, "billing"), f.userToken(t, "")} {
+		f.expectClient("app", false)
+
+		w, body := f.post(t, "/oauth/introspect", "app", token)
+		if w.Code != http.StatusOK || body["active"] != false {
+			t.Errorf("status = %d, body = %v", w.Code, body)
+		}
+	}
+}
+
+func TestIntrospectOwnToken(t *testing.T) {
+	f := newOAuthFixture(t)
+	f.expectClient("app", false)
+	expectEmptyRevocations(f.mock)
+
+	w, body := f.post(t, "/oauth/introspect", "app", f.userToken(t, "app"))
+	if w.Code != http.StatusOK || body["active"] != true {
+		t.Fatalf("status = %d, body = %v", w.Code, body)
+	}
+	if body["client_id"] != "app" {
+		t.Errorf("client_id = %v", body["client_id"])
+	}
+}
+
+func TestIntrospectionClientSeesEveryToken(t *testing.T) {
+	f := newOAuthFixture(t)
+	f.expectClient("gateway", true)
+	expectEmptyRevocations(f.mock)
+
+	w, body := f.post(t, "/oauth/introspect", "gateway", f.clientToken(t, "billing"))
+	if w.Code != http.StatusOK || body["active"] != true {
+		t.Fatalf("status = %d, body = %v", w.Code, body)
+	}
+}
+
+func TestClientCredentialsTokenCallsUserRoutes(t *testing.T) {
+	f := newOAuthFixture(t)
+	auth := middleware.NewAuthMiddleware(
+		f.handler.TokenManager,
+		nil,
+		nil,
+		f.handler.RevokedTokenStore,
+	)
+	users := NewUserHandler(
+		store.NewUserStore(f.handler.OAuthClientStore.DB, nil, nil),
+		nil,
+		&utils.PasswordPolicy{MinLength: 8, MaxLength: 128},
+	)
+	api := f.router.Group("/", auth.RequireAuth())
+	api.GET(
+		"/users",
+		auth.RequireScope(utils.ScopeUsersRead),
+		auth.RequirePermission(models.PermissionUsersRead),
+		users.ListUsers,
+	)
+	api.POST(
+		"/users",
+		auth.RequireScope(utils.ScopeUsersWrite),
+		auth.RequirePermission(models.PermissionUsersWrite),
+		users.CreateUser,
+	)
+
+	f.expectClient("billing", false)
+	w, body := f.postForm(
+		t, "/oauth/token", "billing",
+		url.Values{"grant_type": {"client_credentials"}},
+	)
+	if w.Code != http.StatusOK {
+		t.Fatalf("token status = %d: %v", w.Code, body)
+	}
+	accessToken, _ := body["access_token"].(string)
+
+	expectEmptyRevocations(f.mock)
+	f.mock.ExpectQuery(`FROM users`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email", "role"}).
+			AddRow(uuid.New(), "user@example.com", "user"))
+
+	req := httptest.NewRequest(http.MethodGet, "/users", nil)
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	w, _ = performHTTPRequest(f.router, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /users = %d, want 200: %s", w.Code, w.Body)
+	}
+
+	// The users:write scope lets the client create users, but not admins
+	req = httptest.NewRequest(
+		http.MethodPost,
+		"/users",
+		strings.NewReader(`{"email":"new@example.com","password":"a long passphrase","role":"admin"}`),
+	)
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Content-Type", "application/json")
+	w, _ = performHTTPRequest(f.router, req)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("POST /users as admin = %d, want 403: %s", w.Code, w.Body)
+	}
+}
+
+func TestRefreshTokenGrant(t *testing.T) {
+	tests := []struct {
+		name     string
+		clientID string // client the refresh token was issued to
+		expect   func(f *oauthFixture)
+	}{
+		{
+			name:     "token of another client",
+			clientID: "billing",
+		},
+		{
+			name:     "token of a direct login",
+			clientID: "",
+		},
+		{
+			name:     "unknown token",
+			clientID: "app",
+			expect: func(f *oauthFixture) {
+				f.mock.ExpectQuery(`FROM refresh_tokens\s+WHERE token_hash = \$1`).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}))
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				f := newOAuthFixture(t)
+				refreshToken, err := f.handler.TokenManager.GenerateRefreshToken(
+					uuid.New(),
+					"user@example.com",
+					utils.DefaultScopes,
+					tt.clientID,
+				)
+				if err != nil {
+					t.Fatalf("failed to generate refresh token: %v", err)
+				}
+				// Looked up once to tell public clients and once to authenticate
+				f.expectClient("app", false)
+				f.expectClient("app", false)
+				if tt.expect != nil {
+					tt.expect(f)
+				}
+
+				w, body := f.postForm(
+					t, "/oauth/token", "app",
+					url.Values{
+						"grant_type":    {"refresh_token"},
+						"refresh_token": {refreshToken},
+					},
+				)
+				if w.Code != http.StatusBadRequest || body["error"] != "invalid_grant" {
+					t.Errorf("status = %d, body = %v", w.Code, body)
+				}
+			},
+		)
+	}
+}
+
+func TestRevokeClientEndsTheSessionsOfItsUsers(t *testing.T) {
+	f := newOAuthFixture(t)
+	id := uuid.New()
+
+	f.mock.ExpectQuery(`FROM oauth_clients\s+WHERE id = \$1`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "client_id"}).AddRow(id, "app"))
+	f.mock.ExpectExec(`UPDATE oauth_clients`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	f.mock.ExpectExec(`UPDATE refresh_tokens\s+SET revoked_at`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), "app").
+		WillReturnResult(sqlmock.NewResult(0, 3))
+
+	w, body := performRequest(
+		t, f.router, http.MethodDelete, "/oauth/clients/"+id.String(), nil,
+	)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %v", w.Code, body)
+	}
+}
@@ -0,0 +1,520 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/EngenMe/go-api-dod/internal/data/models"
+	"github.com/EngenMe/go-api-dod/internal/data/store"
+	"github.com/EngenMe/go-api-dod/internal/oidc"
+	"github.com/EngenMe/go-api-dod/internal/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// oidcLoginTimeout bounds how long a user may take at the identity provider
+const oidcLoginTimeout = 10 * time.Minute
+
+// oidcStateCookie holds the state token of a federated login in progress
+const oidcStateCookie = "oidc_state"
+
+// OIDCHandler provides handlers for signing in with external OpenID Connect
+// identity providers
+type OIDCHandler struct {
+	AuthHandler         *AuthHandler
+	LinkedIdentityStore *store.LinkedIdentityStore
+	Providers           map[string]*oidc.Provider
+	BaseURL             string
+}
+
+// NewOIDCHandler creates a new OIDCHandler
+func NewOIDCHandler(
+	authHandler *AuthHandler,
+	linkedIdentityStore *store.LinkedIdentityStore,
+	providers map[string]*oidc.Provider,
+	baseURL string,
+) *OIDCHandler {
+	return &OIDCHandler{
+		AuthHandler:         authHandler,
+		LinkedIdentityStore: linkedIdentityStore,
+		Providers:           providers,
+		BaseURL:             baseURL,
+	}
+}
+
+// provider returns the provider named in the URL, writing a 404 if unknown
+func (h *OIDCHandler) provider(c *gin.Context) (*oidc.Provider, bool) {
+	provider, ok := h.Providers[c.Param("provider")]
+	if !ok {
+		c.JSON(
+			http.StatusNotFound,
+			gin.H{"error": "Unknown identity provider"},
+		)
+		return nil, false
+	}
+
+	return provider, true
+}
+
+// callbackPath returns the path of the provider's callback, which also
+// scopes the state cookie
+func callbackPath(provider *oidc.Provider) string {
+	return "/api/v1/auth/oidc/" + provider.Config.Name + "/callback"
+}
+
+// setStateCookie stores the state token in the browser, or clears it when
+// the token is empty
+func (h *OIDCHandler) setStateCookie(
+	c *gin.Context,
+	provider *oidc.Provider,
+	stateToken string,
+) {
+	maxAge := int(oidcLoginTimeout.Seconds())
+	if stateToken == "" {
+		maxAge = -1
+	}
+
+	// Lax lets the cookie through on the redirect back from the provider
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(
+		oidcStateCookie,
+		stateToken,
+		maxAge,
+		callbackPath(provider),
+		"",
+		strings.HasPrefix(h.BaseURL, "https://"),
+		true,
+	)
+}
+
+// Start handles starting a login with an identity provider. The browser is
+// redirected to the provider with a fresh state, nonce and PKCE challenge.
+func (h *OIDCHandler) Start(c *gin.Context) {
+	provider, ok := h.provider(c)
+	if !ok {
+		return
+	}
+
+	authURL, ok := h.startFlow(c, provider, uuid.Nil)
+	if !ok {
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// Link handles a signed in user starting to link an identity at the provider
+// to their account. The browser has to be sent to the returned URL; the
+// callback then links the identity instead of logging in.
+func (h *OIDCHandler) Link(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	provider, ok := h.provider(c)
+	if !ok {
+		return
+	}
+
+	authURL, ok := h.startFlow(c, provider, userID)
+	if !ok {
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, gin.H{"authorization_url": authURL})
+}
+
+// startFlow stores a fresh state in the browser and returns the URL of the
+// provider's authorization endpoint, writing an error if that fails
+func (h *OIDCHandler) startFlow(
+	c *gin.Context,
+	provider *oidc.Provider,
+	linkUserID uuid.UUID,
+) (string, bool) {
+	state, err := utils.GenerateRandomToken(16)
+	if err != nil {
+		c.JSON(
+			http.StatusInternalServerError,
+			gin.H{"error": "Failed to generate state"},
+		)
+		return "", false
+	}
+	nonce, err := utils.GenerateRandomToken(16)
+	if err != nil {
+		c.JSON(
+			http.StatusInternalServerError,
+			gin.H{"error": "Failed to generate nonce"},
+		)
+		return "", false
+	}
+	codeVerifier, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		c.JSON(
+			http.StatusInternalServerError,
+			gin.H{"error": "Failed to generate code verifier"},
+		)
+		return "", false
+	}
+
+	authURL, err := provider.AuthCodeURL(
+		c.Request.Context(),
+		h.BaseURL+callbackPath(provider),
+		state,
+		nonce,
+		utils.PKCEChallenge(codeVerifier),
+	)
+	if err != nil {
+		log.Printf("oidc provider %s: %v", provider.Config.Name, err)
+		c.JSON(
+			http.StatusBadGateway,
+			gin.H{"error": "Identity provider unavailable"},
+		)
+		return "", false
+	}
+
+	stateToken, err := h.AuthHandler.TokenManager.GenerateOIDCStateToken(
+		provider.Config.Name,
+		state,
+		nonce,
+		codeVerifier,
+		linkUserID,
+		oidcLoginTimeout,
+	)
+	if err != nil {
+		c.JSON(
+			http.StatusInternalServerError,
+			gin.H{"error": "Failed to generate state token"},
+		)
+		return "", false
+	}
+
+	h.setStateCookie(c, provider, stateToken)
+	return authURL, true
+}
+
+// Callback handles the redirect back from an identity provider. The code is
+// exchanged for an ID token, whose identity is mapped to a user who is then
+// logged in like at /login.
+func (h *OIDCHandler) Callback(c *gin.Context) {
+	provider, ok := h.provider(c)
+	if !ok {
+		return
+	}
+
+	// The state must match the one stored in this browser, which prevents
+	// logging a victim into an attacker's account
+	stateToken, _ := c.Cookie(oidcStateCookie)
+	h.setStateCookie(c, provider, "")
+	claims, err := h.AuthHandler.TokenManager.ValidateOIDCStateToken(stateToken)
+	if err != nil ||
+		claims.Provider != provider.Config.Name ||
+		subtle.ConstantTimeCompare(
+			[]byte(claims.ID),
+			[]byte(c.Query("state")),
+		) != 1 {
+		c.JSON(
+			http.StatusBadRequest,
+			gin.H{"error": "Invalid or expired login state"},
+		)
+		return
+	}
+
+	if c.Query("error") != "" {
+		c.JSON(
+			http.StatusUnauthorized,
+			gin.H{"error": "Identity provider login failed"},
+		)
+		return
+	}
+
+	code := c.Query("code")
+	if code == "" {
+		c.JSON(
+			http.StatusBadRequest,
+			gin.H{"error": "Authorization code is required"},
+		)
+		return
+	}
+
+	idClaims, err := provider.Exchange(
+		c.Request.Context(),
+		code,
+		claims.CodeVerifier,
+		h.BaseURL+callbackPath(provider),
+		claims.Nonce,
+	)
+	if err != nil {
+		log.Printf("oidc provider %s: %v", provider.Config.Name, err)
+		c.JSON(
+			http.StatusBadGateway,
+			gin.H{"error": "Failed to complete identity provider login"},
+		)
+		return
+	}
+
+	// A signed in user started the flow to link the identity
+	if claims.LinkUserID != uuid.Nil {
+		h.linkIdentity(c, provider, idClaims, claims.LinkUserID)
+		return
+	}
+
+	user, ok := h.linkedUser(c, provider, idClaims)
+	if !ok {
+		return
+	}
+
+	if h.AuthHandler.rejectLockedUser(c, user) {
+		h.AuthHandler.recordLoginEvent(
+			c,
+			user,
+			models.LoginMethodOIDC,
+			models.LoginFailureAccountLocked,
+		)
+		return
+	}
+
+	if h.AuthHandler.Config.RequireEmailVerification && !user.IsEmailVerified() {
+		h.AuthHandler.recordLoginEvent(
+			c,
+			user,
+			models.LoginMethodOIDC,
+			models.LoginFailureEmailNotVerified,
+		)
+		c.JSON(
+			http.StatusForbidden,
+			gin.H{"error": "Email address not verified"},
+		)
+		return
+	}
+
+	h.AuthHandler.completeLogin(
+		c,
+		user,
+		utils.DefaultScopes,
+		models.LoginMethodOIDC,
+	)
+}
+
+// linkIdentity links the external identity to the signed in user who started
+// the flow
+func (h *OIDCHandler) linkIdentity(
+	c *gin.Context,
+	provider *oidc.Provider,
+	idClaims *oidc.IDTokenClaims,
+	userID uuid.UUID,
+) {
+	identity, err := h.LinkedIdentityStore.GetByIssuerSubject(
+		idClaims.Issuer,
+		idClaims.Subject,
+	)
+	if err != nil {
+		c.JSON(
+			http.StatusInternalServerError,
+			gin.H{"error": "Failed to get linked identity"},
+		)
+		return
+	}
+	if identity != nil {
+		if identity.UserID != userID {
+			c.JSON(
+				http.StatusConflict,
+				gin.H{"error": "Identity is linked to another account"},
+			)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Identity already linked"})
+		return
+	}
+
+	user, err := h.AuthHandler.UserStore.GetByID(userID)
+	if err != nil {
+		c.JSON(
+			http.StatusInternalServerError,
+			gin.H{"error": "Failed to get user"},
+		)
+		return
+	}
+	if user == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	identity = &models.LinkedIdentity{
+		UserID:   user.ID,
+		Provider: provider.Config.Name,
+		Issuer:   idClaims.Issuer,
+		Subject:  idClaims.Subject,
+		Email:    idClaims.Email,
+	}
+	if err := h.LinkedIdentityStore.Create(identity); err != nil {
+		c.JSON(
+			http.StatusInternalServerError,
+			gin.H{"error": "Failed to link identity"},
+		)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Identity linked successfully"})
+}
+
+// linkedUser returns the user linked to the external identity. Unknown
+// identities are linked to the account with the same verified email address
+// if the provider opted in and the account is no admin, or, if the provider
+// allows it, to a newly created user.
+func (h *OIDCHandler) linkedUser(
+	c *gin.Context,
+	provider *oidc.Provider,
+	idClaims *oidc.IDTokenClaims,
+) (*models.User, bool) {
+	identity, err := h.LinkedIdentityStore.GetByIssuerSubject(
+		idClaims.Issuer,
+		idClaims.Subject,
+	)
+	if err != nil {
+		c.JSON(
+			http.StatusInternalServerError,
+			gin.H{"error": "Failed to get linked identity"},
+		)
+		return nil, false
+	}
+
+	if identity != nil {
+		user, err := h.AuthHandler.UserStore.GetByID(identity.UserID)
+		if err != nil {
+			c.JSON(
+				http.StatusInternalServerError,
+				gin.H{"error": "Failed to get user"},
+			)
+			return nil, false
+		}
+		if user != nil {
+			err = h.LinkedIdentityStore.RecordLogin(identity.ID, idClaims.Email)
+			if err != nil {
+				log.Printf("failed to record linked identity login: %v", err)
+			}
+			return user, true
+		}
+
+		// The user was deleted, so the identity starts over
+		if err := h.LinkedIdentityStore.Delete(identity.ID); err != nil {
+			c.JSON(
+				http.StatusInternalServerError,
+				gin.H{"error": "Failed to delete linked identity"},
+			)
+			return nil, false
+		}
+	}
+
+	if idClaims.Email == "" {
+		c.JSON(
+			http.StatusForbidden,
+			gin.H{"error": "Identity provider did not share an email address"},
+		)
+		return nil, false
+	}
+
+	identity = &models.LinkedIdentity{
+		Provider: provider.Config.Name,
+		Issuer:   idClaims.Issuer,
+		Subject:  idClaims.Subject,
+		Email:    idClaims.Email,
+	}
+
+	user, err := h.AuthHandler.UserStore.GetByEmail(idClaims.Email)
+	if err != nil {
+		c.JSON(
+			http.StatusInternalServerError,
+			gin.H{"error": "Failed to get user"},
+		)
+		return nil, false
+	}
+	if user != nil {
+		// Only a verified address proves the identity owns the account, and
+		// admins must link their identities while signed in
+		if !idClaims.EmailVerified ||
+			!provider.Config.LinkExistingUsers ||
+			user.Role == models.RoleAdmin {
+			c.JSON(
+				http.StatusConflict,
+				gin.H{"error": "User with this email already exists, sign in to link this identity"},
+			)
+			return nil, false
+		}
+
+		identity.UserID = user.ID
+		if err := h.LinkedIdentityStore.Create(identity); err != nil {
+			c.JSON(
+				http.StatusInternalServerError,
+				gin.H{"error": "Failed to link identity"},
+			)
+			return nil, false
+		}
+		return user, true
+	}
+
+	if !provider.Config.CreateUsers {
+		c.JSON(
+			http.StatusForbidden,
+			gin.H{"error": "No account is linked to this identity"},
+		)
+		return nil, false
+	}
+
+	user, ok := h.newFederatedUser(c, idClaims)
+	if !ok {
+		return nil, false
+	}
+	if err := h.LinkedIdentityStore.CreateWithUser(user, identity); err != nil {
+		c.JSON(
+			http.StatusInternalServerError,
+			gin.H{"error": "Failed to create user"},
+		)
+		return nil, false
+	}
+
+	return user, true
+}
+
+// newFederatedUser builds a user for an external identity. Its password is
+// random and unknown, so it can only sign in through the provider until a
+// password is set with the reset flow.
+func (h *OIDCHandler) newFederatedUser(
+	c *gin.Context,
+	idClaims *oidc.IDTokenClaims,
+) (*models.User, bool) {
+	password, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		c.JSON(
+			http.StatusInternalServerError,
+			gin.H{"error": "Failed to generate password"},
+		)
+		return nil, false
+	}
+	hashedPassword, err := h.AuthHandler.PasswordHasher.Hash(password)
+	if err != nil {
+		c.JSON(
+			http.StatusInternalServerError,
+			gin.H{"error": "Failed to hash password"},
+		)
+		return nil, false
+	}
+
+	user := &models.User{
+		Email:    idClaims.Email,
+		Password: hashedPassword,
+	}
+	if idClaims.EmailVerified {
+		now := time.Now()
+		user.EmailVerifiedAt = &now
+	}
+
+	return user, true
+}
@@ -0,0 +1,295 @@
+package handlers
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/EngenMe/go-api-dod/config"
+	"github.com/EngenMe/go-api-dod/internal/data/store"
+	"github.com/EngenMe/go-api-dod/internal/oidc"
+	"github.com/EngenMe/go-api-dod/internal/utils"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// stubIdP is an identity provider that redeems the code "code" for an ID
+// token of a fixed user
+type stubIdP struct {
+	server        *httptest.Server
+	key           *utils.SigningKey
+	email         string
+	emailVerified bool
+}
+
+func newStubIdP(t *testing.T) *stubIdP {
+	t.Helper()
+
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		t.Fatalf("failed to encode key: %v", err)
+	}
+	key, err := utils.ParseSigningKey(
+		"stub",
+		utils.AlgorithmEdDSA,
+		pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}),
+	)
+	if err != nil {
+		t.Fatalf("failed to load key: %v", err)
+	}
+
+	idp := &stubIdP{key: key, email: "user@example.com", emailVerified: true}
+	router := gin.New()
+	router.GET("/.well-known/openid-configuration", idp.discovery)
+	router.GET("/jwks", idp.jwks)
+	router.POST("/token", idp.token)
+	idp.server = httptest.NewServer(router)
+	t.Cleanup(idp.server.Close)
+
+	return idp
+}
+
+func (p *stubIdP) discovery(c *gin.Context) {
+	c.JSON(
+		http.StatusOK, gin.H{
+			"issuer":                 p.server.URL,
+			"authorization_endpoint": p.server.URL + "/authorize",
+			"token_endpoint":         p.server.URL + "/token",
+			"jwks_uri":               p.server.URL + "/jwks",
+		},
+	)
+}
+
+func (p *stubIdP) jwks(c *gin.Context) {
+	jwk, _ := p.key.JWK()
+	c.JSON(http.StatusOK, utils.JWKSet{Keys: []utils.JWK{jwk}})
+}
+
+func (p *stubIdP) token(c *gin.Context) {
+	if c.PostForm("code") != "code" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_grant"})
+		return
+	}
+
+	now := time.Now()
+	token := jwt.NewWithClaims(
+		p.key.Method, jwt.MapClaims{
+			"iss":            p.server.URL,
+			"sub":            "subject",
+			"aud":            "go-api-dod",
+			"iat":            now.Unix(),
+			"exp":            now.Add(time.Minute).Unix(),
+			"nonce":          "nonce",
+			"email":          p.email,
+			"email_verified": p.emailVerified,
+		},
+	)
+	token.Header["kid"] = p.key.ID
+	idToken, err := token.SignedString(p.key.SignKey)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"id_token": idToken, "token_type": "Bearer"})
+}
+
+// oidcFixture is an OIDCHandler signing in with a stub identity provider
+type oidcFixture struct {
+	handler *OIDCHandler
+	idp     *stubIdP
+	mock    sqlmock.Sqlmock
+	router  *gin.Engine
+}
+
+func newOIDCFixture(t *testing.T, cfg config.OIDCProviderConfig) *oidcFixture {
+	db, mock := newTestDB(t)
+	idp := newStubIdP(t)
+	tokenHasher := utils.NewTokenHasher("pepper")
+
+	cfg.Name = "stub"
+	cfg.Issuer = idp.server.URL
+	cfg.ClientID = "go-api-dod"
+	cfg.Scopes = []string{"openid", "email"}
+	authHandler := &AuthHandler{
+		UserStore: store.NewUserStore(db, newTestSecretBox(t), nil),
+		RefreshTokenStore: store.NewRefreshTokenStore(
+			db,
+			tokenHasher,
+			store.NewRevokedTokenStore(db, time.Minute, 15*time.Minute),
+		),
+		LoginEventStore:     store.NewLoginEventStore(db),
+		TokenManager:        newTestTokenManager(t),
+		LoginFailureLimiter: utils.NewRateLimiter(10, time.Minute),
+	}
+	f := &oidcFixture{
+		handler: NewOIDCHandler(
+			authHandler,
+			store.NewLinkedIdentityStore(db),
+			oidc.NewProviders([]config.OIDCProviderConfig{cfg}),
+			"http://localhost:8080",
+		),
+		idp:    idp,
+		mock:   mock,
+		router: gin.New(),
+	}
+	f.router.GET("/api/v1/auth/oidc/:provider/callback", f.handler.Callback)
+
+	return f
+}
+
+// callback returns from the provider to a flow started by linkUserID, or by
+// an anonymous login when it is uuid.Nil
+func (f *oidcFixture) callback(
+	t *testing.T,
+	linkUserID uuid.UUID,
+) (*httptest.ResponseRecorder, map[string]interface{}) {
+	t.Helper()
+
+	stateToken, err := f.handler.AuthHandler.TokenManager.GenerateOIDCStateToken(
+		"stub",
+		"state",
+		"nonce",
+		"verifier",
+		linkUserID,
+		time.Minute,
+	)
+	if err != nil {
+		t.Fatalf("failed to generate state token: %v", err)
+	}
+
+	req := httptest.NewRequest(
+		http.MethodGet,
+		"/api/v1/auth/oidc/stub/callback?state=state&code=code",
+		nil,
+	)
+	req.AddCookie(&http.Cookie{Name: oidcStateCookie, Value: stateToken})
+	return performHTTPRequest(f.router, req)
+}
+
+// expectNoIdentity answers the identity lookup with no linked identity
+func (f *oidcFixture) expectNoIdentity() {
+	f.mock.ExpectQuery(`FROM linked_identities`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+}
+
+// expectUser answers a user lookup with a user of the role
+func (f *oidcFixture) expectUser(userID uuid.UUID, role string) {
+	f.mock.ExpectQuery(`FROM users`).
+		WillReturnRows(
+			sqlmock.NewRows([]string{"id", "email", "role"}).
+				AddRow(userID, "user@example.com", role),
+		)
+}
+
+func TestOIDCCallbackLinksExistingUsersOnlyWhenAllowed(t *testing.T) {
+	tests := []struct {
+		name              string
+		linkExistingUsers bool
+		emailVerified     bool
+		role              string
+		wantStatus        int
+	}{
+		{"provider did not opt in", false, true, "user", http.StatusConflict},
+		{"unverified email", true, false, "user", http.StatusConflict},
+		{"admin account", true, true, "admin", http.StatusConflict},
+		{"user account", true, true, "user", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				f := newOIDCFixture(
+					t,
+					config.OIDCProviderConfig{LinkExistingUsers: tt.linkExistingUsers},
+				)
+				f.idp.emailVerified = tt.emailVerified
+				f.expectNoIdentity()
+				f.expectUser(uuid.New(), tt.role)
+				if tt.wantStatus == http.StatusOK {
+					f.mock.ExpectExec(`INSERT INTO linked_identities`).
+						WillReturnResult(sqlmock.NewResult(0, 1))
+					f.mock.ExpectExec(`INSERT INTO login_events`).
+						WillReturnResult(sqlmock.NewResult(0, 1))
+					f.mock.ExpectExec(`INSERT INTO refresh_tokens`).
+						WillReturnResult(sqlmock.NewResult(0, 1))
+				}
+
+				w, body := f.callback(t, uuid.Nil)
+				if w.Code != tt.wantStatus {
+					t.Fatalf("status = %d, want %d: %v", w.Code, tt.wantStatus, body)
+				}
+			},
+		)
+	}
+}
+
+func TestOIDCCallbackRejectsLockedUsers(t *testing.T) {
+	f := newOIDCFixture(t, config.OIDCProviderConfig{})
+	userID := uuid.New()
+	f.mock.ExpectQuery(`FROM linked_identities`).
+		WillReturnRows(
+			sqlmock.NewRows([]string{"id", "user_id"}).AddRow(uuid.New(), userID),
+		)
+	f.mock.ExpectQuery(`FROM users`).
+		WillReturnRows(
+			sqlmock.NewRows([]string{"id", "email", "role", "locked_until"}).
+				AddRow(userID, "user@example.com", "user", time.Now().Add(time.Hour)),
+		)
+	f.mock.ExpectExec(`UPDATE linked_identities`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	f.mock.ExpectExec(`INSERT INTO login_events`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	w, body := f.callback(t, uuid.Nil)
+	if w.Code != http.StatusLocked {
+		t.Fatalf("status = %d, want 423: %v", w.Code, body)
+	}
+}
+
+func TestOIDCCallbackLinksIdentityToSignedInUser(t *testing.T) {
+	// Linking while signed in works for admins as well
+	f := newOIDCFixture(t, config.OIDCProviderConfig{})
+	userID := uuid.New()
+	f.expectNoIdentity()
+	f.expectUser(userID, "admin")
+	f.mock.ExpectExec(`INSERT INTO linked_identities`).
+		WithArgs(
+			sqlmock.AnyArg(), userID, "stub", f.idp.server.URL, "subject",
+			"user@example.com", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(),
+		).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	w, body := f.callback(t, userID)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %v", w.Code, body)
+	}
+	if body["access_token"] != nil {
+		t.Error("linking must not log in")
+	}
+}
+
+func TestOIDCCallbackRefusesIdentityOfAnotherUser(t *testing.T) {
+	f := newOIDCFixture(t, config.OIDCProviderConfig{})
+	f.mock.ExpectQuery(`FROM linked_identities`).
+		WillReturnRows(
+			sqlmock.NewRows([]string{"id", "user_id"}).AddRow(uuid.New(), uuid.New()),
+		)
+
+	w, body := f.callback(t, uuid.New())
+	if w.Code != http.StatusConflict {
+		t.Fatalf("status = %d, want 409: %v", w.Code, body)
+	}
+}
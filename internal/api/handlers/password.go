@@ -0,0 +1,341 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/EngenMe/go-api-dod/internal/api/middleware"
+	"github.com/EngenMe/go-api-dod/internal/data/models"
+	"github.com/EngenMe/go-api-dod/internal/mail"
+	"github.com/EngenMe/go-api-dod/internal/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// rejectWeakPassword answers 400 with the structured policy violations of a
+// password, if any
+func rejectWeakPassword(
+	c *gin.Context,
+	policy *utils.PasswordPolicy,
+	password, email string,
+) bool {
+	violations := policy.Validate(password, email)
+	if len(violations) == 0 {
+		return false
+	}
+
+	c.JSON(
+		http.StatusBadRequest, gin.H{
+			"error":      "Password does not meet the password policy",
+			"violations": violations,
+		},
+	)
+	return true
+}
+
+// ForgotPassword handles requesting a password reset email. Requests are
+// throttled per address, and neither the response nor its timing reveals
+// whether the email is registered.
+func (h *AuthHandler) ForgotPassword(c *gin.Context) {
+	// Parse request body
+	var req struct {
+		Email string `json:"email" binding:"required,email"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	allowed, retryAfter := h.ResendLimiter.Allow(
+		"password-reset:" + strings.ToLower(req.Email),
+	)
+	if !allowed {
+		setRetryAfter(c, retryAfter)
+		c.JSON(
+			http.StatusTooManyRequests,
+			gin.H{"error": "Too many requests, please try again later"},
+		)
+		return
+	}
+
+	// Get user
+	user, err := h.UserStore.GetByEmail(req.Email)
+	if err != nil {
+		c.JSON(
+			http.StatusInternalServerError,
+			gin.H{"error": "Failed to get user"},
+		)
+		return
+	}
+
+	if user != nil {
+		sendInBackground(
+			"password reset email",
+			func() error { return h.sendPasswordResetEmail(user) },
+		)
+	}
+
+	c.JSON(
+		http.StatusAccepted,
+		gin.H{"message": "If an account with that email exists, a password reset link has been sent"},
+	)
+}
+
+// sendPasswordResetEmail replaces any outstanding reset token of the user with
+// a new one and emails it
+func (h *AuthHandler) sendPasswordResetEmail(user *models.User) error {
+	err := h.UserTokenStore.InvalidateForUser(
+		user.ID,
+		models.UserTokenPasswordReset,
+	)
+	if err != nil {
+		return err
+	}
+
+	token, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		return err
+	}
+
+	resetToken := &models.UserToken{
+		UserID:    user.ID,
+		Purpose:   models.UserTokenPasswordReset,
+		Token:     token,
+		Email:     user.Email,
+		ExpiresAt: time.Now().Add(h.Config.PasswordResetExpiration),
+	}
+	if err := h.UserTokenStore.Create(resetToken); err != nil {
+		return err
+	}
+
+	link := h.Config.PasswordResetURL + "?token=" + url.QueryEscape(token)
+	return h.Mailer.Send(
+		mail.Message{
+			To:      user.Email,
+			Subject: "Reset your password",
+			Body: fmt.Sprintf(
+				"Someone asked to reset the password of your account.\n\n"+
+					"Use this link within %d minutes to choose a new password:\n%s\n\n"+
+					"If it wasn't you, you can ignore this email.",
+				int(h.Config.PasswordResetExpiration.Minutes()),
+				link,
+			),
+		},
+	)
+}
+
+// ResetPassword handles setting a new password with a reset token
+func (h *AuthHandler) ResetPassword(c *gin.Context) {
+	// Parse request body
+	var req struct {
+		Token    string `json:"token" binding:"required"`
+		Password string `json:"password" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Look up the reset token, it is only redeemed once the password is valid
+	resetToken, err := h.UserTokenStore.GetValid(
+		req.Token,
+		models.UserTokenPasswordReset,
+	)
+	if err != nil {
+		c.JSON(
+			http.StatusInternalServerError,
+			gin.H{"error": "Failed to get reset token"},
+		)
+		return
+	}
+	if resetToken == nil {
+		c.JSON(
+			http.StatusBadRequest,
+			gin.H{"error": "Invalid or expired reset token"},
+		)
+		return
+	}
+
+	// Get user
+	user, err := h.UserStore.GetByID(resetToken.UserID)
+	if err != nil {
+		c.JSON(
+			http.StatusInternalServerError,
+			gin.H{"error": "Failed to get user"},
+		)
+		return
+	}
+	if user == nil {
+		c.JSON(
+			http.StatusBadRequest,
+			gin.H{"error": "Invalid or expired reset token"},
+		)
+		return
+	}
+
+	if rejectWeakPassword(c, h.PasswordPolicy, req.Password, user.Email) {
+		return
+	}
+
+	// Redeem the reset token
+	resetToken, err = h.UserTokenStore.Consume(
+		req.Token,
+		models.UserTokenPasswordReset,
+	)
+	if err != nil {
+		c.JSON(
+			http.StatusInternalServerError,
+			gin.H{"error": "Failed to redeem reset token"},
+		)
+		return
+	}
+	if resetToken == nil {
+		c.JSON(
+			http.StatusBadRequest,
+			gin.H{"error": "Invalid or expired reset token"},
+		)
+		return
+	}
+
+	// Hash password
+	hashedPassword, err := h.PasswordHasher.Hash(req.Password)
+	if err != nil {
+		c.JSON(
+			http.StatusInternalServerError,
+			gin.H{"error": "Failed to hash password"},
+		)
+		return
+	}
+
+	if err := h.UserStore.UpdatePassword(user.ID, hashedPassword); err != nil {
+		c.JSON(
+			http.StatusInternalServerError,
+			gin.H{"error": "Failed to update password"},
+		)
+		return
+	}
+
+	// Other outstanding reset links must not undo the change
+	err = h.UserTokenStore.InvalidateForUser(
+		user.ID,
+		models.UserTokenPasswordReset,
+	)
+	if err != nil {
+		log.Printf("failed to invalidate password reset tokens: %v", err)
+	}
+
+	// End every existing session now that the password changed
+	if err := h.RefreshTokenStore.RevokeAllForUser(user.ID); err != nil {
+		c.JSON(
+			http.StatusInternalServerError,
+			gin.H{"error": "Failed to revoke refresh tokens"},
+		)
+		return
+	}
+
+	// API keys created by whoever knew the old password must stop working too
+	if err := h.APIKeyStore.RevokeAllForUser(user.ID); err != nil {
+		c.JSON(
+			http.StatusInternalServerError,
+			gin.H{"error": "Failed to revoke API keys"},
+		)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password reset successfully"})
+}
+
+// ChangePassword handles changing the password of the authenticated user.
+// Every session, the caller's included, and every API key is revoked and the
+// caller receives tokens for a new session.
+func (h *AuthHandler) ChangePassword(c *gin.Context) {
+	// Parse request body
+	var req struct {
+		CurrentPassword string `json:"current_password" binding:"required"`
+		NewPassword     string `json:"new_password" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, ok := h.currentUser(c)
+	if !ok {
+		return
+	}
+
+	if !h.confirmPassword(c, user, req.CurrentPassword) {
+		return
+	}
+
+	if req.NewPassword == req.CurrentPassword {
+		c.JSON(
+			http.StatusBadRequest,
+			gin.H{"error": "New password must differ from the current password"},
+		)
+		return
+	}
+	if rejectWeakPassword(c, h.PasswordPolicy, req.NewPassword, user.Email) {
+		return
+	}
+
+	// Hash password
+	hashedPassword, err := h.PasswordHasher.Hash(req.NewPassword)
+	if err != nil {
+		c.JSON(
+			http.StatusInternalServerError,
+			gin.H{"error": "Failed to hash password"},
+		)
+		return
+	}
+
+	if err := h.UserStore.UpdatePassword(user.ID, hashedPassword); err != nil {
+		c.JSON(
+			http.StatusInternalServerError,
+			gin.H{"error": "Failed to update password"},
+		)
+		return
+	}
+
+	// Outstanding reset links must not undo the change
+	err = h.UserTokenStore.InvalidateForUser(
+		user.ID,
+		models.UserTokenPasswordReset,
+	)
+	if err != nil {
+		log.Printf("failed to invalidate password reset tokens: %v", err)
+	}
+
+	// End every session, including the caller's, then start a new one
+	if err := h.RefreshTokenStore.RevokeAllForUser(user.ID); err != nil {
+		c.JSON(
+			http.StatusInternalServerError,
+			gin.H{"error": "Failed to revoke refresh tokens"},
+		)
+		return
+	}
+	if err := h.APIKeyStore.RevokeAllForUser(user.ID); err != nil {
+		c.JSON(
+			http.StatusInternalServerError,
+			gin.H{"error": "Failed to revoke API keys"},
+		)
+		return
+	}
+
+	h.recordLoginSuccess(user)
+	// The fresh tokens keep the scopes of the session that made the change
+	h.respondWithTokens(
+		c,
+		user,
+		middleware.Scopes(c),
+		models.LoginMethodPassword,
+		http.StatusOK,
+	)
+}
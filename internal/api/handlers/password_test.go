@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/EngenMe/go-api-dod/internal/data/store"
+	"github.com/EngenMe/go-api-dod/internal/utils"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+func TestForgotPasswordThrottlesPerAddress(t *testing.T) {
+	db, mock := newTestDB(t)
+	h := &AuthHandler{
+		UserStore:     store.NewUserStore(db, nil, nil),
+		ResendLimiter: utils.NewRateLimiter(1, time.Minute),
+	}
+	router := gin.New()
+	router.POST("/password/forgot", h.ForgotPassword)
+
+	// Only the first request looks the address up
+	mock.ExpectQuery(`FROM users`).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	w, _ := performRequest(
+		t, router, http.MethodPost, "/password/forgot",
+		gin.H{"email": "nobody@example.com"},
+	)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("first request = %d, want 202", w.Code)
+	}
+
+	w, _ = performRequest(
+		t, router, http.MethodPost, "/password/forgot",
+		gin.H{"email": "Nobody@Example.com"},
+	)
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("second request = %d, want 429", w.Code)
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Error("Retry-After header missing")
+	}
+}
+
+func TestResetPasswordRevokesAPIKeys(t *testing.T) {
+	db, mock := newTestDB(t)
+	tokenHasher := utils.NewTokenHasher("pepper")
+	h := &AuthHandler{
+		UserStore:      store.NewUserStore(db, nil, nil),
+		UserTokenStore: store.NewUserTokenStore(db, tokenHasher),
+		RefreshTokenStore: store.NewRefreshTokenStore(
+			db,
+			tokenHasher,
+			store.NewRevokedTokenStore(db, time.Minute, 15*time.Minute),
+		),
+		APIKeyStore: store.NewAPIKeyStore(db, tokenHasher),
+		PasswordHasher: utils.NewPasswordHasher(
+			utils.AlgorithmBcrypt,
+			4,
+			utils.Argon2Params{},
+		),
+		PasswordPolicy: &utils.PasswordPolicy{MinLength: 8, MaxLength: 128},
+	}
+	router := gin.New()
+	router.POST("/password/reset", h.ResetPassword)
+
+	userID := uuid.New()
+	tokenRows := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"id", "user_id", "purpose"}).
+			AddRow(uuid.New(), userID, "password_reset")
+	}
+	mock.ExpectQuery(`FROM user_tokens`).WillReturnRows(tokenRows())
+	mock.ExpectQuery(`FROM users`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email", "role"}).
+			AddRow(userID, "user@example.com", "user"))
+	mock.ExpectQuery(`UPDATE user_tokens\s+SET used_at`).WillReturnRows(tokenRows())
+	mock.ExpectExec(`UPDATE users\s+SET password`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE user_tokens`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE refresh_tokens`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE users\s+SET tokens_valid_after`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectExec(`UPDATE api_keys`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), userID).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+
+	w, body := performRequest(
+		t, router, http.MethodPost, "/password/reset",
+		gin.H{"token": "reset-token", "password": "a new passphrase"},
+	)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %v", w.Code, body)
+	}
+}
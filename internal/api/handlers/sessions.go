@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/EngenMe/go-api-dod/internal/data/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// sessionResponse builds the JSON representation of a session from its
+// current refresh token. Sessions are identified by their token family.
+func sessionResponse(refreshToken *models.RefreshToken) gin.H {
+	lastUsedAt := refreshToken.CreatedAt
+	if refreshToken.LastUsedAt != nil {
+		lastUsedAt = *refreshToken.LastUsedAt
+	}
+
+	return gin.H{
+		"id":           refreshToken.FamilyID,
+		"user_agent":   refreshToken.UserAgent,
+		"ip_address":   refreshToken.IPAddress,
+		"created_from": refreshToken.CreatedFrom,
+		"last_used_at": lastUsedAt,
+		"expires_at":   refreshToken.ExpiresAt,
+	}
+}
+
+// activeSessions returns the current refresh token of every session of the
+// user, most recently used first
+func (h *AuthHandler) activeSessions(userID uuid.UUID) (
+	[]models.RefreshToken,
+	error,
+) {
+	refreshTokens, err := h.RefreshTokenStore.GetByUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Rotated tokens are revoked, so each family has one token left
+	var sessions []models.RefreshToken
+	for _, refreshToken := range refreshTokens {
+		if refreshToken.IsValid() {
+			sessions = append(sessions, refreshToken)
+		}
+	}
+
+	return sessions, nil
+}
+
+// ListSessions handles listing where the user is logged in
+func (h *AuthHandler) ListSessions(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	sessions, err := h.activeSessions(userID)
+	if err != nil {
+		c.JSON(
+			http.StatusInternalServerError,
+			gin.H{"error": "Failed to list sessions"},
+		)
+		return
+	}
+
+	response := []gin.H{}
+	for i := range sessions {
+		response = append(response, sessionResponse(&sessions[i]))
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// RevokeSession handles logging out one session of the user. Access tokens
+// already issued to it stay valid until they expire.
+func (h *AuthHandler) RevokeSession(c *gin.Context) {
+	userID, ok := currentUserID(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	// Parse session ID from URL
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid session ID"})
+		return
+	}
+
+	// Sessions of other users are reported as missing
+	sessions, err := h.activeSessions(userID)
+	if err != nil {
+		c.JSON(
+			http.StatusInternalServerError,
+			gin.H{"error": "Failed to get session"},
+		)
+		return
+	}
+	found := false
+	for _, session := range sessions {
+		found = found || session.FamilyID == id
+	}
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+		return
+	}
+
+	if err := h.RefreshTokenStore.RevokeFamily(id); err != nil {
+		c.JSON(
+			http.StatusInternalServerError,
+			gin.H{"error": "Failed to revoke session"},
+		)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Session revoked successfully"})
+}
@@ -4,8 +4,10 @@ import (
 	"net/http"
 	"strconv"
 
+	"github.com/EngenMe/go-api-dod/internal/api/middleware"
 	"github.com/EngenMe/go-api-dod/internal/data/models"
 	"github.com/EngenMe/go-api-dod/internal/data/store"
+	"github.com/EngenMe/go-api-dod/internal/utils"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
@@ -13,13 +15,21 @@ import (
 
 // UserHandler provides handlers for user-related endpoints
 type UserHandler struct {
-	UserStore *store.UserStore
+	UserStore      *store.UserStore
+	PasswordHasher *utils.PasswordHasher
+	PasswordPolicy *utils.PasswordPolicy
 }
 
 // NewUserHandler creates a new UserHandler
-func NewUserHandler(userStore *store.UserStore) *UserHandler {
+func NewUserHandler(
+	userStore *store.UserStore,
+	passwordHasher *utils.PasswordHasher,
+	passwordPolicy *utils.PasswordPolicy,
+) *UserHandler {
 	return &UserHandler{
-		UserStore: userStore,
+		UserStore:      userStore,
+		PasswordHasher: passwordHasher,
+		PasswordPolicy: passwordPolicy,
 	}
 }
 
@@ -27,8 +37,9 @@ func NewUserHandler(userStore *store.UserStore) *UserHandler {
 func (h *UserHandler) CreateUser(c *gin.Context) {
 	// Parse request body
 	var req struct {
-		Email    string `json:"email" binding:"required,email"`
-		Password string `json:"password" binding:"required,min=8"`
+		Email    string      `json:"email" binding:"required,email"`
+		Password string      `json:"password" binding:"required"`
+		Role     models.Role `json:"role"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -36,6 +47,21 @@ func (h *UserHandler) CreateUser(c *gin.Context) {
 		return
 	}
 
+	if req.Role != "" && !req.Role.IsValid() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role"})
+		return
+	}
+	// Clients may create users but never grant roles, so that a leaked client
+	// secret cannot make admins
+	if isClient(c) && req.Role != "" && req.Role != models.RoleUser {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+		return
+	}
+
+	if rejectWeakPassword(c, h.PasswordPolicy, req.Password, req.Email) {
+		return
+	}
+
 	// Check if a user already exists
 	existingUser, err := h.UserStore.GetByEmail(req.Email)
 	if err != nil {
@@ -53,10 +79,21 @@ func (h *UserHandler) CreateUser(c *gin.Context) {
 		return
 	}
 
+	// Hash password
+	hashedPassword, err := h.PasswordHasher.Hash(req.Password)
+	if err != nil {
+		c.JSON(
+			http.StatusInternalServerError,
+			gin.H{"error": "Failed to hash password"},
+		)
+		return
+	}
+
 	// Create user
 	user := models.User{
 		Email:    req.Email,
-		Password: req.Password, // This will be hashed in the auth handler
+		Password: hashedPassword,
+		Role:     req.Role,
 	}
 
 	if err := h.UserStore.Create(&user); err != nil {
@@ -72,6 +109,7 @@ func (h *UserHandler) CreateUser(c *gin.Context) {
 		http.StatusCreated, gin.H{
 			"id":         user.ID,
 			"email":      user.Email,
+			"role":       user.Role,
 			"created_at": user.CreatedAt,
 		},
 	)
@@ -87,6 +125,10 @@ func (h *UserHandler) GetUser(c *gin.Context) {
 		return
 	}
 
+	if !authorizeUserAccess(c, id, models.PermissionUsersRead) {
+		return
+	}
+
 	// Get user
 	user, err := h.UserStore.GetByID(id)
 	if err != nil {
@@ -106,6 +148,7 @@ func (h *UserHandler) GetUser(c *gin.Context) {
 		http.StatusOK, gin.H{
 			"id":         user.ID,
 			"email":      user.Email,
+			"role":       user.Role,
 			"created_at": user.CreatedAt,
 			"updated_at": user.UpdatedAt,
 		},
@@ -144,6 +187,7 @@ func (h *UserHandler) ListUsers(c *gin.Context) {
 			response, gin.H{
 				"id":         user.ID,
 				"email":      user.Email,
+				"role":       user.Role,
 				"created_at": user.CreatedAt,
 				"updated_at": user.UpdatedAt,
 			},
@@ -164,9 +208,14 @@ func (h *UserHandler) UpdateUser(c *gin.Context) {
 		return
 	}
 
+	if !authorizeUserAccess(c, id, models.PermissionUsersWrite) {
+		return
+	}
+
 	// Parse request body
 	var req struct {
-		Email string `json:"email" binding:"email"`
+		Email string      `json:"email" binding:"omitempty,email"`
+		Role  models.Role `json:"role"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -174,6 +223,22 @@ func (h *UserHandler) UpdateUser(c *gin.Context) {
 		return
 	}
 
+	// Only users who may manage others may change roles, including their own.
+	// Clients never may, so that a leaked client secret cannot make admins.
+	if req.Role != "" {
+		if !middleware.Role(c).Can(models.PermissionUsersWrite) {
+			c.JSON(
+				http.StatusForbidden,
+				gin.H{"error": "Insufficient permissions"},
+			)
+			return
+		}
+		if !req.Role.IsValid() {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role"})
+			return
+		}
+	}
+
 	// Get user
 	user, err := h.UserStore.GetByID(id)
 	if err != nil {
@@ -187,6 +252,20 @@ func (h *UserHandler) UpdateUser(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
 		return
 	}
+	if rejectClientOnAdmin(c, user) {
+		return
+	}
+
+	// Users change their own email at PATCH /me, which confirms the password
+	// and verifies the new address
+	if userID, _ := currentUserID(c); userID == user.ID &&
+		req.Email != "" && req.Email != user.Email {
+		c.JSON(
+			http.StatusBadRequest,
+			gin.H{"error": "Use PATCH /me to change your own email"},
+		)
+		return
+	}
 
 	// Update user
 	if req.Email != "" {
@@ -201,11 +280,23 @@ func (h *UserHandler) UpdateUser(c *gin.Context) {
 		return
 	}
 
+	if req.Role != "" && req.Role != user.Role {
+		if err := h.UserStore.SetRole(user.ID, req.Role); err != nil {
+			c.JSON(
+				http.StatusInternalServerError,
+				gin.H{"error": "Failed to update user role"},
+			)
+			return
+		}
+		user.Role = req.Role
+	}
+
 	// Return updated user
 	c.JSON(
 		http.StatusOK, gin.H{
 			"id":         user.ID,
 			"email":      user.Email,
+			"role":       user.Role,
 			"created_at": user.CreatedAt,
 			"updated_at": user.UpdatedAt,
 		},
@@ -222,6 +313,23 @@ func (h *UserHandler) DeleteUser(c *gin.Context) {
 		return
 	}
 
+	if !authorizeUserAccess(c, id, models.PermissionUsersDelete) {
+		return
+	}
+	if isClient(c) {
+		user, err := h.UserStore.GetByID(id)
+		if err != nil {
+			c.JSON(
+				http.StatusInternalServerError,
+				gin.H{"error": "Failed to get user"},
+			)
+			return
+		}
+		if user != nil && rejectClientOnAdmin(c, user) {
+			return
+		}
+	}
+
 	// Delete user
 	if err := h.UserStore.Delete(id); err != nil {
 		c.JSON(
@@ -234,3 +342,40 @@ func (h *UserHandler) DeleteUser(c *gin.Context) {
 	// Return success
 	c.JSON(http.StatusOK, gin.H{"message": "User deleted successfully"})
 }
+
+// UnlockUser handles lifting a lockout caused by failed logins
+func (h *UserHandler) UnlockUser(c *gin.Context) {
+	// Parse user ID from URL
+	idStr := c.Param("id")
+	id, err := uuid.Parse(idStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	// Get user
+	user, err := h.UserStore.GetByID(id)
+	if err != nil {
+		c.JSON(
+			http.StatusInternalServerError,
+			gin.H{"error": "Failed to get user"},
+		)
+		return
+	}
+	if user == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	// Unlock user
+	if err := h.UserStore.ResetFailedLogins(user.ID); err != nil {
+		c.JSON(
+			http.StatusInternalServerError,
+			gin.H{"error": "Failed to unlock user"},
+		)
+		return
+	}
+
+	// Return success
+	c.JSON(http.StatusOK, gin.H{"message": "User unlocked successfully"})
+}
@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/EngenMe/go-api-dod/internal/data/models"
+	"github.com/EngenMe/go-api-dod/internal/data/store"
+	"github.com/EngenMe/go-api-dod/internal/utils"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+func TestCreateUserRejectsPasswordsBcryptCannotHash(t *testing.T) {
+	db, _ := newTestDB(t)
+	h := NewUserHandler(
+		store.NewUserStore(db, nil, nil),
+		utils.NewPasswordHasher(utils.AlgorithmBcrypt, 4, utils.Argon2Params{}),
+		&utils.PasswordPolicy{
+			MinLength: 8,
+			MaxLength: 128,
+			MaxBytes:  utils.BcryptMaxPasswordBytes,
+		},
+	)
+	router := gin.New()
+	router.POST(
+		"/users",
+		authenticateAs(&utils.Claims{UserID: uuid.New(), Role: string(models.RoleAdmin)}),
+		h.CreateUser,
+	)
+
+	// Both are within the character limit but longer than 72 bytes
+	for _, password := range []string{strings.Repeat("a", 73), strings.Repeat("é", 40)} {
+		w, body := performRequest(
+			t, router, http.MethodPost, "/users",
+			gin.H{"email": "new@example.com", "password": password},
+		)
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("status = %d, want 400: %v", w.Code, body)
+		}
+		violations, _ := body["violations"].([]interface{})
+		if len(violations) != 1 ||
+			violations[0].(map[string]interface{})["code"] != utils.PasswordTooLong {
+			t.Errorf("violations = %v", body["violations"])
+		}
+	}
+}
+
+func TestUpdateUserRefusesOwnEmailChange(t *testing.T) {
+	db, mock := newTestDB(t)
+	h := NewUserHandler(store.NewUserStore(db, nil, nil), nil, nil)
+	userID := uuid.New()
+	router := gin.New()
+	router.PUT(
+		"/users/:id",
+		authenticateAs(&utils.Claims{UserID: userID, Role: string(models.RoleUser)}),
+		h.UpdateUser,
+	)
+
+	// Loading the user is the last query; nothing is updated
+	mock.ExpectQuery(`FROM users`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email", "role"}).
+			AddRow(userID, "user@example.com", "user"))
+
+	w, body := performRequest(
+		t, router, http.MethodPut, "/users/"+userID.String(),
+		gin.H{"email": "attacker@example.com"},
+	)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want 400: %v", w.Code, body)
+	}
+}
+
+func TestDeleteUserRevokesTheirTokens(t *testing.T) {
+	db, mock := newTestDB(t)
+	revokedTokenStore := store.NewRevokedTokenStore(db, time.Minute, 15*time.Minute)
+	h := NewUserHandler(
+		store.NewUserStore(db, nil, revokedTokenStore),
+		nil,
+		nil,
+	)
+	userID := uuid.New()
+	router := gin.New()
+	router.DELETE(
+		"/users/:id",
+		authenticateAs(&utils.Claims{UserID: uuid.New(), Role: string(models.RoleAdmin)}),
+		h.DeleteUser,
+	)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE users\s+SET deleted_at = \$1,\s+tokens_valid_after`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE refresh_tokens`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), userID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	w, body := performRequest(
+		t, router, http.MethodDelete, "/users/"+userID.String(), nil,
+	)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %v", w.Code, body)
+	}
+}
@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/EngenMe/go-api-dod/internal/data/models"
+	"github.com/EngenMe/go-api-dod/internal/mail"
+	"github.com/EngenMe/go-api-dod/internal/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// sendVerificationEmail replaces any outstanding verification token of the
+// user with a new one and emails it
+func (h *AuthHandler) sendVerificationEmail(user *models.User) error {
+	err := h.UserTokenStore.InvalidateForUser(
+		user.ID,
+		models.UserTokenEmailVerification,
+	)
+	if err != nil {
+		return err
+	}
+
+	token, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		return err
+	}
+
+	verificationToken := &models.UserToken{
+		UserID:    user.ID,
+		Purpose:   models.UserTokenEmailVerification,
+		Token:     token,
+		Email:     user.Email,
+		ExpiresAt: time.Now().Add(h.Config.EmailVerificationExpiration),
+	}
+	if err := h.UserTokenStore.Create(verificationToken); err != nil {
+		return err
+	}
+
+	link := h.Config.EmailVerificationURL + "?token=" + url.QueryEscape(token)
+	return h.Mailer.Send(
+		mail.Message{
+			To:      user.Email,
+			Subject: "Verify your email address",
+			Body: fmt.Sprintf(
+				"Please confirm your email address by opening this link within %d hours:\n%s",
+				int(h.Config.EmailVerificationExpiration.Hours()),
+				link,
+			),
+		},
+	)
+}
+
+// VerifyEmail handles confirming an email address with a verification token,
+// passed either as the token query parameter or in the JSON body
+func (h *AuthHandler) VerifyEmail(c *gin.Context) {
+	token := c.Query("token")
+	if c.Request.Method == http.MethodPost {
+		// Parse request body
+		var req struct {
+			Token string `json:"token" binding:"required"`
+		}
+
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		token = req.Token
+	}
+	if token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Token is required"})
+		return
+	}
+
+	// Redeem the verification token
+	verificationToken, err := h.UserTokenStore.Consume(
+		token,
+		models.UserTokenEmailVerification,
+	)
+	if err != nil {
+		c.JSON(
+			http.StatusInternalServerError,
+			gin.H{"error": "Failed to redeem verification token"},
+		)
+		return
+	}
+	if verificationToken == nil {
+		c.JSON(
+			http.StatusBadRequest,
+			gin.H{"error": "Invalid or expired verification token"},
+		)
+		return
+	}
+
+	if err := h.UserStore.MarkEmailVerified(verificationToken.UserID); err != nil {
+		c.JSON(
+			http.StatusInternalServerError,
+			gin.H{"error": "Failed to verify email"},
+		)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Email verified successfully"})
+}
+
+// ResendVerificationEmail handles sending a new verification email. Requests
+// are throttled per address, and the response does not reveal whether the
+// email is registered.
+func (h *AuthHandler) ResendVerificationEmail(c *gin.Context) {
+	// Parse request body
+	var req struct {
+		Email string `json:"email" binding:"required,email"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	allowed, retryAfter := h.ResendLimiter.Allow(strings.ToLower(req.Email))
+	if !allowed {
+		setRetryAfter(c, retryAfter)
+		c.JSON(
+			http.StatusTooManyRequests,
+			gin.H{"error": "Too many requests, please try again later"},
+		)
+		return
+	}
+
+	// Get user
+	user, err := h.UserStore.GetByEmail(req.Email)
+	if err != nil {
+		c.JSON(
+			http.StatusInternalServerError,
+			gin.H{"error": "Failed to get user"},
+		)
+		return
+	}
+
+	if user != nil && !user.IsEmailVerified() {
+		if err := h.sendVerificationEmail(user); err != nil {
+			log.Printf("failed to send verification email: %v", err)
+		}
+	}
+
+	c.JSON(
+		http.StatusAccepted,
+		gin.H{"message": "If an unverified account with that email exists, a verification link has been sent"},
+	)
+}
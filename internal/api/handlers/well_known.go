@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/EngenMe/go-api-dod/internal/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// WellKnownHandler provides handlers for /.well-known discovery documents
+type WellKnownHandler struct {
+	TokenManager *utils.TokenManager
+	BaseURL      string
+}
+
+// NewWellKnownHandler creates a new WellKnownHandler
+func NewWellKnownHandler(
+	tokenManager *utils.TokenManager,
+	baseURL string,
+) *WellKnownHandler {
+	return &WellKnownHandler{
+		TokenManager: tokenManager,
+		BaseURL:      baseURL,
+	}
+}
+
+// JWKS handles publishing the public keys that verify our tokens
+func (h *WellKnownHandler) JWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, h.TokenManager.JWKS())
+}
+
+// OpenIDConfiguration handles publishing the OpenID Connect discovery
+// document (OpenID Connect Discovery section 3)
+func (h *WellKnownHandler) OpenIDConfiguration(c *gin.Context) {
+	scopes := append(
+		append([]string{}, utils.OIDCScopes...),
+		utils.MachineScopes...,
+	)
+
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(
+		http.StatusOK, gin.H{
+			"issuer":                   h.TokenManager.Issuer,
+			"authorization_endpoint":   h.BaseURL + "/oauth/authorize",
+			"token_endpoint":           h.BaseURL + "/oauth/token",
+			"userinfo_endpoint":        h.BaseURL + "/userinfo",
+			"jwks_uri":                 h.BaseURL + "/.well-known/jwks.json",
+			"introspection_endpoint":   h.BaseURL + "/oauth/introspect",
+			"revocation_endpoint":      h.BaseURL + "/oauth/revoke",
+			"scopes_supported":         scopes,
+			"response_types_supported": []string{"code"},
+			"grant_types_supported": []string{
+				"authorization_code",
+				"client_credentials",
+				"refresh_token",
+			},
+			"subject_types_supported": []string{"public"},
+			"id_token_signing_alg_values_supported": []string{
+				h.TokenManager.Keyring.Active.Method.Alg(),
+			},
+			"token_endpoint_auth_methods_supported": []string{
+				"client_secret_basic",
+				"client_secret_post",
+				"none",
+			},
+			"code_challenge_methods_supported": []string{utils.PKCEMethodS256},
+			"claims_supported": []string{
+				"iss",
+				"sub",
+				"aud",
+				"exp",
+				"iat",
+				"auth_time",
+				"nonce",
+				"email",
+				"email_verified",
+			},
+		},
+	)
+}
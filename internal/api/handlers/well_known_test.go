@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestOpenIDConfigurationMatchesServer(t *testing.T) {
+	tokenManager := newTestTokenManager(t)
+	handler := NewWellKnownHandler(tokenManager, tokenManager.Issuer)
+	router := gin.New()
+	router.GET("/.well-known/openid-configuration", handler.OpenIDConfiguration)
+
+	w, body := performRequest(
+		t, router, http.MethodGet, "/.well-known/openid-configuration", nil,
+	)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", w.Code)
+	}
+
+	// Clients compare the issuer with the URL they fetched the document from
+	if body["issuer"] != "http://localhost:8080" {
+		t.Errorf("issuer = %v", body["issuer"])
+	}
+
+	// Every grant accepted by the token endpoint is advertised
+	grantTypes := map[interface{}]bool{}
+	for _, grantType := range body["grant_types_supported"].([]interface{}) {
+		grantTypes[grantType] = true
+	}
+	for _, grantType := range []string{
+		"authorization_code",
+		"client_credentials",
+		"refresh_token",
+	} {
+		if !grantTypes[grantType] {
+			t.Errorf("grant_types_supported lacks %s", grantType)
+		}
+	}
+}
@@ -1,9 +1,13 @@
 package middleware
 
 import (
+	"fmt"
+	"log"
 	"net/http"
 	"strings"
 
+	"github.com/EngenMe/go-api-dod/internal/data/models"
+	"github.com/EngenMe/go-api-dod/internal/data/store"
 	"github.com/EngenMe/go-api-dod/internal/utils"
 
 	"github.com/gin-gonic/gin"
@@ -11,17 +15,29 @@ import (
 
 // AuthMiddleware provides authentication middleware for the API
 type AuthMiddleware struct {
-	TokenManager *utils.TokenManager
+	TokenManager      *utils.TokenManager
+	UserStore         *store.UserStore
+	APIKeyStore       *store.APIKeyStore
+	RevokedTokenStore *store.RevokedTokenStore
 }
 
 // NewAuthMiddleware creates a new AuthMiddleware
-func NewAuthMiddleware(tokenManager *utils.TokenManager) *AuthMiddleware {
+func NewAuthMiddleware(
+	tokenManager *utils.TokenManager,
+	userStore *store.UserStore,
+	apiKeyStore *store.APIKeyStore,
+	revokedTokenStore *store.RevokedTokenStore,
+) *AuthMiddleware {
 	return &AuthMiddleware{
-		TokenManager: tokenManager,
+		TokenManager:      tokenManager,
+		UserStore:         userStore,
+		APIKeyStore:       apiKeyStore,
+		RevokedTokenStore: revokedTokenStore,
 	}
 }
 
-// RequireAuth is a middleware that requires authentication
+// RequireAuth is a middleware that requires authentication with either an
+// access token or an API key
 func (m *AuthMiddleware) RequireAuth() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
@@ -46,6 +62,11 @@ func (m *AuthMiddleware) RequireAuth() gin.HandlerFunc {
 			return
 		}
 
+		if strings.HasPrefix(parts[1], utils.APIKeyPrefix) {
+			m.authenticateAPIKey(c, parts[1])
+			return
+		}
+
 		// Validate the access token
 		claims, err := m.TokenManager.ValidateAccessToken(parts[1])
 		if err != nil {
@@ -57,9 +78,222 @@ func (m *AuthMiddleware) RequireAuth() gin.HandlerFunc {
 			return
 		}
 
+		// Logout, account deletion and revoking a client revoke tokens
+		// before they expire
+		revoked, err := m.RevokedTokenStore.IsRevoked(claims)
+		if err != nil {
+			c.JSON(
+				http.StatusInternalServerError,
+				gin.H{"error": "Failed to check token revocation"},
+			)
+			c.Abort()
+			return
+		}
+		if revoked {
+			c.JSON(
+				http.StatusUnauthorized,
+				gin.H{"error": "Access token has been revoked"},
+			)
+			c.Abort()
+			return
+		}
+
 		// Set user information in the context
+		c.Set("claims", claims)
 		c.Set("userID", claims.UserID)
 		c.Set("email", claims.Email)
+		c.Set("role", models.Role(claims.Role))
+		c.Set("scopes", claims.Scopes())
+		if claims.ClientID != "" {
+			c.Set("clientID", claims.ClientID)
+		}
+		c.Next()
+	}
+}
+
+// authenticateAPIKey authenticates the request as the owner of an API key,
+// limited to the scopes of the key
+func (m *AuthMiddleware) authenticateAPIKey(c *gin.Context, key string) {
+	apiKey, err := m.APIKeyStore.GetByKey(key)
+	if err != nil {
+		c.JSON(
+			http.StatusInternalServerError,
+			gin.H{"error": "Failed to get API key"},
+		)
+		c.Abort()
+		return
+	}
+	if apiKey == nil || !apiKey.IsValid() {
+		c.JSON(
+			http.StatusUnauthorized,
+			gin.H{"error": "Invalid or expired API key"},
+		)
+		c.Abort()
+		return
+	}
+
+	// The key acts with the current role of its owner
+	user, err := m.UserStore.GetByID(apiKey.UserID)
+	if err != nil {
+		c.JSON(
+			http.StatusInternalServerError,
+			gin.H{"error": "Failed to get user"},
+		)
+		c.Abort()
+		return
+	}
+	if user == nil {
+		c.JSON(
+			http.StatusUnauthorized,
+			gin.H{"error": "Invalid or expired API key"},
+		)
+		c.Abort()
+		return
+	}
+
+	if err := m.APIKeyStore.TouchLastUsed(apiKey.ID); err != nil {
+		log.Printf("failed to record API key usage: %v", err)
+	}
+
+	// Set user information in the context
+	c.Set("userID", user.ID)
+	c.Set("email", user.Email)
+	c.Set("role", user.Role)
+	c.Set("scopes", apiKey.Scopes())
+	c.Set("apiKeyID", apiKey.ID)
+	c.Next()
+}
+
+// RequireRole is a middleware that requires the authenticated user to have
+// one of the given roles. It must run after RequireAuth.
+func (m *AuthMiddleware) RequireRole(roles ...models.Role) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		role := Role(c)
+		for _, allowed := range roles {
+			if role == allowed {
+				c.Next()
+				return
+			}
+		}
+
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+		c.Abort()
+	}
+}
+
+// RequirePermission is a middleware that requires the caller to hold a
+// permission, see Can. It must run after RequireAuth.
+func (m *AuthMiddleware) RequirePermission(
+	permission models.Permission,
+) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !Can(c, permission) {
+			c.JSON(
+				http.StatusForbidden,
+				gin.H{"error": "Insufficient permissions"},
+			)
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// RequireScope is a middleware that requires the access token to grant every
+// given scope. It must run after RequireAuth.
+func (m *AuthMiddleware) RequireScope(scopes ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		grantedScopes := Scopes(c)
+		for _, scope := range scopes {
+			if !utils.HasScope(grantedScopes, scope) {
+				// RFC 6750 section 3.1
+				c.Header(
+					"WWW-Authenticate",
+					fmt.Sprintf(
+						`Bearer error="insufficient_scope", scope="%s"`,
+						utils.FormatScope(scopes),
+					),
+				)
+				c.JSON(
+					http.StatusForbidden,
+					gin.H{"error": "Insufficient scope"},
+				)
+				c.Abort()
+				return
+			}
+		}
+
+		c.Next()
+	}
+}
+
+// RejectImpersonation is a middleware that refuses access tokens an admin
+// got by impersonating the user, keeping credentials and sessions out of
+// their reach. It must run after RequireAuth.
+func (m *AuthMiddleware) RejectImpersonation() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if claims := Claims(c); claims != nil && claims.Actor != nil {
+			c.JSON(
+				http.StatusForbidden,
+				gin.H{"error": "Not allowed while impersonating a user"},
+			)
+			c.Abort()
+			return
+		}
+
 		c.Next()
 	}
 }
+
+// clientScopePermissions lists the permissions granted by the scopes of an
+// OAuth client acting on its own behalf
+var clientScopePermissions = map[string][]models.Permission{
+	utils.ScopeUsersRead: {models.PermissionUsersRead},
+	utils.ScopeUsersWrite: {
+		models.PermissionUsersWrite,
+		models.PermissionUsersDelete,
+	},
+}
+
+// Can reports whether the caller holds a permission: through the role of the
+// authenticated user or, for an OAuth client acting on its own behalf, which
+// has no role, through the scopes of its access token
+func Can(c *gin.Context, permission models.Permission) bool {
+	claims := Claims(c)
+	if claims == nil || claims.ClientID == "" {
+		return Role(c).Can(permission)
+	}
+
+	for _, scope := range Scopes(c) {
+		for _, granted := range clientScopePermissions[scope] {
+			if granted == permission {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Claims returns the access token claims set by RequireAuth, which is nil
+// for requests authenticated with an API key
+func Claims(c *gin.Context) *utils.Claims {
+	claims, _ := c.Get("claims")
+	cl, _ := claims.(*utils.Claims)
+	return cl
+}
+
+// Role returns the role of the authenticated user set by RequireAuth
+func Role(c *gin.Context) models.Role {
+	role, _ := c.Get("role")
+	r, _ := role.(models.Role)
+	return r
+}
+
+// Scopes returns the scopes of the access token or API key set by
+// RequireAuth
+func Scopes(c *gin.Context) []string {
+	scopes, _ := c.Get("scopes")
+	s, _ := scopes.([]string)
+	return s
+}
@@ -36,6 +36,16 @@ func (m *LoggingMiddleware) RequestLogger() gin.HandlerFunc {
 		path := c.Request.URL.Path
 		statusCode := c.Writer.Status()
 
+		// Requests of impersonation tokens name both identities
+		if claims := Claims(c); claims != nil && claims.Actor != nil {
+			m.Logger.Printf(
+				"| %3d | %13v | %15s | %s | %s | user %s impersonated by admin %s",
+				statusCode, latency, clientIP, method, path,
+				claims.UserID, claims.Actor.Subject,
+			)
+			return
+		}
+
 		m.Logger.Printf(
 			"| %3d | %13v | %15s | %s | %s",
 			statusCode, latency, clientIP, method, path,
@@ -1,10 +1,15 @@
 package api
 
 import (
+	"fmt"
+
 	"github.com/EngenMe/go-api-dod/config"
 	"github.com/EngenMe/go-api-dod/internal/api/handlers"
 	"github.com/EngenMe/go-api-dod/internal/api/middleware"
+	"github.com/EngenMe/go-api-dod/internal/data/models"
 	"github.com/EngenMe/go-api-dod/internal/data/store"
+	"github.com/EngenMe/go-api-dod/internal/mail"
+	"github.com/EngenMe/go-api-dod/internal/oidc"
 	"github.com/EngenMe/go-api-dod/internal/utils"
 
 	"github.com/gin-gonic/gin"
@@ -12,21 +17,38 @@ import (
 
 // Server represents the API server
 type Server struct {
-	Router            *gin.Engine
-	Config            config.Config
-	DB                *store.PostgresStore
-	UserStore         *store.UserStore
-	RefreshTokenStore *store.RefreshTokenStore
-	PasswordHasher    *utils.PasswordHasher
-	TokenManager      *utils.TokenManager
-	AuthMiddleware    *middleware.AuthMiddleware
-	LoggingMiddleware *middleware.LoggingMiddleware
-	UserHandler       *handlers.UserHandler
-	AuthHandler       *handlers.AuthHandler
+	Router                 *gin.Engine
+	Config                 config.Config
+	DB                     *store.PostgresStore
+	UserStore              *store.UserStore
+	RefreshTokenStore      *store.RefreshTokenStore
+	UserTokenStore         *store.UserTokenStore
+	MFARecoveryCodeStore   *store.MFARecoveryCodeStore
+	SecurityEventStore     *store.SecurityEventStore
+	RevokedTokenStore      *store.RevokedTokenStore
+	LoginEventStore        *store.LoginEventStore
+	APIKeyStore            *store.APIKeyStore
+	OAuthClientStore       *store.OAuthClientStore
+	AuthorizationCodeStore *store.AuthorizationCodeStore
+	LinkedIdentityStore    *store.LinkedIdentityStore
+	PasswordHasher         *utils.PasswordHasher
+	PasswordPolicy         *utils.PasswordPolicy
+	TokenHasher            *utils.TokenHasher
+	TokenManager           *utils.TokenManager
+	Mailer                 mail.Mailer
+	AuthMiddleware         *middleware.AuthMiddleware
+	LoggingMiddleware      *middleware.LoggingMiddleware
+	UserHandler            *handlers.UserHandler
+	AuthHandler            *handlers.AuthHandler
+	MFAHandler             *handlers.MFAHandler
+	APIKeyHandler          *handlers.APIKeyHandler
+	OAuthHandler           *handlers.OAuthHandler
+	OIDCHandler            *handlers.OIDCHandler
+	WellKnownHandler       *handlers.WellKnownHandler
 }
 
 // NewServer creates a new Server
-func NewServer(cfg config.Config, db *store.PostgresStore) *Server {
+func NewServer(cfg config.Config, db *store.PostgresStore) (*Server, error) {
 	// Set Gin mode
 	if cfg.Server.Mode == "production" {
 		gin.SetMode(gin.ReleaseMode)
@@ -37,43 +59,199 @@ func NewServer(cfg config.Config, db *store.PostgresStore) *Server {
 	router.Use(gin.Recovery())
 
 	// Initialize dependencies
-	userStore := store.NewUserStore(db.DB)
-	refreshTokenStore := store.NewRefreshTokenStore(db.DB)
-	passwordHasher := utils.NewPasswordHasher(cfg.Auth.BcryptCost)
+	secretBox, err := utils.NewSecretBox(cfg.Auth.MFAEncryptionKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load MFA encryption key: %w", err)
+	}
+	tokenHasher := utils.NewTokenHasher(cfg.Auth.TokenPepper)
+	// Revocations must outlive every kind of access token, impersonation
+	// tokens included
+	revokedTokenStore := store.NewRevokedTokenStore(
+		db.DB,
+		cfg.Auth.TokenRevocationCacheTTL,
+		max(cfg.Auth.AccessTokenExpiration, cfg.Auth.ImpersonationExpiration),
+	)
+	userStore := store.NewUserStore(db.DB, secretBox, revokedTokenStore)
+	refreshTokenStore := store.NewRefreshTokenStore(
+		db.DB,
+		tokenHasher,
+		revokedTokenStore,
+	)
+	userTokenStore := store.NewUserTokenStore(db.DB, tokenHasher)
+	mfaRecoveryCodeStore := store.NewMFARecoveryCodeStore(db.DB, tokenHasher)
+	securityEventStore := store.NewSecurityEventStore(db.DB)
+	loginEventStore := store.NewLoginEventStore(db.DB)
+	apiKeyStore := store.NewAPIKeyStore(db.DB, tokenHasher)
+	oauthClientStore := store.NewOAuthClientStore(db.DB, tokenHasher)
+	authorizationCodeStore := store.NewAuthorizationCodeStore(
+		db.DB,
+		tokenHasher,
+	)
+	linkedIdentityStore := store.NewLinkedIdentityStore(db.DB)
+	passwordHasher := utils.NewPasswordHasher(
+		cfg.Auth.PasswordHashAlgorithm,
+		cfg.Auth.BcryptCost,
+		utils.Argon2Params{
+			Memory:      cfg.Auth.Argon2Memory,
+			Iterations:  cfg.Auth.Argon2Iterations,
+			Parallelism: cfg.Auth.Argon2Parallelism,
+		},
+	)
+	keyring, err := loadKeyring(cfg.Auth)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load signing keys: %w", err)
+	}
 	tokenManager := utils.NewTokenManager(
-		cfg.Auth.JWTSecret,
+		keyring,
 		cfg.Auth.TokenIssuer,
 		cfg.Auth.AccessTokenExpiration,
 		cfg.Auth.RefreshTokenExpiration,
+		cfg.Auth.MFATokenExpiration,
+	)
+	mailer, err := mail.NewMailer(cfg.Mail)
+	if err != nil {
+		return nil, err
+	}
+	authMiddleware := middleware.NewAuthMiddleware(
+		tokenManager,
+		userStore,
+		apiKeyStore,
+		revokedTokenStore,
 	)
-	authMiddleware := middleware.NewAuthMiddleware(tokenManager)
 	loggingMiddleware := middleware.NewLoggingMiddleware()
-	userHandler := handlers.NewUserHandler(userStore)
+	passwordPolicy, err := loadPasswordPolicy(cfg.Auth)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load password policy: %w", err)
+	}
+	userHandler := handlers.NewUserHandler(
+		userStore,
+		passwordHasher,
+		passwordPolicy,
+	)
 	authHandler := handlers.NewAuthHandler(
+		cfg.Auth,
 		userStore,
 		refreshTokenStore,
+		userTokenStore,
+		mfaRecoveryCodeStore,
+		securityEventStore,
+		revokedTokenStore,
+		loginEventStore,
+		apiKeyStore,
 		passwordHasher,
+		passwordPolicy,
 		tokenManager,
+		mailer,
+	)
+	mfaHandler := handlers.NewMFAHandler(authHandler, cfg.Auth.MFAIssuer)
+	apiKeyHandler := handlers.NewAPIKeyHandler(apiKeyStore)
+	oauthHandler := handlers.NewOAuthHandler(
+		authHandler,
+		oauthClientStore,
+		authorizationCodeStore,
+		refreshTokenStore,
+		revokedTokenStore,
+		tokenManager,
+	)
+	oidcHandler := handlers.NewOIDCHandler(
+		authHandler,
+		linkedIdentityStore,
+		oidc.NewProviders(cfg.Auth.OIDCProviders),
+		cfg.Server.BaseURL,
+	)
+	wellKnownHandler := handlers.NewWellKnownHandler(
+		tokenManager,
+		cfg.Server.BaseURL,
 	)
 
 	server := &Server{
-		Router:            router,
-		Config:            cfg,
-		DB:                db,
-		UserStore:         userStore,
-		RefreshTokenStore: refreshTokenStore,
-		PasswordHasher:    passwordHasher,
-		TokenManager:      tokenManager,
-		AuthMiddleware:    authMiddleware,
-		LoggingMiddleware: loggingMiddleware,
-		UserHandler:       userHandler,
-		AuthHandler:       authHandler,
+		Router:                 router,
+		Config:                 cfg,
+		DB:                     db,
+		UserStore:              userStore,
+		RefreshTokenStore:      refreshTokenStore,
+		UserTokenStore:         userTokenStore,
+		MFARecoveryCodeStore:   mfaRecoveryCodeStore,
+		SecurityEventStore:     securityEventStore,
+		RevokedTokenStore:      revokedTokenStore,
+		LoginEventStore:        loginEventStore,
+		APIKeyStore:            apiKeyStore,
+		OAuthClientStore:       oauthClientStore,
+		AuthorizationCodeStore: authorizationCodeStore,
+		LinkedIdentityStore:    linkedIdentityStore,
+		PasswordHasher:         passwordHasher,
+		PasswordPolicy:         passwordPolicy,
+		TokenHasher:            tokenHasher,
+		TokenManager:           tokenManager,
+		Mailer:                 mailer,
+		AuthMiddleware:         authMiddleware,
+		LoggingMiddleware:      loggingMiddleware,
+		UserHandler:            userHandler,
+		AuthHandler:            authHandler,
+		MFAHandler:             mfaHandler,
+		APIKeyHandler:          apiKeyHandler,
+		OAuthHandler:           oauthHandler,
+		OIDCHandler:            oidcHandler,
+		WellKnownHandler:       wellKnownHandler,
 	}
 
 	// Set up routes
 	server.setupRoutes()
 
-	return server
+	return server, nil
+}
+
+// loadPasswordPolicy builds the password policy described by the configuration
+func loadPasswordPolicy(cfg config.AuthConfig) (*utils.PasswordPolicy, error) {
+	policy := &utils.PasswordPolicy{
+		MinLength:            cfg.PasswordMinLength,
+		MaxLength:            cfg.PasswordMaxLength,
+		RequiredClasses:      cfg.PasswordRequiredClasses,
+		ForbidEmailLocalPart: cfg.PasswordForbidEmail,
+	}
+
+	// bcrypt refuses longer passwords instead of hashing them
+	if cfg.PasswordHashAlgorithm == utils.AlgorithmBcrypt {
+		policy.MaxBytes = utils.BcryptMaxPasswordBytes
+	}
+
+	if cfg.BreachedPasswordsFile != "" {
+		breached, err := utils.LoadBreachedPasswords(cfg.BreachedPasswordsFile)
+		if err != nil {
+			return nil, err
+		}
+		policy.Breached = breached
+	}
+
+	return policy, nil
+}
+
+// loadKeyring builds the JWT keyring described by the configuration
+func loadKeyring(cfg config.AuthConfig) (*utils.Keyring, error) {
+	active, err := loadSigningKey(cfg.SigningKey)
+	if err != nil {
+		return nil, err
+	}
+
+	var verificationKeys []*utils.SigningKey
+	for _, keyConfig := range cfg.VerificationKeys {
+		key, err := loadSigningKey(keyConfig)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", keyConfig.ID, err)
+		}
+		verificationKeys = append(verificationKeys, key)
+	}
+
+	return utils.NewKeyring(active, verificationKeys...)
+}
+
+// loadSigningKey builds the JWT key described by the configuration
+func loadSigningKey(cfg config.SigningKeyConfig) (*utils.SigningKey, error) {
+	if cfg.Algorithm == utils.AlgorithmHS256 {
+		return utils.NewHMACSigningKey(cfg.ID, cfg.Secret)
+	}
+
+	return utils.LoadSigningKey(cfg.ID, cfg.Algorithm, cfg.KeyFile)
 }
 
 // setupRoutes sets up the API routes
@@ -81,23 +259,142 @@ func (s *Server) setupRoutes() {
 	// Apply middleware
 	s.Router.Use(s.LoggingMiddleware.RequestLogger())
 
+	// Discovery documents
+	s.Router.GET("/.well-known/jwks.json", s.WellKnownHandler.JWKS)
+	s.Router.GET(
+		"/.well-known/openid-configuration",
+		s.WellKnownHandler.OpenIDConfiguration,
+	)
+
+	// OAuth2 and OpenID Connect endpoints
+	oauth := s.Router.Group("/oauth")
+	{
+		oauth.GET("/authorize", s.OAuthHandler.Authorize)
+		oauth.POST("/authorize", s.OAuthHandler.Consent)
+		oauth.POST("/token", s.OAuthHandler.Token)
+		oauth.POST("/introspect", s.OAuthHandler.Introspect)
+		oauth.POST("/revoke", s.OAuthHandler.Revoke)
+	}
+
+	userInfo := s.Router.Group("/userinfo")
+	userInfo.Use(
+		s.AuthMiddleware.RequireAuth(),
+		s.AuthMiddleware.RequireScope(utils.ScopeOpenID),
+	)
+	{
+		userInfo.GET("", s.OAuthHandler.UserInfo)
+		userInfo.POST("", s.OAuthHandler.UserInfo)
+	}
+
 	// Versioned API group: /api/v1
 	v1 := s.Router.Group("/api/v1")
 	{
 		// Public routes
 		v1.POST("/signup", s.AuthHandler.Signup)
 		v1.POST("/login", s.AuthHandler.Login)
+		v1.POST("/login/mfa", s.AuthHandler.LoginMFA)
+		v1.POST("/login/magic-link", s.AuthHandler.RequestMagicLink)
+		v1.POST("/login/magic-link/verify", s.AuthHandler.VerifyMagicLink)
 		v1.POST("/refresh", s.AuthHandler.RefreshToken)
+		v1.POST("/password/forgot", s.AuthHandler.ForgotPassword)
+		v1.POST("/password/reset", s.AuthHandler.ResetPassword)
+		v1.GET("/verify-email", s.AuthHandler.VerifyEmail)
+		v1.POST("/verify-email", s.AuthHandler.VerifyEmail)
+		v1.POST("/verify-email/resend", s.AuthHandler.ResendVerificationEmail)
+
+		// Login with external identity providers
+		v1.GET("/auth/oidc/:provider/start", s.OIDCHandler.Start)
+		v1.GET("/auth/oidc/:provider/callback", s.OIDCHandler.Callback)
 
 		// Protected routes
 		authorized := v1.Group("/")
 		authorized.Use(s.AuthMiddleware.RequireAuth())
 		{
-			authorized.GET("/users", s.UserHandler.ListUsers)
-			authorized.GET("/users/:id", s.UserHandler.GetUser)
-			authorized.POST("/users", s.UserHandler.CreateUser)
-			authorized.PUT("/users/:id", s.UserHandler.UpdateUser)
-			authorized.DELETE("/users/:id", s.UserHandler.DeleteUser)
+			authorized.POST("/logout", s.AuthHandler.Logout)
+
+			// Credentials and sessions, never open to impersonation
+			account := authorized.Group("/")
+			account.Use(
+				s.AuthMiddleware.RequireScope(utils.ScopeAccount),
+				s.AuthMiddleware.RejectImpersonation(),
+			)
+			{
+				account.POST("/logout/all", s.AuthHandler.LogoutAll)
+				account.DELETE("/me", s.AuthHandler.DeleteMe)
+				account.POST("/me/password", s.AuthHandler.ChangePassword)
+				account.GET("/me/sessions", s.AuthHandler.ListSessions)
+				account.DELETE("/me/sessions/:id", s.AuthHandler.RevokeSession)
+				account.GET("/me/login-history", s.AuthHandler.ListLoginHistory)
+				account.POST("/auth/oidc/:provider/link", s.OIDCHandler.Link)
+
+				account.POST("/mfa/enroll", s.MFAHandler.Enroll)
+				account.POST("/mfa/confirm", s.MFAHandler.Confirm)
+				account.POST("/mfa/disable", s.MFAHandler.Disable)
+
+				account.GET("/me/api-keys", s.APIKeyHandler.ListAPIKeys)
+				account.POST("/me/api-keys", s.APIKeyHandler.CreateAPIKey)
+				account.DELETE("/me/api-keys/:id", s.APIKeyHandler.RevokeAPIKey)
+
+				clients := account.Group("/oauth/clients")
+				clients.Use(s.AuthMiddleware.RequireRole(models.RoleAdmin))
+				{
+					clients.GET("", s.OAuthHandler.ListClients)
+					clients.POST("", s.OAuthHandler.CreateClient)
+					clients.DELETE("/:id", s.OAuthHandler.RevokeClient)
+				}
+
+				account.POST(
+					"/admin/users/:id/impersonate",
+					s.AuthMiddleware.RequireRole(models.RoleAdmin),
+					s.AuthHandler.Impersonate,
+				)
+			}
+
+			// Non-admins may only access their own record, which the
+			// handlers check
+			readUsers := authorized.Group("/")
+			readUsers.Use(s.AuthMiddleware.RequireScope(utils.ScopeUsersRead))
+			{
+				readUsers.GET("/me", s.AuthHandler.GetMe)
+				readUsers.GET(
+					"/users",
+					s.AuthMiddleware.RequirePermission(models.PermissionUsersRead),
+					s.UserHandler.ListUsers,
+				)
+				readUsers.GET("/users/:id", s.UserHandler.GetUser)
+			}
+
+			writeUsers := authorized.Group("/")
+			writeUsers.Use(s.AuthMiddleware.RequireScope(utils.ScopeUsersWrite))
+			{
+				// Changing the email of one's own record would hand the
+				// account over, so impersonation may not
+				writeUsers.PATCH(
+					"/me",
+					s.AuthMiddleware.RejectImpersonation(),
+					s.AuthHandler.UpdateMe,
+				)
+				writeUsers.POST(
+					"/users",
+					s.AuthMiddleware.RequirePermission(models.PermissionUsersWrite),
+					s.UserHandler.CreateUser,
+				)
+				writeUsers.PUT(
+					"/users/:id",
+					s.AuthMiddleware.RejectImpersonation(),
+					s.UserHandler.UpdateUser,
+				)
+				writeUsers.DELETE(
+					"/users/:id",
+					s.AuthMiddleware.RejectImpersonation(),
+					s.UserHandler.DeleteUser,
+				)
+				writeUsers.POST(
+					"/users/:id/unlock",
+					s.AuthMiddleware.RequirePermission(models.PermissionUsersWrite),
+					s.UserHandler.UnlockUser,
+				)
+			}
 		}
 	}
 }
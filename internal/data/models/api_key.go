@@ -0,0 +1,38 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// APIKey represents a long-lived personal access token owned by a user
+type APIKey struct {
+	ID         uuid.UUID `gorm:"type:uuid;primary_key"`
+	UserID     uuid.UUID `gorm:"type:uuid;index;not null"`
+	Name       string    `gorm:"type:varchar(100);not null"`
+	Prefix     string    `gorm:"type:varchar(16);index;not null"` // shown to identify the key
+	KeyHash    string    `gorm:"type:varchar(64);uniqueIndex;not null"`
+	Key        string    `gorm:"-"`                          // plaintext, only set when issuing
+	Scope      string    `gorm:"type:varchar(255);not null"` // space separated
+	ExpiresAt  *time.Time
+	LastUsedAt *time.Time
+	RevokedAt  *time.Time
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+// IsValid reports whether the key is neither expired nor revoked
+func (k *APIKey) IsValid() bool {
+	if k.RevokedAt != nil {
+		return false
+	}
+
+	return k.ExpiresAt == nil || k.ExpiresAt.After(time.Now())
+}
+
+// Scopes returns the scopes granted to the key
+func (k *APIKey) Scopes() []string {
+	return strings.Fields(k.Scope)
+}
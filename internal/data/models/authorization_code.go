@@ -0,0 +1,25 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// AuthorizationCode represents a single-use OAuth2 authorization code that a
+// client exchanges for tokens after the user consented
+type AuthorizationCode struct {
+	ID            uuid.UUID `gorm:"type:uuid;primary_key"`
+	CodeHash      string    `gorm:"type:varchar(64);uniqueIndex;not null"`
+	Code          string    `gorm:"-"` // plaintext, only set when issuing
+	ClientID      string    `gorm:"type:varchar(64);index;not null"`
+	UserID        uuid.UUID `gorm:"type:uuid;index;not null"`
+	RedirectURI   string    `gorm:"type:text;not null"`
+	Scope         string    `gorm:"type:varchar(255);not null"`
+	Nonce         string    `gorm:"type:varchar(255);not null;default:''"`
+	CodeChallenge string    `gorm:"type:varchar(128);not null"` // S256
+	AuthTime      time.Time `gorm:"not null"`
+	ExpiresAt     time.Time `gorm:"not null"`
+	UsedAt        *time.Time
+	CreatedAt     time.Time
+}
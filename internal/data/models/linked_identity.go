@@ -0,0 +1,21 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// LinkedIdentity links an account at an external OpenID Connect identity
+// provider, identified by issuer and subject, to a user
+type LinkedIdentity struct {
+	ID          uuid.UUID `gorm:"type:uuid;primary_key"`
+	UserID      uuid.UUID `gorm:"type:uuid;index;not null"`
+	Provider    string    `gorm:"type:varchar(64);not null"`
+	Issuer      string    `gorm:"type:varchar(255);uniqueIndex:idx_linked_identities_issuer_subject;not null"`
+	Subject     string    `gorm:"type:varchar(255);uniqueIndex:idx_linked_identities_issuer_subject;not null"`
+	Email       string    `gorm:"type:varchar(255);not null;default:''"` // as last reported by the provider
+	LastLoginAt *time.Time
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
@@ -0,0 +1,35 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// LoginFailureReason tells why a login attempt was rejected
+type LoginFailureReason string
+
+const (
+	// LoginFailureInvalidPassword is a wrong password
+	LoginFailureInvalidPassword LoginFailureReason = "invalid_password"
+	// LoginFailureInvalidMFACode is a wrong TOTP or recovery code
+	LoginFailureInvalidMFACode LoginFailureReason = "invalid_mfa_code"
+	// LoginFailureAccountLocked is an attempt while the account is locked
+	LoginFailureAccountLocked LoginFailureReason = "account_locked"
+	// LoginFailureEmailNotVerified is a login before the email is verified
+	LoginFailureEmailNotVerified LoginFailureReason = "email_not_verified"
+)
+
+// LoginEvent represents a successful or failed login to an account. Events
+// are only ever appended and form the user's login history.
+type LoginEvent struct {
+	ID                uuid.UUID          `gorm:"type:uuid;primary_key"`
+	UserID            uuid.UUID          `gorm:"type:uuid;index;not null"`
+	Method            LoginMethod        `gorm:"type:varchar(32);not null"`
+	Success           bool               `gorm:"not null"`
+	Reason            LoginFailureReason `gorm:"type:varchar(32)"`
+	IPAddress         string             `gorm:"type:varchar(45)"`
+	UserAgent         string             `gorm:"type:varchar(512)"`
+	DeviceFingerprint string             `gorm:"type:varchar(64);index"`
+	CreatedAt         time.Time          `gorm:"index"`
+}
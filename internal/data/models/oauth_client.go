@@ -0,0 +1,49 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// OAuthClient represents a registered OAuth2 client, such as a backend
+// service using the client credentials grant or an app signing users in
+// through the authorization code flow. Public clients, such as SPAs and
+// mobile apps, cannot keep a secret and rely on PKCE alone. Clients have no
+// role; acting on their own behalf, their scopes decide what they may do.
+type OAuthClient struct {
+	ID           uuid.UUID `gorm:"type:uuid;primary_key"`
+	ClientID     string    `gorm:"type:varchar(64);uniqueIndex;not null"`
+	Name         string    `gorm:"type:varchar(100);not null"`
+	SecretHash   string    `gorm:"type:varchar(64);not null"`
+	Secret       string    `gorm:"-"`                             // plaintext, only set when issuing
+	Scope        string    `gorm:"type:varchar(255);not null"`    // allowed scopes, space separated
+	RedirectURIs string    `gorm:"type:text;not null;default:''"` // space separated
+	Public       bool      `gorm:"not null;default:false"`
+	Introspect   bool      `gorm:"not null;default:false"` // may introspect tokens of other clients
+	RevokedAt    *time.Time
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+// Scopes returns the scopes the client may request
+func (c *OAuthClient) Scopes() []string {
+	return strings.Fields(c.Scope)
+}
+
+// RedirectURIList returns the registered redirect URIs of the client
+func (c *OAuthClient) RedirectURIList() []string {
+	return strings.Fields(c.RedirectURIs)
+}
+
+// AllowsRedirectURI reports whether the URI exactly matches a registered
+// redirect URI
+func (c *OAuthClient) AllowsRedirectURI(uri string) bool {
+	for _, registered := range c.RedirectURIList() {
+		if registered == uri {
+			return true
+		}
+	}
+	return false
+}
@@ -6,22 +6,59 @@ import (
 	"github.com/google/uuid"
 )
 
-// RefreshToken represents a refresh token in the system
+// LoginMethod identifies how a session was started
+type LoginMethod string
+
+const (
+	// LoginMethodPassword is a login, signup or password change with the
+	// account password
+	LoginMethodPassword LoginMethod = "password"
+	// LoginMethodMagicLink is a login with an emailed link
+	LoginMethodMagicLink LoginMethod = "magic_link"
+	// LoginMethodOIDC is a login with an external identity provider
+	LoginMethodOIDC LoginMethod = "oidc"
+	// LoginMethodOAuth is a sign-in to an OAuth client through the
+	// authorization code flow
+	LoginMethodOAuth LoginMethod = "oauth"
+)
+
+// RefreshToken represents a refresh token in the system. The tokens of a
+// family form one session, which is represented by its unrevoked token.
 type RefreshToken struct {
-	ID        uuid.UUID `gorm:"type:uuid;primary_key"`
-	UserID    uuid.UUID `gorm:"type:uuid;index;not null"`
-	Token     string    `gorm:"type:varchar(512);uniqueIndex;not null"`
-	ExpiresAt time.Time `gorm:"not null"`
-	CreatedAt time.Time
-	UpdatedAt time.Time
-	RevokedAt *time.Time
+	ID          uuid.UUID   `gorm:"type:uuid;primary_key"`
+	UserID      uuid.UUID   `gorm:"type:uuid;index;not null"`
+	FamilyID    uuid.UUID   `gorm:"type:uuid;index"`
+	ParentID    *uuid.UUID  `gorm:"type:uuid"`
+	TokenHash   string      `gorm:"type:varchar(64);uniqueIndex"`
+	Token       string      `gorm:"-"` // plaintext, only set when issuing
+	UserAgent   string      `gorm:"type:varchar(512);not null;default:''"`
+	IPAddress   string      `gorm:"type:varchar(45);not null;default:''"`
+	CreatedFrom LoginMethod `gorm:"type:varchar(32);not null;default:''"`       // inherited from the first token of the family
+	ClientID    string      `gorm:"type:varchar(64);index;not null;default:''"` // OAuth client signed in to, empty for first-party logins
+	LastUsedAt  *time.Time  // when the token was issued or last presented
+	ExpiresAt   time.Time   `gorm:"not null"`
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	RevokedAt   *time.Time
+	RotatedAt   *time.Time
 }
 
+// IsValid reports whether the token is neither expired nor revoked
 func (rt *RefreshToken) IsValid() bool {
 	// Check if the token has expired
 	if rt.ExpiresAt.Before(time.Now()) {
 		return false
 	}
 
+	// Check if the token has been revoked or rotated
+	if rt.RevokedAt != nil {
+		return false
+	}
+
 	return true
 }
+
+// IsRotated reports whether the token has already been exchanged for a new one
+func (rt *RefreshToken) IsRotated() bool {
+	return rt.RotatedAt != nil
+}
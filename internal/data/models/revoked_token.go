@@ -0,0 +1,23 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// RevokedToken records the ID (jti) of an access token that was revoked
+// before it expired
+type RevokedToken struct {
+	JTI       string    `gorm:"type:varchar(64);primary_key"`
+	ExpiresAt time.Time `gorm:"index;not null"`
+	CreatedAt time.Time
+}
+
+// RevokedSession records a revoked login, a refresh token family, whose access
+// tokens are rejected until the longest-lived of them expired
+type RevokedSession struct {
+	FamilyID  uuid.UUID `gorm:"type:uuid;primary_key"`
+	ExpiresAt time.Time `gorm:"index;not null"`
+	CreatedAt time.Time
+}
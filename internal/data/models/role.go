@@ -0,0 +1,50 @@
+package models
+
+// Role is the access level granted to a user
+type Role string
+
+const (
+	// RoleUser may only manage their own account
+	RoleUser Role = "user"
+	// RoleAdmin may manage every account
+	RoleAdmin Role = "admin"
+)
+
+// Permission names an action that a role may be allowed to perform
+type Permission string
+
+const (
+	// PermissionUsersRead allows reading any user
+	PermissionUsersRead Permission = "users:read"
+	// PermissionUsersWrite allows creating, updating and unlocking any user
+	PermissionUsersWrite Permission = "users:write"
+	// PermissionUsersDelete allows deleting any user
+	PermissionUsersDelete Permission = "users:delete"
+)
+
+// rolePermissions lists the permissions granted to each role. Users can
+// always act on their own record regardless of these.
+var rolePermissions = map[Role][]Permission{
+	RoleAdmin: {
+		PermissionUsersRead,
+		PermissionUsersWrite,
+		PermissionUsersDelete,
+	},
+	RoleUser: {},
+}
+
+// IsValid reports whether the role is known
+func (r Role) IsValid() bool {
+	_, ok := rolePermissions[r]
+	return ok
+}
+
+// Can reports whether the role grants the permission
+func (r Role) Can(permission Permission) bool {
+	for _, granted := range rolePermissions[r] {
+		if granted == permission {
+			return true
+		}
+	}
+	return false
+}
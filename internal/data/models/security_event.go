@@ -0,0 +1,30 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// SecurityEventType identifies the kind of security incident
+type SecurityEventType string
+
+const (
+	// SecurityEventRefreshTokenReuse is recorded when a rotated refresh
+	// token is presented again, which indicates it has been stolen
+	SecurityEventRefreshTokenReuse SecurityEventType = "refresh_token_reuse"
+	// SecurityEventImpersonation is recorded when an admin starts acting as
+	// a user
+	SecurityEventImpersonation SecurityEventType = "impersonation"
+)
+
+// SecurityEvent represents a security incident worth alerting on
+type SecurityEvent struct {
+	ID        uuid.UUID         `gorm:"type:uuid;primary_key"`
+	UserID    *uuid.UUID        `gorm:"type:uuid;index"`
+	Type      SecurityEventType `gorm:"type:varchar(64);index;not null"`
+	IPAddress string            `gorm:"type:varchar(45)"`
+	UserAgent string            `gorm:"type:varchar(512)"`
+	Details   string            `gorm:"type:text"`
+	CreatedAt time.Time         `gorm:"index"`
+}
@@ -9,10 +9,34 @@ import (
 
 // User represents a user in the system
 type User struct {
-	ID        uuid.UUID `gorm:"type:uuid;primary_key"`
-	Email     string    `gorm:"type:varchar(255);uniqueIndex;not null"`
-	Password  string    `gorm:"type:varchar(255);not null"`
-	CreatedAt time.Time
-	UpdatedAt time.Time
-	DeletedAt gorm.DeletedAt `gorm:"index"`
+	ID                  uuid.UUID `gorm:"type:uuid;primary_key"`
+	Email               string    `gorm:"type:varchar(255);uniqueIndex;not null"`
+	Password            string    `gorm:"type:varchar(255);not null"`
+	Role                Role      `gorm:"type:varchar(32);not null;default:'user'"`
+	EmailVerifiedAt     *time.Time
+	MFASecret           string `gorm:"type:varchar(255);not null;default:''"`
+	MFAEnabledAt        *time.Time
+	MFALastCounter      int64 `gorm:"not null;default:0"` // last accepted TOTP step
+	FailedLoginAttempts int   `gorm:"not null;default:0"`
+	LockedUntil         *time.Time
+	TokensValidAfter    *time.Time `gorm:"index"` // access tokens issued earlier are revoked
+	CreatedAt           time.Time
+	UpdatedAt           time.Time
+	DeletedAt           gorm.DeletedAt `gorm:"index"`
+}
+
+// IsEmailVerified reports whether the user has confirmed their email address
+func (u *User) IsEmailVerified() bool {
+	return u.EmailVerifiedAt != nil
+}
+
+// IsMFAEnabled reports whether the user completed TOTP enrollment
+func (u *User) IsMFAEnabled() bool {
+	return u.MFAEnabledAt != nil && u.MFASecret != ""
+}
+
+// IsLocked reports whether the account is temporarily locked after too many
+// failed logins
+func (u *User) IsLocked() bool {
+	return u.LockedUntil != nil && u.LockedUntil.After(time.Now())
 }
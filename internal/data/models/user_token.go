@@ -0,0 +1,32 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// UserTokenPurpose identifies what a single-use user token may be redeemed for
+type UserTokenPurpose string
+
+const (
+	// UserTokenPasswordReset allows setting a new password
+	UserTokenPasswordReset UserTokenPurpose = "password_reset"
+	// UserTokenEmailVerification confirms ownership of the email address
+	UserTokenEmailVerification UserTokenPurpose = "email_verification"
+	// UserTokenMagicLink allows logging in without a password
+	UserTokenMagicLink UserTokenPurpose = "magic_link"
+)
+
+// UserToken represents a single-use, short-lived token sent to a user
+type UserToken struct {
+	ID        uuid.UUID        `gorm:"type:uuid;primary_key"`
+	UserID    uuid.UUID        `gorm:"type:uuid;index;not null"`
+	Purpose   UserTokenPurpose `gorm:"type:varchar(32);index;not null"`
+	TokenHash string           `gorm:"type:varchar(64);uniqueIndex;not null"`
+	Email     string           `gorm:"type:varchar(255);not null;default:''"` // address the token was sent to
+	Token     string           `gorm:"-"`                                     // plaintext, only set when issuing
+	ExpiresAt time.Time        `gorm:"not null"`
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
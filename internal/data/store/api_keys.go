@@ -0,0 +1,150 @@
+package store
+
+import (
+	"time"
+
+	"github.com/EngenMe/go-api-dod/internal/data/models"
+	"github.com/EngenMe/go-api-dod/internal/utils"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// apiKeyLastUsedResolution limits how often last_used_at is written for keys
+// that are used in quick succession
+const apiKeyLastUsedResolution = time.Minute
+
+// APIKeyStore provides methods to interact with the api_keys table.
+// Keys are only ever persisted as keyed hashes.
+type APIKeyStore struct {
+	DB          *gorm.DB
+	TokenHasher *utils.TokenHasher
+}
+
+// NewAPIKeyStore creates a new APIKeyStore
+func NewAPIKeyStore(db *gorm.DB, tokenHasher *utils.TokenHasher) *APIKeyStore {
+	return &APIKeyStore{
+		DB:          db,
+		TokenHasher: tokenHasher,
+	}
+}
+
+// Create creates a new API key
+func (s *APIKeyStore) Create(apiKey *models.APIKey) error {
+	if apiKey.ID == uuid.Nil {
+		apiKey.ID = uuid.New()
+	}
+	apiKey.KeyHash = s.TokenHasher.Hash(apiKey.Key)
+	apiKey.CreatedAt = time.Now()
+	apiKey.UpdatedAt = time.Now()
+
+	query := `
+        INSERT INTO api_keys (id, user_id, name, prefix, key_hash, scope, expires_at, created_at, updated_at)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+    `
+	result := s.DB.Exec(
+		query,
+		apiKey.ID,
+		apiKey.UserID,
+		apiKey.Name,
+		apiKey.Prefix,
+		apiKey.KeyHash,
+		apiKey.Scope,
+		apiKey.ExpiresAt,
+		apiKey.CreatedAt,
+		apiKey.UpdatedAt,
+	)
+	return result.Error
+}
+
+// GetByID retrieves an API key by ID
+func (s *APIKeyStore) GetByID(id uuid.UUID) (*models.APIKey, error) {
+	var apiKey models.APIKey
+	query := `
+        SELECT id, user_id, name, prefix, key_hash, scope, expires_at, last_used_at, revoked_at, created_at, updated_at
+        FROM api_keys
+        WHERE id = $1
+    `
+	result := s.DB.Raw(query, id).Scan(&apiKey)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return nil, nil
+	}
+
+	return &apiKey, nil
+}
+
+// GetByKey retrieves an API key by the hash of its plaintext key
+func (s *APIKeyStore) GetByKey(key string) (*models.APIKey, error) {
+	var apiKey models.APIKey
+	query := `
+        SELECT id, user_id, name, prefix, key_hash, scope, expires_at, last_used_at, revoked_at, created_at, updated_at
+        FROM api_keys
+        WHERE key_hash = $1
+    `
+	result := s.DB.Raw(query, s.TokenHasher.Hash(key)).Scan(&apiKey)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return nil, nil
+	}
+
+	return &apiKey, nil
+}
+
+// GetByUserID retrieves all unrevoked API keys of a user
+func (s *APIKeyStore) GetByUserID(userID uuid.UUID) ([]models.APIKey, error) {
+	var apiKeys []models.APIKey
+	query := `
+        SELECT id, user_id, name, prefix, key_hash, scope, expires_at, last_used_at, revoked_at, created_at, updated_at
+        FROM api_keys
+        WHERE user_id = $1 AND revoked_at IS NULL
+        ORDER BY created_at DESC
+    `
+	result := s.DB.Raw(query, userID).Scan(&apiKeys)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return apiKeys, nil
+}
+
+// TouchLastUsed records that an API key was just used
+func (s *APIKeyStore) TouchLastUsed(id uuid.UUID) error {
+	now := time.Now()
+	query := `
+        UPDATE api_keys
+        SET last_used_at = $1
+        WHERE id = $2 AND (last_used_at IS NULL OR last_used_at < $3)
+    `
+	result := s.DB.Exec(query, now, id, now.Add(-apiKeyLastUsedResolution))
+	return result.Error
+}
+
+// Revoke revokes an API key
+func (s *APIKeyStore) Revoke(id uuid.UUID) error {
+	now := time.Now()
+	query := `
+        UPDATE api_keys
+        SET revoked_at = $1, updated_at = $2
+        WHERE id = $3 AND revoked_at IS NULL
+    `
+	result := s.DB.Exec(query, now, now, id)
+	return result.Error
+}
+
+// RevokeAllForUser revokes all API keys of a user
+func (s *APIKeyStore) RevokeAllForUser(userID uuid.UUID) error {
+	now := time.Now()
+	query := `
+        UPDATE api_keys
+        SET revoked_at = $1, updated_at = $2
+        WHERE user_id = $3 AND revoked_at IS NULL
+    `
+	result := s.DB.Exec(query, now, now, userID)
+	return result.Error
+}
@@ -0,0 +1,100 @@
+package store
+
+import (
+	"time"
+
+	"github.com/EngenMe/go-api-dod/internal/data/models"
+	"github.com/EngenMe/go-api-dod/internal/utils"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// AuthorizationCodeStore provides methods to interact with the
+// authorization_codes table. Codes are only ever persisted as keyed hashes.
+type AuthorizationCodeStore struct {
+	DB          *gorm.DB
+	TokenHasher *utils.TokenHasher
+}
+
+// NewAuthorizationCodeStore creates a new AuthorizationCodeStore
+func NewAuthorizationCodeStore(
+	db *gorm.DB,
+	tokenHasher *utils.TokenHasher,
+) *AuthorizationCodeStore {
+	return &AuthorizationCodeStore{
+		DB:          db,
+		TokenHasher: tokenHasher,
+	}
+}
+
+// Create creates a new authorization code
+func (s *AuthorizationCodeStore) Create(code *models.AuthorizationCode) error {
+	if code.ID == uuid.Nil {
+		code.ID = uuid.New()
+	}
+	code.CodeHash = s.TokenHasher.Hash(code.Code)
+	code.CreatedAt = time.Now()
+
+	query := `
+        INSERT INTO authorization_codes (id, code_hash, client_id, user_id, redirect_uri, scope, nonce, code_challenge, auth_time, expires_at, created_at)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+    `
+	result := s.DB.Exec(
+		query,
+		code.ID,
+		code.CodeHash,
+		code.ClientID,
+		code.UserID,
+		code.RedirectURI,
+		code.Scope,
+		code.Nonce,
+		code.CodeChallenge,
+		code.AuthTime,
+		code.ExpiresAt,
+		code.CreatedAt,
+	)
+	return result.Error
+}
+
+// Consume atomically marks an unused, unexpired code as used and returns it.
+// It returns nil if no such code exists.
+func (s *AuthorizationCodeStore) Consume(code string) (
+	*models.AuthorizationCode,
+	error,
+) {
+	var authorizationCode models.AuthorizationCode
+	now := time.Now()
+	query := `
+        UPDATE authorization_codes
+        SET used_at = $1
+        WHERE code_hash = $2
+          AND used_at IS NULL
+          AND expires_at > $3
+        RETURNING id, code_hash, client_id, user_id, redirect_uri, scope, nonce, code_challenge, auth_time, expires_at, used_at, created_at
+    `
+	result := s.DB.Raw(
+		query,
+		now,
+		s.TokenHasher.Hash(code),
+		now,
+	).Scan(&authorizationCode)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return nil, nil
+	}
+
+	return &authorizationCode, nil
+}
+
+// DeleteExpired deletes all expired authorization codes
+func (s *AuthorizationCodeStore) DeleteExpired() error {
+	query := `
+        DELETE FROM authorization_codes
+        WHERE expires_at < $1
+    `
+	result := s.DB.Exec(query, time.Now())
+	return result.Error
+}
@@ -0,0 +1,130 @@
+package store
+
+import (
+	"time"
+
+	"github.com/EngenMe/go-api-dod/internal/data/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// LinkedIdentityStore provides methods to interact with the
+// linked_identities table
+type LinkedIdentityStore struct {
+	DB *gorm.DB
+}
+
+// NewLinkedIdentityStore creates a new LinkedIdentityStore
+func NewLinkedIdentityStore(db *gorm.DB) *LinkedIdentityStore {
+	return &LinkedIdentityStore{
+		DB: db,
+	}
+}
+
+// Create links an external identity to an existing user
+func (s *LinkedIdentityStore) Create(identity *models.LinkedIdentity) error {
+	return s.create(s.DB, identity)
+}
+
+// CreateWithUser creates a new user together with the external identity it
+// was created for. The user's email is marked verified if EmailVerifiedAt is
+// set.
+func (s *LinkedIdentityStore) CreateWithUser(
+	user *models.User,
+	identity *models.LinkedIdentity,
+) error {
+	return s.DB.Transaction(
+		func(tx *gorm.DB) error {
+			users := &UserStore{DB: tx}
+			if err := users.Create(user); err != nil {
+				return err
+			}
+			if user.EmailVerifiedAt != nil {
+				if err := users.MarkEmailVerified(user.ID); err != nil {
+					return err
+				}
+			}
+
+			identity.UserID = user.ID
+			return s.create(tx, identity)
+		},
+	)
+}
+
+// create inserts a linked identity using db, which may be a transaction
+func (s *LinkedIdentityStore) create(
+	db *gorm.DB,
+	identity *models.LinkedIdentity,
+) error {
+	if identity.ID == uuid.Nil {
+		identity.ID = uuid.New()
+	}
+	now := time.Now()
+	identity.LastLoginAt = &now
+	identity.CreatedAt = now
+	identity.UpdatedAt = now
+
+	query := `
+        INSERT INTO linked_identities (id, user_id, provider, issuer, subject, email, last_login_at, created_at, updated_at)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+    `
+	result := db.Exec(
+		query,
+		identity.ID,
+		identity.UserID,
+		identity.Provider,
+		identity.Issuer,
+		identity.Subject,
+		identity.Email,
+		identity.LastLoginAt,
+		identity.CreatedAt,
+		identity.UpdatedAt,
+	)
+	return result.Error
+}
+
+// GetByIssuerSubject retrieves the identity with the subject at the issuer
+func (s *LinkedIdentityStore) GetByIssuerSubject(issuer, subject string) (
+	*models.LinkedIdentity,
+	error,
+) {
+	var identity models.LinkedIdentity
+	query := `
+        SELECT id, user_id, provider, issuer, subject, email, last_login_at, created_at, updated_at
+        FROM linked_identities
+        WHERE issuer = $1 AND subject = $2
+    `
+	result := s.DB.Raw(query, issuer, subject).Scan(&identity)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return nil, nil
+	}
+
+	return &identity, nil
+}
+
+// RecordLogin stores the time of a login with the identity and the email
+// address the provider reported
+func (s *LinkedIdentityStore) RecordLogin(id uuid.UUID, email string) error {
+	now := time.Now()
+	query := `
+        UPDATE linked_identities
+        SET email = $1, last_login_at = $2, updated_at = $3
+        WHERE id = $4
+    `
+	result := s.DB.Exec(query, email, now, now, id)
+	return result.Error
+}
+
+// Delete deletes a linked identity
+func (s *LinkedIdentityStore) Delete(id uuid.UUID) error {
+	query := `
+        DELETE FROM linked_identities
+        WHERE id = $1
+    `
+	result := s.DB.Exec(query, id)
+	return result.Error
+}
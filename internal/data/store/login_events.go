@@ -0,0 +1,97 @@
+package store
+
+import (
+	"time"
+
+	"github.com/EngenMe/go-api-dod/internal/data/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// LoginEventStore provides methods to interact with the login_events table.
+// The history is append-only, so events are never updated or deleted.
+type LoginEventStore struct {
+	DB *gorm.DB
+}
+
+// NewLoginEventStore creates a new LoginEventStore
+func NewLoginEventStore(db *gorm.DB) *LoginEventStore {
+	return &LoginEventStore{
+		DB: db,
+	}
+}
+
+// Create records a new login event
+func (s *LoginEventStore) Create(event *models.LoginEvent) error {
+	if event.ID == uuid.Nil {
+		event.ID = uuid.New()
+	}
+	event.CreatedAt = time.Now()
+
+	query := `
+        INSERT INTO login_events (id, user_id, method, success, reason, ip_address,
+            user_agent, device_fingerprint, created_at)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+    `
+	result := s.DB.Exec(
+		query,
+		event.ID,
+		event.UserID,
+		event.Method,
+		event.Success,
+		event.Reason,
+		event.IPAddress,
+		event.UserAgent,
+		event.DeviceFingerprint,
+		event.CreatedAt,
+	)
+	return result.Error
+}
+
+// ListByUserID retrieves a page of a user's login events, newest first
+func (s *LoginEventStore) ListByUserID(
+	userID uuid.UUID,
+	limit, offset int,
+) ([]models.LoginEvent, error) {
+	var events []models.LoginEvent
+	query := `
+        SELECT id, user_id, method, success, reason, ip_address, user_agent,
+            device_fingerprint, created_at
+        FROM login_events
+        WHERE user_id = $1
+        ORDER BY created_at DESC
+        LIMIT $2 OFFSET $3
+    `
+	result := s.DB.Raw(query, userID, limit, offset).Scan(&events)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return events, nil
+}
+
+// KnownDevice reports whether the user has logged in successfully from the
+// device before and whether they have logged in successfully at all. The
+// event being checked, excludeID, does not count.
+func (s *LoginEventStore) KnownDevice(
+	userID uuid.UUID,
+	fingerprint string,
+	excludeID uuid.UUID,
+) (bool, bool, error) {
+	var counts struct {
+		Total      int64
+		FromDevice int64
+	}
+	query := `
+        SELECT COUNT(*) AS total,
+            COUNT(*) FILTER (WHERE device_fingerprint = $2) AS from_device
+        FROM login_events
+        WHERE user_id = $1 AND success AND id <> $3
+    `
+	result := s.DB.Raw(query, userID, fingerprint, excludeID).Scan(&counts)
+	if result.Error != nil {
+		return false, false, result.Error
+	}
+
+	return counts.FromDevice > 0, counts.Total > 0, nil
+}
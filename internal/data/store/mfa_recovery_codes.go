@@ -0,0 +1,116 @@
+package store
+
+import (
+	"time"
+
+	"github.com/EngenMe/go-api-dod/internal/utils"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// MFARecoveryCodeStore provides methods to interact with the
+// mfa_recovery_codes table. Codes are only ever persisted as keyed hashes.
+type MFARecoveryCodeStore struct {
+	DB          *gorm.DB
+	TokenHasher *utils.TokenHasher
+}
+
+// NewMFARecoveryCodeStore creates a new MFARecoveryCodeStore
+func NewMFARecoveryCodeStore(
+	db *gorm.DB,
+	tokenHasher *utils.TokenHasher,
+) *MFARecoveryCodeStore {
+	return &MFARecoveryCodeStore{
+		DB:          db,
+		TokenHasher: tokenHasher,
+	}
+}
+
+// ReplaceForUser deletes the existing recovery codes of a user and stores the
+// given ones
+func (s *MFARecoveryCodeStore) ReplaceForUser(
+	userID uuid.UUID,
+	codes []string,
+) error {
+	return s.DB.Transaction(
+		func(tx *gorm.DB) error {
+			query := `
+                DELETE FROM mfa_recovery_codes
+                WHERE user_id = $1
+            `
+			if err := tx.Exec(query, userID).Error; err != nil {
+				return err
+			}
+
+			now := time.Now()
+			for _, code := range codes {
+				insert := `
+                    INSERT INTO mfa_recovery_codes (id, user_id, code_hash, created_at)
+                    VALUES ($1, $2, $3, $4)
+                `
+				result := tx.Exec(
+					insert,
+					uuid.New(),
+					userID,
+					s.TokenHasher.Hash(utils.NormalizeRecoveryCode(code)),
+					now,
+				)
+				if result.Error != nil {
+					return result.Error
+				}
+			}
+
+			return nil
+		},
+	)
+}
+
+// Consume atomically marks an unused recovery code of the user as used.
+// It reports whether a matching code was found.
+func (s *MFARecoveryCodeStore) Consume(userID uuid.UUID, code string) (
+	bool,
+	error,
+) {
+	query := `
+        UPDATE mfa_recovery_codes
+        SET used_at = $1
+        WHERE user_id = $2 AND code_hash = $3 AND used_at IS NULL
+    `
+	result := s.DB.Exec(
+		query,
+		time.Now(),
+		userID,
+		s.TokenHasher.Hash(utils.NormalizeRecoveryCode(code)),
+	)
+	if result.Error != nil {
+		return false, result.Error
+	}
+
+	return result.RowsAffected > 0, nil
+}
+
+// CountRemaining returns the number of unused recovery codes of a user
+func (s *MFARecoveryCodeStore) CountRemaining(userID uuid.UUID) (int, error) {
+	var count int
+	query := `
+        SELECT COUNT(*)
+        FROM mfa_recovery_codes
+        WHERE user_id = $1 AND used_at IS NULL
+    `
+	result := s.DB.Raw(query, userID).Scan(&count)
+	if result.Error != nil {
+		return 0, result.Error
+	}
+
+	return count, nil
+}
+
+// DeleteForUser deletes every recovery code of a user
+func (s *MFARecoveryCodeStore) DeleteForUser(userID uuid.UUID) error {
+	query := `
+        DELETE FROM mfa_recovery_codes
+        WHERE user_id = $1
+    `
+	result := s.DB.Exec(query, userID)
+	return result.Error
+}
@@ -0,0 +1,156 @@
+package store
+
+import (
+	"time"
+
+	"github.com/EngenMe/go-api-dod/internal/data/models"
+	"github.com/EngenMe/go-api-dod/internal/utils"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// oauthClientColumns lists the oauth_clients columns scanned into
+// models.OAuthClient
+const oauthClientColumns = `id, client_id, name, secret_hash, scope,
+        redirect_uris, public, introspect, revoked_at, created_at, updated_at`
+
+// OAuthClientStore provides methods to interact with the oauth_clients table.
+// Client secrets are only ever persisted as keyed hashes.
+type OAuthClientStore struct {
+	DB          *gorm.DB
+	TokenHasher *utils.TokenHasher
+}
+
+// NewOAuthClientStore creates a new OAuthClientStore
+func NewOAuthClientStore(
+	db *gorm.DB,
+	tokenHasher *utils.TokenHasher,
+) *OAuthClientStore {
+	return &OAuthClientStore{
+		DB:          db,
+		TokenHasher: tokenHasher,
+	}
+}
+
+// Create registers a new client
+func (s *OAuthClientStore) Create(client *models.OAuthClient) error {
+	if client.ID == uuid.Nil {
+		client.ID = uuid.New()
+	}
+	// Public clients have no secret to hash
+	if !client.Public {
+		client.SecretHash = s.TokenHasher.Hash(client.Secret)
+	}
+	client.CreatedAt = time.Now()
+	client.UpdatedAt = time.Now()
+
+	query := `
+        INSERT INTO oauth_clients (id, client_id, name, secret_hash, scope, redirect_uris, public, introspect, created_at, updated_at)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+    `
+	result := s.DB.Exec(
+		query,
+		client.ID,
+		client.ClientID,
+		client.Name,
+		client.SecretHash,
+		client.Scope,
+		client.RedirectURIs,
+		client.Public,
+		client.Introspect,
+		client.CreatedAt,
+		client.UpdatedAt,
+	)
+	return result.Error
+}
+
+// GetByID retrieves a client by ID
+func (s *OAuthClientStore) GetByID(id uuid.UUID) (*models.OAuthClient, error) {
+	var client models.OAuthClient
+	query := `
+        SELECT ` + oauthClientColumns + `
+        FROM oauth_clients
+        WHERE id = $1
+    `
+	result := s.DB.Raw(query, id).Scan(&client)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return nil, nil
+	}
+
+	return &client, nil
+}
+
+// GetByClientID retrieves an unrevoked client by its client ID
+func (s *OAuthClientStore) GetByClientID(clientID string) (
+	*models.OAuthClient,
+	error,
+) {
+	var client models.OAuthClient
+	query := `
+        SELECT ` + oauthClientColumns + `
+        FROM oauth_clients
+        WHERE client_id = $1 AND revoked_at IS NULL
+    `
+	result := s.DB.Raw(query, clientID).Scan(&client)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return nil, nil
+	}
+
+	return &client, nil
+}
+
+// Authenticate retrieves an unrevoked confidential client by its client ID
+// and secret. It returns nil if the client is unknown, public or the secret
+// does not match.
+func (s *OAuthClientStore) Authenticate(clientID, secret string) (
+	*models.OAuthClient,
+	error,
+) {
+	client, err := s.GetByClientID(clientID)
+	if err != nil || client == nil {
+		return nil, err
+	}
+
+	if client.Public || !s.TokenHasher.Check(secret, client.SecretHash) {
+		return nil, nil
+	}
+
+	return client, nil
+}
+
+// List retrieves all unrevoked clients
+func (s *OAuthClientStore) List() ([]models.OAuthClient, error) {
+	var clients []models.OAuthClient
+	query := `
+        SELECT ` + oauthClientColumns + `
+        FROM oauth_clients
+        WHERE revoked_at IS NULL
+        ORDER BY created_at DESC
+    `
+	result := s.DB.Raw(query).Scan(&clients)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return clients, nil
+}
+
+// Revoke revokes a client so that it can no longer obtain tokens
+func (s *OAuthClientStore) Revoke(id uuid.UUID) error {
+	now := time.Now()
+	query := `
+        UPDATE oauth_clients
+        SET revoked_at = $1, updated_at = $2
+        WHERE id = $3 AND revoked_at IS NULL
+    `
+	result := s.DB.Exec(query, now, now, id)
+	return result.Error
+}
@@ -5,7 +5,9 @@ import (
 
 	"github.com/EngenMe/go-api-dod/config"
 	"github.com/EngenMe/go-api-dod/internal/data/models"
+	"github.com/EngenMe/go-api-dod/internal/utils"
 
+	"github.com/google/uuid"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
@@ -39,5 +41,135 @@ func NewPostgresStore(cfg config.DatabaseConfig) (*PostgresStore, error) {
 
 // RunMigrations runs database migrations using GORM's AutoMigrate
 func (s *PostgresStore) RunMigrations() error {
-	return s.DB.AutoMigrate(&models.User{}, &models.RefreshToken{})
+	err := s.DB.AutoMigrate(
+		&models.User{},
+		&models.RefreshToken{},
+		&models.UserToken{},
+		&models.MFARecoveryCode{},
+		&models.SecurityEvent{},
+		&models.APIKey{},
+		&models.OAuthClient{},
+		&models.RevokedToken{},
+		&models.RevokedSession{},
+		&models.AuthorizationCode{},
+		&models.LinkedIdentity{},
+		&models.LoginEvent{},
+	)
+	if err != nil {
+		return err
+	}
+
+	// Refresh tokens issued before family tracking start their own family
+	return s.DB.Exec(
+		`UPDATE refresh_tokens SET family_id = id WHERE family_id IS NULL`,
+	).Error
+}
+
+// MigrateRefreshTokenHashes replaces refresh tokens stored in plaintext by
+// older releases with their keyed hash and drops the plaintext column.
+// It is a no-op once the plaintext column is gone.
+func (s *PostgresStore) MigrateRefreshTokenHashes(
+	tokenHasher *utils.TokenHasher,
+) error {
+	if !s.DB.Migrator().HasColumn(&models.RefreshToken{}, "token") {
+		return nil
+	}
+
+	return s.DB.Transaction(
+		func(tx *gorm.DB) error {
+			var rows []struct {
+				ID    uuid.UUID
+				Token string
+			}
+			query := `
+                SELECT id, token
+                FROM refresh_tokens
+                WHERE token_hash IS NULL
+            `
+			if err := tx.Raw(query).Scan(&rows).Error; err != nil {
+				return err
+			}
+
+			for _, row := range rows {
+				result := tx.Exec(
+					`UPDATE refresh_tokens SET token_hash = $1 WHERE id = $2`,
+					tokenHasher.Hash(row.Token),
+					row.ID,
+				)
+				if result.Error != nil {
+					return result.Error
+				}
+			}
+
+			return tx.Migrator().DropColumn(&models.RefreshToken{}, "token")
+		},
+	)
+}
+
+// MigrateClientRoles drops the role column of OAuth clients, which are
+// authorized by their scopes instead, and returns the IDs of the clients that
+// had the admin role. It is a no-op once the column is gone.
+func (s *PostgresStore) MigrateClientRoles() ([]string, error) {
+	if !s.DB.Migrator().HasColumn(&models.OAuthClient{}, "role") {
+		return nil, nil
+	}
+
+	var clientIDs []string
+	err := s.DB.Transaction(
+		func(tx *gorm.DB) error {
+			query := `
+                SELECT client_id
+                FROM oauth_clients
+                WHERE role = 'admin'
+            `
+			if err := tx.Raw(query).Scan(&clientIDs).Error; err != nil {
+				return err
+			}
+
+			return tx.Migrator().DropColumn(&models.OAuthClient{}, "role")
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return clientIDs, nil
+}
+
+// MigrateMFASecrets encrypts TOTP secrets stored in plaintext by older
+// releases. It is a no-op once every secret is encrypted.
+func (s *PostgresStore) MigrateMFASecrets(secretBox *utils.SecretBox) error {
+	return s.DB.Transaction(
+		func(tx *gorm.DB) error {
+			var rows []struct {
+				ID        uuid.UUID
+				MFASecret string
+			}
+			query := `
+                SELECT id, mfa_secret
+                FROM users
+                WHERE mfa_secret <> '' AND mfa_secret NOT LIKE 'enc:%'
+            `
+			if err := tx.Raw(query).Scan(&rows).Error; err != nil {
+				return err
+			}
+
+			for _, row := range rows {
+				sealed, err := secretBox.Seal(row.MFASecret, row.ID.String())
+				if err != nil {
+					return err
+				}
+				result := tx.Exec(
+					`UPDATE users SET mfa_secret = $1 WHERE id = $2`,
+					sealed,
+					row.ID,
+				)
+				if result.Error != nil {
+					return result.Error
+				}
+			}
+
+			return nil
+		},
+	)
 }
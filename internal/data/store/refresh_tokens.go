@@ -1,42 +1,88 @@
 package store
 
 import (
+	"errors"
 	"time"
 
 	"github.com/EngenMe/go-api-dod/internal/data/models"
+	"github.com/EngenMe/go-api-dod/internal/utils"
 	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
-// RefreshTokenStore provides methods to interact with the refresh_tokens table
+// ErrRefreshTokenRevoked is returned when rotating a refresh token that has
+// been revoked, e.g. by a concurrent logout
+var ErrRefreshTokenRevoked = errors.New("refresh token already revoked")
+
+// ErrRefreshTokenRotated is returned when rotating a refresh token that a
+// concurrent request has rotated first
+var ErrRefreshTokenRotated = errors.New("refresh token already rotated")
+
+// refreshTokenColumns lists the columns read into models.RefreshToken
+const refreshTokenColumns = `id, user_id, family_id, parent_id, token_hash,
+        user_agent, ip_address, created_from, client_id, last_used_at,
+        expires_at, created_at, updated_at, revoked_at, rotated_at`
+
+// RefreshTokenStore provides methods to interact with the refresh_tokens table.
+// Tokens are only ever persisted as keyed hashes. Revoking every session of
+// a user also revokes their access tokens through the RevokedTokenStore.
 type RefreshTokenStore struct {
-	DB *gorm.DB
+	DB                *gorm.DB
+	TokenHasher       *utils.TokenHasher
+	RevokedTokenStore *RevokedTokenStore
 }
 
 // NewRefreshTokenStore creates a new RefreshTokenStore
-func NewRefreshTokenStore(db *gorm.DB) *RefreshTokenStore {
+func NewRefreshTokenStore(
+	db *gorm.DB,
+	tokenHasher *utils.TokenHasher,
+	revokedTokenStore *RevokedTokenStore,
+) *RefreshTokenStore {
 	return &RefreshTokenStore{
-		DB: db,
+		DB:                db,
+		TokenHasher:       tokenHasher,
+		RevokedTokenStore: revokedTokenStore,
 	}
 }
 
-// Create creates a new refresh token
+// Create creates a new refresh token, starting a new family unless one is set
 func (s *RefreshTokenStore) Create(refreshToken *models.RefreshToken) error {
+	return s.create(s.DB, refreshToken)
+}
+
+// create inserts a refresh token using the given database handle
+func (s *RefreshTokenStore) create(
+	db *gorm.DB,
+	refreshToken *models.RefreshToken,
+) error {
 	if refreshToken.ID == uuid.Nil {
 		refreshToken.ID = uuid.New()
 	}
-	refreshToken.CreatedAt = time.Now()
-	refreshToken.UpdatedAt = time.Now()
+	if refreshToken.FamilyID == uuid.Nil {
+		refreshToken.FamilyID = refreshToken.ID
+	}
+	refreshToken.TokenHash = s.TokenHasher.Hash(refreshToken.Token)
+	now := time.Now()
+	refreshToken.LastUsedAt = &now
+	refreshToken.CreatedAt = now
+	refreshToken.UpdatedAt = now
 
 	query := `
-        INSERT INTO refresh_tokens (id, user_id, token, expires_at, created_at, updated_at)
-        VALUES ($1, $2, $3, $4, $5, $6)
+        INSERT INTO refresh_tokens (id, user_id, family_id, parent_id, token_hash, user_agent, ip_address, created_from, client_id, last_used_at, expires_at, created_at, updated_at)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
     `
-	result := s.DB.Exec(
+	result := db.Exec(
 		query,
 		refreshToken.ID,
 		refreshToken.UserID,
-		refreshToken.Token,
+		refreshToken.FamilyID,
+		refreshToken.ParentID,
+		refreshToken.TokenHash,
+		refreshToken.UserAgent,
+		refreshToken.IPAddress,
+		refreshToken.CreatedFrom,
+		refreshToken.ClientID,
+		refreshToken.LastUsedAt,
 		refreshToken.ExpiresAt,
 		refreshToken.CreatedAt,
 		refreshToken.UpdatedAt,
@@ -44,18 +90,72 @@ func (s *RefreshTokenStore) Create(refreshToken *models.RefreshToken) error {
 	return result.Error
 }
 
-// GetByToken retrieves a refresh token by its token string
+// Rotate marks the current refresh token as rotated and stores its successor
+// in the same family, which inherits how and at which client the session was
+// started.
+// ErrRefreshTokenRotated or ErrRefreshTokenRevoked is returned if the current
+// token was rotated or revoked in the meantime.
+func (s *RefreshTokenStore) Rotate(
+	current *models.RefreshToken,
+	next *models.RefreshToken,
+) error {
+	return s.DB.Transaction(
+		func(tx *gorm.DB) error {
+			now := time.Now()
+			query := `
+                UPDATE refresh_tokens
+                SET revoked_at = $1, rotated_at = $2, last_used_at = $3, updated_at = $4
+                WHERE id = $5 AND revoked_at IS NULL
+            `
+			result := tx.Exec(query, now, now, now, now, current.ID)
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				return rotationConflict(tx, current.ID)
+			}
+
+			next.FamilyID = current.FamilyID
+			next.ParentID = &current.ID
+			next.CreatedFrom = current.CreatedFrom
+			next.ClientID = current.ClientID
+			return s.create(tx, next)
+		},
+	)
+}
+
+// rotationConflict tells whether a refresh token that could not be rotated
+// was rotated or revoked by another request
+func rotationConflict(tx *gorm.DB, id uuid.UUID) error {
+	var refreshToken models.RefreshToken
+	query := `
+        SELECT rotated_at
+        FROM refresh_tokens
+        WHERE id = $1
+    `
+	result := tx.Raw(query, id).Scan(&refreshToken)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if refreshToken.IsRotated() {
+		return ErrRefreshTokenRotated
+	}
+	return ErrRefreshTokenRevoked
+}
+
+// GetByToken retrieves a refresh token by the hash of its token string
 func (s *RefreshTokenStore) GetByToken(token string) (
 	*models.RefreshToken,
 	error,
 ) {
 	var refreshToken models.RefreshToken
 	query := `
-        SELECT id, user_id, token, expires_at, created_at, updated_at, revoked_at
+        SELECT ` + refreshTokenColumns + `
         FROM refresh_tokens
-        WHERE token = $1
+        WHERE token_hash = $1
     `
-	result := s.DB.Raw(query, token).Scan(&refreshToken)
+	result := s.DB.Raw(query, s.TokenHasher.Hash(token)).Scan(&refreshToken)
 	if result.Error != nil {
 		return nil, result.Error
 	}
@@ -74,7 +174,7 @@ func (s *RefreshTokenStore) GetByUserID(userID uuid.UUID) (
 ) {
 	var refreshTokens []models.RefreshToken
 	query := `
-        SELECT id, user_id, token, expires_at, created_at, updated_at, revoked_at
+        SELECT ` + refreshTokenColumns + `
         FROM refresh_tokens
         WHERE user_id = $1 AND revoked_at IS NULL
         ORDER BY created_at DESC
@@ -87,6 +187,30 @@ func (s *RefreshTokenStore) GetByUserID(userID uuid.UUID) (
 	return refreshTokens, nil
 }
 
+// SessionStartedAt returns when the session with the refresh token family
+// was started by a login, which is nil if its first token no longer exists
+func (s *RefreshTokenStore) SessionStartedAt(
+	userID, familyID uuid.UUID,
+) (*time.Time, error) {
+	// The first token of a family carries the family ID
+	var refreshToken models.RefreshToken
+	query := `
+        SELECT created_at
+        FROM refresh_tokens
+        WHERE id = $1 AND user_id = $2
+    `
+	result := s.DB.Raw(query, familyID, userID).Scan(&refreshToken)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return nil, nil
+	}
+
+	return &refreshToken.CreatedAt, nil
+}
+
 // Revoke revokes a refresh token
 func (s *RefreshTokenStore) Revoke(id uuid.UUID) error {
 	now := time.Now()
@@ -99,18 +223,90 @@ func (s *RefreshTokenStore) Revoke(id uuid.UUID) error {
 	return result.Error
 }
 
-// RevokeAllForUser revokes all refresh tokens for a user
-func (s *RefreshTokenStore) RevokeAllForUser(userID uuid.UUID) error {
+// RevokeFamily revokes every refresh token descending from the same login,
+// along with the access tokens issued to it
+func (s *RefreshTokenStore) RevokeFamily(familyID uuid.UUID) error {
+	now := time.Now()
+	expiresAt := now.Add(s.RevokedTokenStore.MaxTokenAge)
+	err := s.DB.Transaction(
+		func(tx *gorm.DB) error {
+			query := `
+                UPDATE refresh_tokens
+                SET revoked_at = $1, updated_at = $2
+                WHERE family_id = $3 AND revoked_at IS NULL
+            `
+			result := tx.Exec(query, now, now, familyID)
+			if result.Error != nil {
+				return result.Error
+			}
+
+			query = `
+                INSERT INTO revoked_sessions (family_id, expires_at, created_at)
+                VALUES ($1, $2, $3)
+                ON CONFLICT (family_id) DO UPDATE SET expires_at = EXCLUDED.expires_at
+            `
+			result = tx.Exec(query, familyID, expiresAt, now)
+			return result.Error
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	// Take effect immediately on this instance
+	s.RevokedTokenStore.RevokeSession(familyID, expiresAt)
+	return nil
+}
+
+// RevokeAllForClient revokes every session started by signing in to the
+// OAuth client. Their access tokens are rejected once the client is revoked
+// in the RevokedTokenStore.
+func (s *RefreshTokenStore) RevokeAllForClient(clientID string) error {
 	now := time.Now()
 	query := `
         UPDATE refresh_tokens
         SET revoked_at = $1, updated_at = $2
-        WHERE user_id = $3 AND revoked_at IS NULL
+        WHERE client_id = $3 AND revoked_at IS NULL
     `
-	result := s.DB.Exec(query, now, now, userID)
+	result := s.DB.Exec(query, now, now, clientID)
 	return result.Error
 }
 
+// RevokeAllForUser revokes all refresh tokens for a user, along with every
+// access token issued to them so far
+func (s *RefreshTokenStore) RevokeAllForUser(userID uuid.UUID) error {
+	validAfter := tokensValidAfterNow()
+	err := s.DB.Transaction(
+		func(tx *gorm.DB) error {
+			now := time.Now()
+			query := `
+                UPDATE refresh_tokens
+                SET revoked_at = $1, updated_at = $2
+                WHERE user_id = $3 AND revoked_at IS NULL
+            `
+			result := tx.Exec(query, now, now, userID)
+			if result.Error != nil {
+				return result.Error
+			}
+
+			query = `
+                UPDATE users
+                SET tokens_valid_after = $1
+                WHERE id = $2
+            `
+			result = tx.Exec(query, validAfter, userID)
+			return result.Error
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	// Take effect immediately on this instance
+	s.RevokedTokenStore.RevokeUser(userID, validAfter)
+	return nil
+}
+
 // DeleteExpired deletes all expired refresh tokens
 func (s *RefreshTokenStore) DeleteExpired() error {
 	now := time.Now()
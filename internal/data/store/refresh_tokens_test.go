@@ -0,0 +1,119 @@
+package store
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/EngenMe/go-api-dod/internal/data/models"
+	"github.com/EngenMe/go-api-dod/internal/utils"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+)
+
+func TestRotateTellsRotatedFromRevokedTokens(t *testing.T) {
+	tests := []struct {
+		name      string
+		rotatedAt interface{}
+		want      error
+	}{
+		{name: "rotated by a concurrent refresh", rotatedAt: time.Now(), want: ErrRefreshTokenRotated},
+		{name: "revoked by a logout", rotatedAt: nil, want: ErrRefreshTokenRevoked},
+	}
+
+	for _, tt := range tests {
+		t.Run(
+			tt.name, func(t *testing.T) {
+				db, mock := newTestDB(t)
+				s := NewRefreshTokenStore(db, utils.NewTokenHasher("pepper"), nil)
+				current := &models.RefreshToken{ID: uuid.New(), FamilyID: uuid.New()}
+
+				mock.ExpectBegin()
+				mock.ExpectExec(`UPDATE refresh_tokens\s+SET revoked_at .*\s+WHERE id = \$5 AND revoked_at IS NULL`).
+					WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectQuery(`SELECT rotated_at\s+FROM refresh_tokens`).
+					WithArgs(current.ID).
+					WillReturnRows(sqlmock.NewRows([]string{"rotated_at"}).AddRow(tt.rotatedAt))
+				mock.ExpectRollback()
+
+				err := s.Rotate(current, &models.RefreshToken{Token: "next"})
+				if !errors.Is(err, tt.want) {
+					t.Errorf("Rotate() error = %v, want %v", err, tt.want)
+				}
+			},
+		)
+	}
+}
+
+func TestRotateLinksSuccessorToFamily(t *testing.T) {
+	db, mock := newTestDB(t)
+	s := NewRefreshTokenStore(db, utils.NewTokenHasher("pepper"), nil)
+	current := &models.RefreshToken{
+		ID:          uuid.New(),
+		FamilyID:    uuid.New(),
+		CreatedFrom: models.LoginMethodMagicLink,
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE refresh_tokens`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO refresh_tokens`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	next := &models.RefreshToken{Token: "next"}
+	if err := s.Rotate(current, next); err != nil {
+		t.Fatalf("Rotate() error = %v", err)
+	}
+	if next.FamilyID != current.FamilyID ||
+		next.ParentID == nil || *next.ParentID != current.ID ||
+		next.CreatedFrom != current.CreatedFrom {
+		t.Errorf("successor not linked to family: %+v", next)
+	}
+	if next.TokenHash == "" || next.TokenHash == next.Token {
+		t.Errorf("successor stored without hashing: %q", next.TokenHash)
+	}
+}
+
+func TestRevokeFamilyRevokesItsAccessTokens(t *testing.T) {
+	db, mock := newTestDB(t)
+	revokedTokenStore := NewRevokedTokenStore(db, time.Minute, 15*time.Minute)
+	s := NewRefreshTokenStore(db, utils.NewTokenHasher("pepper"), revokedTokenStore)
+	familyID := uuid.New()
+	claims := func(sessionID string) *utils.Claims {
+		return &utils.Claims{
+			UserID:    uuid.New(),
+			SessionID: sessionID,
+			TokenType: utils.AccessToken,
+		}
+	}
+
+	expectEmptyRevocations(mock)
+	if revoked, err := revokedTokenStore.IsRevoked(claims(familyID.String())); err != nil || revoked {
+		t.Fatalf("IsRevoked before revocation = %v, %v", revoked, err)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE refresh_tokens\s+SET revoked_at .*\s+WHERE family_id = \$3`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), familyID).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(`INSERT INTO revoked_sessions`).
+		WithArgs(familyID, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	if err := s.RevokeFamily(familyID); err != nil {
+		t.Fatalf("RevokeFamily: %v", err)
+	}
+
+	// Access tokens of the session die with it, those of others live on
+	for sessionID, want := range map[string]bool{
+		familyID.String():   true,
+		uuid.New().String(): false,
+	} {
+		if revoked, err := revokedTokenStore.IsRevoked(claims(sessionID)); err != nil || revoked != want {
+			t.Errorf("IsRevoked(sid %s) = %v, %v, want %v", sessionID, revoked, err, want)
+		}
+	}
+}
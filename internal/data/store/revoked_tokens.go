@@ -0,0 +1,253 @@
+package store
+
+import (
+	"sync"
+	"time"
+
+	"github.com/EngenMe/go-api-dod/internal/data/models"
+	"github.com/EngenMe/go-api-dod/internal/utils"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// RevokedTokenStore decides whether an access token was revoked before it
+// expired, either individually by its jti, along with its session, through
+// the tokens_valid_after watermark of its user or, for tokens of an OAuth
+// client, by revoking the client. All are cached in memory and reloaded from
+// the database once the cache is older than CacheTTL, so revocations made by
+// other instances take effect within CacheTTL.
+type RevokedTokenStore struct {
+	DB *gorm.DB
+	// CacheTTL is how long the in-memory copy of the denylist is trusted
+	CacheTTL time.Duration
+	// MaxTokenAge is the longest access token lifetime. Older watermarks cannot
+	// affect any valid access token and are not cached.
+	MaxTokenAge time.Duration
+
+	mu         sync.Mutex
+	loadedAt   time.Time
+	jtis       map[string]time.Time
+	sessions   map[string]time.Time // expires_at by refresh token family ID
+	watermarks map[uuid.UUID]time.Time
+	clients    map[string]time.Time // revoked_at by client ID
+}
+
+// NewRevokedTokenStore creates a new RevokedTokenStore
+func NewRevokedTokenStore(
+	db *gorm.DB,
+	cacheTTL, maxTokenAge time.Duration,
+) *RevokedTokenStore {
+	return &RevokedTokenStore{
+		DB:          db,
+		CacheTTL:    cacheTTL,
+		MaxTokenAge: maxTokenAge,
+		jtis:        make(map[string]time.Time),
+		sessions:    make(map[string]time.Time),
+		watermarks:  make(map[uuid.UUID]time.Time),
+		clients:     make(map[string]time.Time),
+	}
+}
+
+// Revoke adds the jti of an access token to the denylist until the token
+// expires
+func (s *RevokedTokenStore) Revoke(jti string, expiresAt time.Time) error {
+	_, err := s.Consume(jti, expiresAt)
+	return err
+}
+
+// Consume revokes the jti of a single-use token, reporting false if it was
+// already revoked. Of concurrent calls for the same jti exactly one succeeds.
+func (s *RevokedTokenStore) Consume(
+	jti string,
+	expiresAt time.Time,
+) (bool, error) {
+	query := `
+        INSERT INTO revoked_tokens (jti, expires_at, created_at)
+        VALUES ($1, $2, $3)
+        ON CONFLICT (jti) DO NOTHING
+    `
+	result := s.DB.Exec(query, jti, expiresAt, time.Now())
+	if result.Error != nil {
+		return false, result.Error
+	}
+
+	// Take effect immediately on this instance
+	s.mu.Lock()
+	s.jtis[jti] = expiresAt
+	s.mu.Unlock()
+
+	return result.RowsAffected == 1, nil
+}
+
+// RevokeSession makes the access tokens of a session rejected on this
+// instance until expiresAt. The revoked_sessions row other instances load is
+// written along with the revocation of its refresh tokens.
+func (s *RevokedTokenStore) RevokeSession(
+	familyID uuid.UUID,
+	expiresAt time.Time,
+) {
+	s.mu.Lock()
+	s.sessions[familyID.String()] = expiresAt
+	s.mu.Unlock()
+}
+
+// RevokeUser makes the access tokens of a user issued before validAfter
+// rejected on this instance. The tokens_valid_after column of the user is
+// the watermark other instances load.
+func (s *RevokedTokenStore) RevokeUser(userID uuid.UUID, validAfter time.Time) {
+	validAfter = validAfter.Truncate(time.Second)
+	s.mu.Lock()
+	if validAfter.After(s.watermarks[userID]) {
+		s.watermarks[userID] = validAfter
+	}
+	s.mu.Unlock()
+}
+
+// RevokeClient makes a client revoked at revokedAt reject the access tokens
+// it obtained earlier, for itself or for users signing in to it. The
+// revoked_at column of the client is the watermark other instances load.
+func (s *RevokedTokenStore) RevokeClient(clientID string, revokedAt time.Time) {
+	s.mu.Lock()
+	s.clients[clientID] = revokedAt.Truncate(time.Second)
+	s.mu.Unlock()
+}
+
+// IsRevoked reports whether the token with the claims has been revoked
+func (s *RevokedTokenStore) IsRevoked(claims *utils.Claims) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if time.Since(s.loadedAt) > s.CacheTTL {
+		if err := s.load(); err != nil {
+			return false, err
+		}
+	}
+
+	if claims.ID != "" {
+		if _, ok := s.jtis[claims.ID]; ok {
+			return true, nil
+		}
+	}
+
+	if claims.SessionID != "" {
+		if _, ok := s.sessions[claims.SessionID]; ok {
+			return true, nil
+		}
+	}
+
+	// Token issue times have whole-second precision, so watermarks are
+	// compared at the same granularity. Tokens issued within the second of a
+	// user watermark stay valid; those within the second a client was revoked
+	// do not.
+	issuedAt := claims.IssuedAtTime().Truncate(time.Second)
+	if claims.UserID != uuid.Nil {
+		if validAfter, ok := s.watermarks[claims.UserID]; ok &&
+			issuedAt.Before(validAfter) {
+			return true, nil
+		}
+	}
+	// Both the client's own tokens and those of users signed in to it
+	if client := claims.Client(); client != "" {
+		if revokedAt, ok := s.clients[client]; ok &&
+			!issuedAt.After(revokedAt) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// load replaces the cache with the unexpired revocations in the database.
+// The caller must hold s.mu.
+func (s *RevokedTokenStore) load() error {
+	now := time.Now()
+
+	var revokedTokens []models.RevokedToken
+	query := `
+        SELECT jti, expires_at, created_at
+        FROM revoked_tokens
+        WHERE expires_at > $1
+    `
+	result := s.DB.Raw(query, now).Scan(&revokedTokens)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	var revokedSessions []models.RevokedSession
+	query = `
+        SELECT family_id, expires_at, created_at
+        FROM revoked_sessions
+        WHERE expires_at > $1
+    `
+	result = s.DB.Raw(query, now).Scan(&revokedSessions)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	var users []models.User
+	query = `
+        SELECT id, tokens_valid_after
+        FROM users
+        WHERE tokens_valid_after > $1
+    `
+	result = s.DB.Raw(query, now.Add(-s.MaxTokenAge)).Scan(&users)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	var clients []models.OAuthClient
+	query = `
+        SELECT client_id, revoked_at
+        FROM oauth_clients
+        WHERE revoked_at > $1
+    `
+	result = s.DB.Raw(query, now.Add(-s.MaxTokenAge)).Scan(&clients)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	s.jtis = make(map[string]time.Time, len(revokedTokens))
+	for _, revokedToken := range revokedTokens {
+		s.jtis[revokedToken.JTI] = revokedToken.ExpiresAt
+	}
+	s.sessions = make(map[string]time.Time, len(revokedSessions))
+	for _, revokedSession := range revokedSessions {
+		s.sessions[revokedSession.FamilyID.String()] = revokedSession.ExpiresAt
+	}
+	s.watermarks = make(map[uuid.UUID]time.Time, len(users))
+	for _, user := range users {
+		s.watermarks[user.ID] = user.TokensValidAfter.Truncate(time.Second)
+	}
+	s.clients = make(map[string]time.Time, len(clients))
+	for _, client := range clients {
+		s.clients[client.ClientID] = client.RevokedAt.Truncate(time.Second)
+	}
+	s.loadedAt = now
+
+	return nil
+}
+
+// DeleteExpired deletes the denylist entries of expired tokens and sessions
+func (s *RevokedTokenStore) DeleteExpired() error {
+	now := time.Now()
+	query := `
+        DELETE FROM revoked_tokens
+        WHERE expires_at < $1
+    `
+	if err := s.DB.Exec(query, now).Error; err != nil {
+		return err
+	}
+
+	query = `
+        DELETE FROM revoked_sessions
+        WHERE expires_at < $1
+    `
+	return s.DB.Exec(query, now).Error
+}
+
+// tokensValidAfterNow returns the watermark that invalidates every token
+// issued so far. Token issue times have whole-second precision, so it is
+// truncated to keep tokens issued later within the same second valid.
+func tokensValidAfterNow() time.Time {
+	return time.Now().Truncate(time.Second)
+}
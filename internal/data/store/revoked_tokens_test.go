@@ -0,0 +1,99 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/EngenMe/go-api-dod/internal/utils"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// expectEmptyRevocations answers the cache reload with no revocations
+func expectEmptyRevocations(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery(`FROM revoked_tokens`).
+		WillReturnRows(sqlmock.NewRows([]string{"jti"}))
+	mock.ExpectQuery(`FROM revoked_sessions`).
+		WillReturnRows(sqlmock.NewRows([]string{"family_id"}))
+	mock.ExpectQuery(`FROM users`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectQuery(`FROM oauth_clients`).
+		WillReturnRows(sqlmock.NewRows([]string{"client_id"}))
+}
+
+// clientClaims returns the claims of a client token issued at issuedAt
+func clientClaims(clientID string, issuedAt time.Time) *utils.Claims {
+	return &utils.Claims{
+		ClientID:  clientID,
+		TokenType: utils.AccessToken,
+		RegisteredClaims: jwt.RegisteredClaims{
+			IssuedAt: jwt.NewNumericDate(issuedAt),
+		},
+	}
+}
+
+func TestRevokeClientRejectsItsEarlierTokens(t *testing.T) {
+	db, mock := newTestDB(t)
+	s := NewRevokedTokenStore(db, time.Minute, 15*time.Minute)
+	expectEmptyRevocations(mock)
+
+	issued := clientClaims("billing", time.Now())
+	revoked, err := s.IsRevoked(issued)
+	if err != nil || revoked {
+		t.Fatalf("IsRevoked before revocation = %v, %v", revoked, err)
+	}
+
+	s.RevokeClient("billing", time.Now())
+
+	if revoked, _ := s.IsRevoked(issued); !revoked {
+		t.Error("token issued before the client was revoked is still valid")
+	}
+	if revoked, _ := s.IsRevoked(clientClaims("other", time.Now())); revoked {
+		t.Error("token of another client was revoked")
+	}
+}
+
+func TestRevokeClientRejectsTokensOfItsUsers(t *testing.T) {
+	db, mock := newTestDB(t)
+	s := NewRevokedTokenStore(db, time.Minute, 15*time.Minute)
+	expectEmptyRevocations(mock)
+
+	signedIn := &utils.Claims{
+		UserID:          uuid.New(),
+		AuthorizedParty: "app",
+		TokenType:       utils.AccessToken,
+		RegisteredClaims: jwt.RegisteredClaims{
+			IssuedAt: jwt.NewNumericDate(time.Now()),
+		},
+	}
+	if revoked, err := s.IsRevoked(signedIn); err != nil || revoked {
+		t.Fatalf("IsRevoked before revocation = %v, %v", revoked, err)
+	}
+
+	s.RevokeClient("app", time.Now())
+
+	if revoked, _ := s.IsRevoked(signedIn); !revoked {
+		t.Error("token of a user signed in to the revoked client is still valid")
+	}
+}
+
+func TestRevokedClientsAreLoadedFromTheDatabase(t *testing.T) {
+	db, mock := newTestDB(t)
+	s := NewRevokedTokenStore(db, time.Minute, 15*time.Minute)
+	revokedAt := time.Now().Add(-time.Minute)
+	mock.ExpectQuery(`FROM revoked_tokens`).
+		WillReturnRows(sqlmock.NewRows([]string{"jti"}))
+	mock.ExpectQuery(`FROM revoked_sessions`).
+		WillReturnRows(sqlmock.NewRows([]string{"family_id"}))
+	mock.ExpectQuery(`FROM users`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectQuery(`FROM oauth_clients`).
+		WillReturnRows(sqlmock.NewRows([]string{"client_id", "revoked_at"}).
+			AddRow("billing", revokedAt))
+
+	if revoked, _ := s.IsRevoked(clientClaims("billing", revokedAt.Add(-time.Minute))); !revoked {
+		t.Error("token issued before the client was revoked is still valid")
+	}
+}
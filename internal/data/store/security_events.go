@@ -0,0 +1,45 @@
+package store
+
+import (
+	"time"
+
+	"github.com/EngenMe/go-api-dod/internal/data/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// SecurityEventStore provides methods to interact with the security_events table
+type SecurityEventStore struct {
+	DB *gorm.DB
+}
+
+// NewSecurityEventStore creates a new SecurityEventStore
+func NewSecurityEventStore(db *gorm.DB) *SecurityEventStore {
+	return &SecurityEventStore{
+		DB: db,
+	}
+}
+
+// Create records a new security event
+func (s *SecurityEventStore) Create(event *models.SecurityEvent) error {
+	if event.ID == uuid.Nil {
+		event.ID = uuid.New()
+	}
+	event.CreatedAt = time.Now()
+
+	query := `
+        INSERT INTO security_events (id, user_id, type, ip_address, user_agent, details, created_at)
+        VALUES ($1, $2, $3, $4, $5, $6, $7)
+    `
+	result := s.DB.Exec(
+		query,
+		event.ID,
+		event.UserID,
+		event.Type,
+		event.IPAddress,
+		event.UserAgent,
+		event.Details,
+		event.CreatedAt,
+	)
+	return result.Error
+}
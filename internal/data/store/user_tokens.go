@@ -0,0 +1,144 @@
+package store
+
+import (
+	"time"
+
+	"github.com/EngenMe/go-api-dod/internal/data/models"
+	"github.com/EngenMe/go-api-dod/internal/utils"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// UserTokenStore provides methods to interact with the user_tokens table.
+// Tokens are only ever persisted as keyed hashes.
+type UserTokenStore struct {
+	DB          *gorm.DB
+	TokenHasher *utils.TokenHasher
+}
+
+// NewUserTokenStore creates a new UserTokenStore
+func NewUserTokenStore(
+	db *gorm.DB,
+	tokenHasher *utils.TokenHasher,
+) *UserTokenStore {
+	return &UserTokenStore{
+		DB:          db,
+		TokenHasher: tokenHasher,
+	}
+}
+
+// Create creates a new user token
+func (s *UserTokenStore) Create(userToken *models.UserToken) error {
+	if userToken.ID == uuid.Nil {
+		userToken.ID = uuid.New()
+	}
+	userToken.TokenHash = s.TokenHasher.Hash(userToken.Token)
+	userToken.CreatedAt = time.Now()
+
+	query := `
+        INSERT INTO user_tokens (id, user_id, purpose, token_hash, email, expires_at, created_at)
+        VALUES ($1, $2, $3, $4, $5, $6, $7)
+    `
+	result := s.DB.Exec(
+		query,
+		userToken.ID,
+		userToken.UserID,
+		userToken.Purpose,
+		userToken.TokenHash,
+		userToken.Email,
+		userToken.ExpiresAt,
+		userToken.CreatedAt,
+	)
+	return result.Error
+}
+
+// GetValid retrieves an unused, unexpired token without consuming it.
+// It returns nil if no such token exists.
+func (s *UserTokenStore) GetValid(
+	token string,
+	purpose models.UserTokenPurpose,
+) (*models.UserToken, error) {
+	var userToken models.UserToken
+	query := `
+        SELECT id, user_id, purpose, token_hash, email, expires_at, used_at, created_at
+        FROM user_tokens
+        WHERE token_hash = $1
+          AND purpose = $2
+          AND used_at IS NULL
+          AND expires_at > $3
+    `
+	result := s.DB.Raw(
+		query,
+		s.TokenHasher.Hash(token),
+		purpose,
+		time.Now(),
+	).Scan(&userToken)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return nil, nil
+	}
+
+	return &userToken, nil
+}
+
+// Consume atomically marks an unused, unexpired token as used and returns it.
+// It returns nil if no such token exists.
+func (s *UserTokenStore) Consume(
+	token string,
+	purpose models.UserTokenPurpose,
+) (*models.UserToken, error) {
+	var userToken models.UserToken
+	now := time.Now()
+	query := `
+        UPDATE user_tokens
+        SET used_at = $1
+        WHERE token_hash = $2
+          AND purpose = $3
+          AND used_at IS NULL
+          AND expires_at > $4
+        RETURNING id, user_id, purpose, token_hash, email, expires_at, used_at, created_at
+    `
+	result := s.DB.Raw(
+		query,
+		now,
+		s.TokenHasher.Hash(token),
+		purpose,
+		now,
+	).Scan(&userToken)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return nil, nil
+	}
+
+	return &userToken, nil
+}
+
+// InvalidateForUser marks every outstanding token of a purpose as used
+func (s *UserTokenStore) InvalidateForUser(
+	userID uuid.UUID,
+	purpose models.UserTokenPurpose,
+) error {
+	query := `
+        UPDATE user_tokens
+        SET used_at = $1
+        WHERE user_id = $2 AND purpose = $3 AND used_at IS NULL
+    `
+	result := s.DB.Exec(query, time.Now(), userID, purpose)
+	return result.Error
+}
+
+// DeleteExpired deletes all expired user tokens
+func (s *UserTokenStore) DeleteExpired() error {
+	query := `
+        DELETE FROM user_tokens
+        WHERE expires_at < $1
+    `
+	result := s.DB.Exec(query, time.Now())
+	return result.Error
+}
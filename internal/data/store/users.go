@@ -4,19 +4,36 @@ import (
 	"time"
 
 	"github.com/EngenMe/go-api-dod/internal/data/models"
+	"github.com/EngenMe/go-api-dod/internal/utils"
 	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
-// UserStore provides methods to interact with the user's table
+// userColumns lists the users columns scanned into models.User
+const userColumns = `id, email, password, role, email_verified_at,
+        mfa_secret, mfa_enabled_at, mfa_last_counter,
+        failed_login_attempts, locked_until, tokens_valid_after,
+        created_at, updated_at, deleted_at`
+
+// UserStore provides methods to interact with the user's table. TOTP
+// secrets are encrypted with the SecretBox before they are stored. Deleting
+// a user also revokes their access tokens through the RevokedTokenStore.
 type UserStore struct {
-	DB *gorm.DB
+	DB                *gorm.DB
+	SecretBox         *utils.SecretBox
+	RevokedTokenStore *RevokedTokenStore
 }
 
 // NewUserStore creates a new UserStore
-func NewUserStore(db *gorm.DB) *UserStore {
+func NewUserStore(
+	db *gorm.DB,
+	secretBox *utils.SecretBox,
+	revokedTokenStore *RevokedTokenStore,
+) *UserStore {
 	return &UserStore{
-		DB: db,
+		DB:                db,
+		SecretBox:         secretBox,
+		RevokedTokenStore: revokedTokenStore,
 	}
 }
 
@@ -25,18 +42,22 @@ func (s *UserStore) Create(user *models.User) error {
 	if user.ID == uuid.Nil {
 		user.ID = uuid.New()
 	}
+	if user.Role == "" {
+		user.Role = models.RoleUser
+	}
 	user.CreatedAt = time.Now()
 	user.UpdatedAt = time.Now()
 
 	query := `
-        INSERT INTO users (id, email, password, created_at, updated_at)
-        VALUES ($1, $2, $3, $4, $5)
+        INSERT INTO users (id, email, password, role, created_at, updated_at)
+        VALUES ($1, $2, $3, $4, $5, $6)
     `
 	result := s.DB.Exec(
 		query,
 		user.ID,
 		user.Email,
 		user.Password,
+		user.Role,
 		user.CreatedAt,
 		user.UpdatedAt,
 	)
@@ -47,7 +68,7 @@ func (s *UserStore) Create(user *models.User) error {
 func (s *UserStore) GetByID(id uuid.UUID) (*models.User, error) {
 	var user models.User
 	query := `
-        SELECT id, email, password, created_at, updated_at, deleted_at
+        SELECT ` + userColumns + `
         FROM users
         WHERE id = $1 AND deleted_at IS NULL
     `
@@ -67,7 +88,7 @@ func (s *UserStore) GetByID(id uuid.UUID) (*models.User, error) {
 func (s *UserStore) GetByEmail(email string) (*models.User, error) {
 	var user models.User
 	query := `
-        SELECT id, email, password, created_at, updated_at, deleted_at
+        SELECT ` + userColumns + `
         FROM users
         WHERE email = $1 AND deleted_at IS NULL
     `
@@ -87,7 +108,7 @@ func (s *UserStore) GetByEmail(email string) (*models.User, error) {
 func (s *UserStore) List(limit, offset int) ([]models.User, error) {
 	var users []models.User
 	query := `
-        SELECT id, email, password, created_at, updated_at, deleted_at
+        SELECT ` + userColumns + `
         FROM users
         WHERE deleted_at IS NULL
         ORDER BY created_at DESC
@@ -101,34 +122,238 @@ func (s *UserStore) List(limit, offset int) ([]models.User, error) {
 	return users, nil
 }
 
-// Update updates a user
+// Update updates the profile of a user. Passwords are changed through
+// UpdatePassword only. A changed email loses its verification, and the
+// tokens emailed to the old address are deleted.
 func (s *UserStore) Update(user *models.User) error {
 	user.UpdatedAt = time.Now()
+	return s.DB.Transaction(
+		func(tx *gorm.DB) error {
+			// Login links, reset and verification tokens were sent to the
+			// old address and must not outlive it
+			query := `
+                DELETE FROM user_tokens
+                WHERE user_id = $1
+                  AND used_at IS NULL
+                  AND EXISTS (
+                      SELECT 1 FROM users WHERE id = $1 AND email <> $2
+                  )
+            `
+			if err := tx.Exec(query, user.ID, user.Email).Error; err != nil {
+				return err
+			}
+
+			query = `
+                UPDATE users
+                SET email_verified_at = CASE WHEN email = $1 THEN email_verified_at END,
+                    email = $1,
+                    updated_at = $2
+                WHERE id = $3 AND deleted_at IS NULL
+            `
+			return tx.Exec(
+				query,
+				user.Email,
+				user.UpdatedAt,
+				user.ID,
+			).Error
+		},
+	)
+}
+
+// UpdatePassword replaces the password hash of a user
+func (s *UserStore) UpdatePassword(id uuid.UUID, hashedPassword string) error {
 	query := `
         UPDATE users
-        SET email = $1,
-            password = $2,
-            updated_at = $3
-        WHERE id = $4 AND deleted_at IS NULL
+        SET password = $1,
+            updated_at = $2
+        WHERE id = $3 AND deleted_at IS NULL
     `
-	result := s.DB.Exec(
-		query,
-		user.Email,
-		user.Password,
-		user.UpdatedAt,
-		user.ID,
-	)
+	result := s.DB.Exec(query, hashedPassword, time.Now(), id)
+	return result.Error
+}
 
+// SetRole changes the role of a user
+func (s *UserStore) SetRole(id uuid.UUID, role models.Role) error {
+	query := `
+        UPDATE users
+        SET role = $1,
+            updated_at = $2
+        WHERE id = $3 AND deleted_at IS NULL
+    `
+	result := s.DB.Exec(query, role, time.Now(), id)
 	return result.Error
 }
 
-// Delete deletes a user
+// SetRoleByEmail changes the role of the user with the given email
+func (s *UserStore) SetRoleByEmail(email string, role models.Role) error {
+	query := `
+        UPDATE users
+        SET role = $1,
+            updated_at = $2
+        WHERE email = $3 AND deleted_at IS NULL
+    `
+	result := s.DB.Exec(query, role, time.Now(), email)
+	return result.Error
+}
+
+// Delete deletes a user and, in the same transaction, revokes their refresh
+// tokens and every access token issued to them so far
 func (s *UserStore) Delete(id uuid.UUID) error {
+	validAfter := tokensValidAfterNow()
+	err := s.DB.Transaction(
+		func(tx *gorm.DB) error {
+			now := time.Now()
+			query := `
+                UPDATE users
+                SET deleted_at = $1,
+                    tokens_valid_after = $2
+                WHERE id = $3 AND deleted_at IS NULL
+            `
+			result := tx.Exec(query, now, validAfter, id)
+			if result.Error != nil {
+				return result.Error
+			}
+
+			query = `
+                UPDATE refresh_tokens
+                SET revoked_at = $1, updated_at = $2
+                WHERE user_id = $3 AND revoked_at IS NULL
+            `
+			result = tx.Exec(query, now, now, id)
+			return result.Error
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	// Take effect immediately on this instance
+	s.RevokedTokenStore.RevokeUser(id, validAfter)
+	return nil
+}
+
+// MarkEmailVerified records that the user confirmed their email address
+func (s *UserStore) MarkEmailVerified(id uuid.UUID) error {
+	now := time.Now()
+	query := `
+        UPDATE users
+        SET email_verified_at = $1,
+            updated_at = $2
+        WHERE id = $3 AND deleted_at IS NULL AND email_verified_at IS NULL
+    `
+	result := s.DB.Exec(query, now, now, id)
+	return result.Error
+}
+
+// SetMFASecret stores a pending TOTP secret and leaves MFA disabled until the
+// enrollment is confirmed
+func (s *UserStore) SetMFASecret(id uuid.UUID, secret string) error {
+	sealed, err := s.SecretBox.Seal(secret, id.String())
+	if err != nil {
+		return err
+	}
+
+	query := `
+        UPDATE users
+        SET mfa_secret = $1,
+            mfa_enabled_at = NULL,
+            mfa_last_counter = 0,
+            updated_at = $2
+        WHERE id = $3 AND deleted_at IS NULL
+    `
+	result := s.DB.Exec(query, sealed, time.Now(), id)
+	return result.Error
+}
+
+// MFASecret decrypts the stored TOTP secret of a user
+func (s *UserStore) MFASecret(user *models.User) (string, error) {
+	return s.SecretBox.Open(user.MFASecret, user.ID.String())
+}
+
+// EnableMFA marks the pending TOTP secret as confirmed
+func (s *UserStore) EnableMFA(id uuid.UUID, counter int64) error {
+	now := time.Now()
 	query := `
         UPDATE users
-        SET deleted_at = $1
+        SET mfa_enabled_at = $1,
+            mfa_last_counter = $2,
+            updated_at = $3
+        WHERE id = $4 AND deleted_at IS NULL AND mfa_secret <> ''
+    `
+	result := s.DB.Exec(query, now, counter, now, id)
+	return result.Error
+}
+
+// DisableMFA removes the TOTP secret of a user
+func (s *UserStore) DisableMFA(id uuid.UUID) error {
+	query := `
+        UPDATE users
+        SET mfa_secret = '',
+            mfa_enabled_at = NULL,
+            mfa_last_counter = 0,
+            updated_at = $1
         WHERE id = $2 AND deleted_at IS NULL
     `
 	result := s.DB.Exec(query, time.Now(), id)
 	return result.Error
 }
+
+// AdvanceMFACounter records the time step of an accepted TOTP code. It reports
+// false if an equal or later step was already used, i.e. the code is replayed.
+func (s *UserStore) AdvanceMFACounter(id uuid.UUID, counter int64) (
+	bool,
+	error,
+) {
+	query := `
+        UPDATE users
+        SET mfa_last_counter = $1
+        WHERE id = $2 AND mfa_last_counter < $3
+    `
+	result := s.DB.Exec(query, counter, id, counter)
+	if result.Error != nil {
+		return false, result.Error
+	}
+
+	return result.RowsAffected > 0, nil
+}
+
+// RecordFailedLogin increments the failed login counter of a user and returns
+// the new count
+func (s *UserStore) RecordFailedLogin(id uuid.UUID) (int, error) {
+	var attempts int
+	query := `
+        UPDATE users
+        SET failed_login_attempts = failed_login_attempts + 1
+        WHERE id = $1
+        RETURNING failed_login_attempts
+    `
+	result := s.DB.Raw(query, id).Scan(&attempts)
+	if result.Error != nil {
+		return 0, result.Error
+	}
+
+	return attempts, nil
+}
+
+// Lock locks a user out of logging in until the given time
+func (s *UserStore) Lock(id uuid.UUID, until time.Time) error {
+	query := `
+        UPDATE users
+        SET locked_until = $1
+        WHERE id = $2
+    `
+	result := s.DB.Exec(query, until, id)
+	return result.Error
+}
+
+// ResetFailedLogins clears the failed login counter and any lock of a user
+func (s *UserStore) ResetFailedLogins(id uuid.UUID) error {
+	query := `
+        UPDATE users
+        SET failed_login_attempts = 0,
+            locked_until = NULL
+        WHERE id = $1
+    `
+	result := s.DB.Exec(query, id)
+	return result.Error
+}
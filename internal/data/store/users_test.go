@@ -0,0 +1,78 @@
+package store
+
+import (
+	"database/sql/driver"
+	"strings"
+	"testing"
+
+	"github.com/EngenMe/go-api-dod/internal/data/models"
+	"github.com/EngenMe/go-api-dod/internal/utils"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+)
+
+// capturedArg matches any argument and remembers it
+type capturedArg struct {
+	value driver.Value
+}
+
+func (a *capturedArg) Match(v driver.Value) bool {
+	a.value = v
+	return true
+}
+
+func TestMFASecretIsEncryptedAtRest(t *testing.T) {
+	db, mock := newTestDB(t)
+	secretBox, err := utils.NewSecretBox("test")
+	if err != nil {
+		t.Fatalf("failed to create secret box: %v", err)
+	}
+	s := NewUserStore(db, secretBox, nil)
+	user := &models.User{ID: uuid.New()}
+
+	stored := &capturedArg{}
+	mock.ExpectExec(`UPDATE users\s+SET mfa_secret = \$1`).
+		WithArgs(stored, sqlmock.AnyArg(), user.ID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if err := s.SetMFASecret(user.ID, "JBSWY3DPEHPK3PXP"); err != nil {
+		t.Fatalf("SetMFASecret: %v", err)
+	}
+
+	user.MFASecret, _ = stored.value.(string)
+	if strings.Contains(user.MFASecret, "JBSWY3DPEHPK3PXP") {
+		t.Fatalf("stored secret %q is plaintext", user.MFASecret)
+	}
+
+	secret, err := s.MFASecret(user)
+	if err != nil || secret != "JBSWY3DPEHPK3PXP" {
+		t.Errorf("MFASecret = %q, %v", secret, err)
+	}
+
+	// A sealed secret copied to another user does not decrypt
+	other := &models.User{ID: uuid.New(), MFASecret: user.MFASecret}
+	if _, err := s.MFASecret(other); err == nil {
+		t.Error("secret of another user was decrypted")
+	}
+}
+
+func TestUpdateDeletesTokensSentToTheOldAddress(t *testing.T) {
+	db, mock := newTestDB(t)
+	s := NewUserStore(db, nil, nil)
+	user := &models.User{ID: uuid.New(), Email: "new@example.com"}
+
+	// The delete only matches while the stored email differs
+	mock.ExpectBegin()
+	mock.ExpectExec(`DELETE FROM user_tokens\s+WHERE user_id = \$1\s+AND used_at IS NULL\s+AND EXISTS \(\s*SELECT 1 FROM users WHERE id = \$1 AND email <> \$2`).
+		WithArgs(user.ID, "new@example.com").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(`UPDATE users\s+SET email_verified_at`).
+		WithArgs("new@example.com", sqlmock.AnyArg(), user.ID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	if err := s.Update(user); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+}
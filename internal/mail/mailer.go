@@ -0,0 +1,106 @@
+package mail
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/EngenMe/go-api-dod/config"
+)
+
+// Message represents an email message
+type Message struct {
+	From    string
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends email messages
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// NewMailer creates the Mailer selected by the configuration
+func NewMailer(cfg config.MailConfig) (Mailer, error) {
+	switch cfg.Driver {
+	case "log":
+		return NewLogMailer(cfg.From), nil
+	case "file":
+		return NewFileMailer(cfg.From, cfg.FilePath), nil
+	default:
+		return nil, fmt.Errorf("unsupported mail driver %q", cfg.Driver)
+	}
+}
+
+// LogMailer writes messages to the application log, for development
+type LogMailer struct {
+	From   string
+	Logger *log.Logger
+}
+
+// NewLogMailer creates a new LogMailer
+func NewLogMailer(from string) *LogMailer {
+	return &LogMailer{
+		From:   from,
+		Logger: log.New(os.Stdout, "[MAIL] ", log.LstdFlags),
+	}
+}
+
+// Send logs the message
+func (m *LogMailer) Send(msg Message) error {
+	if msg.From == "" {
+		msg.From = m.From
+	}
+
+	m.Logger.Printf(
+		"from=%s to=%s subject=%q\n%s",
+		msg.From, msg.To, msg.Subject, msg.Body,
+	)
+	return nil
+}
+
+// FileMailer appends messages to a file, for development and testing
+type FileMailer struct {
+	From string
+	Path string
+	mu   sync.Mutex
+}
+
+// NewFileMailer creates a new FileMailer
+func NewFileMailer(from, path string) *FileMailer {
+	return &FileMailer{
+		From: from,
+		Path: path,
+	}
+}
+
+// Send appends the message to the file
+func (m *FileMailer) Send(msg Message) error {
+	if msg.From == "" {
+		msg.From = m.From
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	file, err := os.OpenFile(
+		m.Path,
+		os.O_APPEND|os.O_CREATE|os.O_WRONLY,
+		0o600,
+	)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = fmt.Fprintf(
+		file,
+		"Date: %s\nFrom: %s\nTo: %s\nSubject: %s\n\n%s\n\n",
+		time.Now().Format(time.RFC1123Z),
+		msg.From, msg.To, msg.Subject, msg.Body,
+	)
+	return err
+}
@@ -1,23 +1,73 @@
 package utils
 
 import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/bcrypt"
 )
 
-// PasswordHasher provides methods for hashing and checking passwords
+// Supported password hashing algorithms
+const (
+	AlgorithmArgon2id = "argon2id"
+	AlgorithmBcrypt   = "bcrypt"
+)
+
+// BcryptMaxPasswordBytes is the longest password bcrypt accepts
+const BcryptMaxPasswordBytes = 72
+
+// Argon2Params holds the argon2id cost parameters
+type Argon2Params struct {
+	Memory      uint32 // KiB
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// PasswordHasher provides methods for hashing and checking passwords. New
+// hashes use the configured algorithm, while hashes of every supported
+// algorithm can be checked.
 type PasswordHasher struct {
-	Cost int
+	Algorithm string
+	Cost      int // bcrypt cost
+	Argon2    Argon2Params
 }
 
 // NewPasswordHasher creates a new PasswordHasher
-func NewPasswordHasher(cost int) *PasswordHasher {
+func NewPasswordHasher(
+	algorithm string,
+	cost int,
+	argon2Params Argon2Params,
+) *PasswordHasher {
+	if argon2Params.SaltLength == 0 {
+		argon2Params.SaltLength = 16
+	}
+	if argon2Params.KeyLength == 0 {
+		argon2Params.KeyLength = 32
+	}
+
 	return &PasswordHasher{
-		Cost: cost,
+		Algorithm: algorithm,
+		Cost:      cost,
+		Argon2:    argon2Params,
 	}
 }
 
 // Hash hashes a password
 func (h *PasswordHasher) Hash(password string) (string, error) {
+	if h.Algorithm == AlgorithmArgon2id {
+		return h.hashArgon2id(password)
+	}
+
 	hashedBytes, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
 	if err != nil {
 		return "", err
@@ -27,6 +77,142 @@ func (h *PasswordHasher) Hash(password string) (string, error) {
 
 // Check checks if a password matches a hash
 func (h *PasswordHasher) Check(password, hash string) bool {
+	if strings.HasPrefix(hash, "$argon2id$") {
+		params, salt, key, err := decodeArgon2id(hash)
+		if err != nil {
+			return false
+		}
+
+		derived := argon2.IDKey(
+			[]byte(password),
+			salt,
+			params.Iterations,
+			params.Memory,
+			params.Parallelism,
+			params.KeyLength,
+		)
+		return subtle.ConstantTimeCompare(derived, key) == 1
+	}
+
 	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
 	return err == nil
 }
+
+// NeedsRehash reports whether a hash was made with another algorithm or
+// other cost parameters than the configured ones
+func (h *PasswordHasher) NeedsRehash(hash string) bool {
+	if strings.HasPrefix(hash, "$argon2id$") {
+		if h.Algorithm != AlgorithmArgon2id {
+			return true
+		}
+
+		params, _, _, err := decodeArgon2id(hash)
+		return err != nil ||
+			params.Memory != h.Argon2.Memory ||
+			params.Iterations != h.Argon2.Iterations ||
+			params.Parallelism != h.Argon2.Parallelism ||
+			params.KeyLength != h.Argon2.KeyLength
+	}
+
+	if h.Algorithm != AlgorithmBcrypt {
+		return true
+	}
+
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost != h.Cost
+}
+
+// hashArgon2id hashes a password with argon2id in PHC string format
+func (h *PasswordHasher) hashArgon2id(password string) (string, error) {
+	salt := make([]byte, h.Argon2.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey(
+		[]byte(password),
+		salt,
+		h.Argon2.Iterations,
+		h.Argon2.Memory,
+		h.Argon2.Parallelism,
+		h.Argon2.KeyLength,
+	)
+
+	return fmt.Sprintf(
+		"$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version,
+		h.Argon2.Memory,
+		h.Argon2.Iterations,
+		h.Argon2.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// decodeArgon2id parses an argon2id PHC string
+func decodeArgon2id(hash string) (Argon2Params, []byte, []byte, error) {
+	var params Argon2Params
+
+	// "", "argon2id", "v=19", "m=...,t=...,p=...", salt, key
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return params, nil, nil, errors.New("invalid argon2id hash")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return params, nil, nil, err
+	}
+	if version != argon2.Version {
+		return params, nil, nil, errors.New("unsupported argon2 version")
+	}
+
+	_, err := fmt.Sscanf(
+		parts[3],
+		"m=%d,t=%d,p=%d",
+		&params.Memory,
+		&params.Iterations,
+		&params.Parallelism,
+	)
+	if err != nil {
+		return params, nil, nil, err
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, err
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return params, nil, nil, err
+	}
+	params.SaltLength = uint32(len(salt))
+	params.KeyLength = uint32(len(key))
+
+	return params, salt, key, nil
+}
+
+// TokenHasher derives keyed hashes of opaque tokens so that only the hash
+// has to be persisted
+type TokenHasher struct {
+	Pepper []byte
+}
+
+// NewTokenHasher creates a new TokenHasher
+func NewTokenHasher(pepper string) *TokenHasher {
+	return &TokenHasher{
+		Pepper: []byte(pepper),
+	}
+}
+
+// Hash returns the hex encoded HMAC-SHA256 of a token
+func (h *TokenHasher) Hash(token string) string {
+	mac := hmac.New(sha256.New, h.Pepper)
+	mac.Write([]byte(token))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Check reports whether the token matches a hash in constant time
+func (h *TokenHasher) Check(token, hash string) bool {
+	return hmac.Equal([]byte(h.Hash(token)), []byte(hash))
+}
@@ -0,0 +1,307 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Supported JWT signing algorithms
+const (
+	AlgorithmHS256 = "HS256"
+	AlgorithmRS256 = "RS256"
+	AlgorithmES256 = "ES256"
+	AlgorithmEdDSA = "EdDSA"
+)
+
+// SigningKey is a key used to sign and verify JWTs
+type SigningKey struct {
+	ID        string
+	Method    jwt.SigningMethod
+	SignKey   interface{} // nil for verification-only keys
+	VerifyKey interface{}
+	Symmetric bool
+}
+
+// JWK represents a public key in JSON Web Key format (RFC 7517)
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKSet represents a JSON Web Key Set
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// NewHMACSigningKey creates an HS256 SigningKey from a shared secret
+func NewHMACSigningKey(id, secret string) (*SigningKey, error) {
+	if secret == "" {
+		return nil, errors.New("HS256 requires a secret")
+	}
+	if id == "" {
+		id = "default"
+	}
+
+	return &SigningKey{
+		ID:        id,
+		Method:    jwt.SigningMethodHS256,
+		SignKey:   []byte(secret),
+		VerifyKey: []byte(secret),
+		Symmetric: true,
+	}, nil
+}
+
+// LoadSigningKey loads an asymmetric SigningKey from a PEM file. The file may
+// hold a private key, or only a public key for a verification-only key. When
+// id is empty, the RFC 7638 thumbprint of the public key is used.
+func LoadSigningKey(id, algorithm, path string) (*SigningKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+
+	return ParseSigningKey(id, algorithm, data)
+}
+
+// ParseSigningKey parses an asymmetric SigningKey from PEM encoded data
+func ParseSigningKey(id, algorithm string, data []byte) (*SigningKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+
+	var signKey, verifyKey interface{}
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		signKey, verifyKey = key, publicKeyOf(key)
+	case "RSA PRIVATE KEY":
+		key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		signKey, verifyKey = key, &key.PublicKey
+	case "EC PRIVATE KEY":
+		key, err := x509.ParseECPrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		signKey, verifyKey = key, &key.PublicKey
+	case "PUBLIC KEY":
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		verifyKey = key
+	default:
+		return nil, fmt.Errorf("unsupported PEM block type %q", block.Type)
+	}
+
+	return newSigningKey(id, algorithm, signKey, verifyKey)
+}
+
+// publicKeyOf returns the public half of a private key
+func publicKeyOf(privateKey interface{}) interface{} {
+	if signer, ok := privateKey.(crypto.Signer); ok {
+		return signer.Public()
+	}
+	return nil
+}
+
+// newSigningKey checks that the keys match the algorithm and builds a SigningKey
+func newSigningKey(
+	id, algorithm string,
+	signKey, verifyKey interface{},
+) (*SigningKey, error) {
+	var method jwt.SigningMethod
+	switch algorithm {
+	case AlgorithmRS256:
+		if _, ok := verifyKey.(*rsa.PublicKey); !ok {
+			return nil, errors.New("RS256 requires an RSA key")
+		}
+		method = jwt.SigningMethodRS256
+	case AlgorithmES256:
+		key, ok := verifyKey.(*ecdsa.PublicKey)
+		if !ok || key.Curve != elliptic.P256() {
+			return nil, errors.New("ES256 requires a P-256 ECDSA key")
+		}
+		method = jwt.SigningMethodES256
+	case AlgorithmEdDSA:
+		if _, ok := verifyKey.(ed25519.PublicKey); !ok {
+			return nil, errors.New("EdDSA requires an Ed25519 key")
+		}
+		method = jwt.SigningMethodEdDSA
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm %q", algorithm)
+	}
+
+	key := &SigningKey{
+		ID:        id,
+		Method:    method,
+		SignKey:   signKey,
+		VerifyKey: verifyKey,
+	}
+	if key.ID == "" {
+		thumbprint, err := key.Thumbprint()
+		if err != nil {
+			return nil, err
+		}
+		key.ID = thumbprint
+	}
+
+	return key, nil
+}
+
+// CanSign reports whether the key holds private material
+func (k *SigningKey) CanSign() bool {
+	return k.SignKey != nil
+}
+
+// JWK returns the public key in JWK format. Symmetric keys are never exported.
+func (k *SigningKey) JWK() (JWK, bool) {
+	if k.Symmetric {
+		return JWK{}, false
+	}
+
+	jwk := JWK{
+		Kid: k.ID,
+		Use: "sig",
+		Alg: k.Method.Alg(),
+	}
+	switch key := k.VerifyKey.(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = encodeSegment(key.N.Bytes())
+		jwk.E = encodeSegment(big.NewInt(int64(key.E)).Bytes())
+	case *ecdsa.PublicKey:
+		size := (key.Curve.Params().BitSize + 7) / 8
+		jwk.Kty = "EC"
+		jwk.Crv = key.Curve.Params().Name
+		jwk.X = encodeSegment(key.X.FillBytes(make([]byte, size)))
+		jwk.Y = encodeSegment(key.Y.FillBytes(make([]byte, size)))
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = encodeSegment(key)
+	default:
+		return JWK{}, false
+	}
+
+	return jwk, true
+}
+
+// Thumbprint returns the RFC 7638 SHA-256 thumbprint of the public key
+func (k *SigningKey) Thumbprint() (string, error) {
+	jwk, ok := k.JWK()
+	if !ok {
+		return "", errors.New("thumbprints require an asymmetric key")
+	}
+
+	// Only the required members, in lexicographic order
+	var members interface{}
+	switch jwk.Kty {
+	case "RSA":
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{jwk.E, jwk.Kty, jwk.N}
+	case "EC":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		}{jwk.Crv, jwk.Kty, jwk.X, jwk.Y}
+	default:
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{jwk.Crv, jwk.Kty, jwk.X}
+	}
+
+	data, err := json.Marshal(members)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return encodeSegment(sum[:]), nil
+}
+
+// encodeSegment encodes bytes as unpadded base64url
+func encodeSegment(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// Keyring holds the active signing key together with verification-only keys,
+// such as retired keys whose tokens have not expired yet or upcoming keys that
+// should be published before they become active
+type Keyring struct {
+	Active *SigningKey
+	keys   map[string]*SigningKey
+	order  []string
+}
+
+// NewKeyring creates a new Keyring
+func NewKeyring(
+	active *SigningKey,
+	verificationKeys ...*SigningKey,
+) (*Keyring, error) {
+	if !active.CanSign() {
+		return nil, errors.New("active signing key has no private key")
+	}
+
+	keyring := &Keyring{
+		Active: active,
+		keys:   make(map[string]*SigningKey),
+	}
+	for _, key := range append([]*SigningKey{active}, verificationKeys...) {
+		if _, exists := keyring.keys[key.ID]; exists {
+			return nil, fmt.Errorf("duplicate key ID %q", key.ID)
+		}
+		keyring.keys[key.ID] = key
+		keyring.order = append(keyring.order, key.ID)
+	}
+
+	return keyring, nil
+}
+
+// Get returns the key with the given ID
+func (k *Keyring) Get(id string) (*SigningKey, bool) {
+	key, ok := k.keys[id]
+	return key, ok
+}
+
+// Keys returns all keys, the active key first
+func (k *Keyring) Keys() []*SigningKey {
+	keys := make([]*SigningKey, 0, len(k.order))
+	for _, id := range k.order {
+		keys = append(keys, k.keys[id])
+	}
+	return keys
+}
@@ -0,0 +1,214 @@
+package utils
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Password policy violation codes
+const (
+	PasswordTooShort       = "too_short"
+	PasswordTooLong        = "too_long"
+	PasswordMissingClasses = "missing_character_classes"
+	PasswordContainsEmail  = "contains_email"
+	PasswordBreached       = "breached"
+)
+
+// minEmailLocalPart is the shortest email local part worth checking for, so
+// that addresses like "jo@example.com" don't ban every password with "jo"
+const minEmailLocalPart = 3
+
+// PasswordViolation describes one reason a password was rejected
+type PasswordViolation struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// PasswordPolicy validates new passwords
+type PasswordPolicy struct {
+	MinLength            int
+	MaxLength            int
+	MaxBytes             int // limit of the password hash, 0 if none
+	RequiredClasses      int // of lower, upper, digit and symbol; 0 disables
+	ForbidEmailLocalPart bool
+	Breached             *BreachedPasswords // nil disables the check
+}
+
+// Validate returns every policy violation of a password set for the account
+// with the given email
+func (p *PasswordPolicy) Validate(password, email string) []PasswordViolation {
+	var violations []PasswordViolation
+
+	length := utf8.RuneCountInString(password)
+	if length < p.MinLength {
+		violations = append(
+			violations, PasswordViolation{
+				Code: PasswordTooShort,
+				Message: fmt.Sprintf(
+					"Password must be at least %d characters long",
+					p.MinLength,
+				),
+			},
+		)
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		violations = append(
+			violations, PasswordViolation{
+				Code: PasswordTooLong,
+				Message: fmt.Sprintf(
+					"Password must be at most %d characters long",
+					p.MaxLength,
+				),
+			},
+		)
+	} else if p.MaxBytes > 0 && len(password) > p.MaxBytes {
+		violations = append(
+			violations, PasswordViolation{
+				Code: PasswordTooLong,
+				Message: fmt.Sprintf(
+					"Password must be at most %d bytes long",
+					p.MaxBytes,
+				),
+			},
+		)
+	}
+
+	if p.RequiredClasses > 0 && characterClasses(password) < p.RequiredClasses {
+		violations = append(
+			violations, PasswordViolation{
+				Code: PasswordMissingClasses,
+				Message: fmt.Sprintf(
+					"Password must contain at least %d of: lowercase letters, uppercase letters, digits, symbols",
+					p.RequiredClasses,
+				),
+			},
+		)
+	}
+
+	if p.ForbidEmailLocalPart {
+		localPart, _, _ := strings.Cut(strings.ToLower(email), "@")
+		if len(localPart) >= minEmailLocalPart &&
+			strings.Contains(strings.ToLower(password), localPart) {
+			violations = append(
+				violations, PasswordViolation{
+					Code:    PasswordContainsEmail,
+					Message: "Password must not contain your email address",
+				},
+			)
+		}
+	}
+
+	if p.Breached != nil && p.Breached.Contains(password) {
+		violations = append(
+			violations, PasswordViolation{
+				Code:    PasswordBreached,
+				Message: "Password appears in a list of breached passwords",
+			},
+		)
+	}
+
+	return violations
+}
+
+// characterClasses counts the character classes used in a password
+func characterClasses(password string) int {
+	var lower, upper, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+
+	count := 0
+	for _, used := range []bool{lower, upper, digit, symbol} {
+		if used {
+			count++
+		}
+	}
+	return count
+}
+
+// BreachedPasswords is an offline set of breached password SHA-1 hashes.
+// Entries may be truncated to a prefix to keep the file small, at the cost
+// of some false positives.
+type BreachedPasswords struct {
+	prefixLength int
+	prefixes     map[string]struct{}
+}
+
+// LoadBreachedPasswords loads a file with one hex SHA-1 hash or hash prefix
+// per line. All entries must have the same length; an optional ":count"
+// suffix (as in the Have I Been Pwned downloads) and "#" comments are ignored.
+func LoadBreachedPasswords(path string) (*BreachedPasswords, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	breached := &BreachedPasswords{
+		prefixes: make(map[string]struct{}),
+	}
+
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		entry := strings.TrimSpace(scanner.Text())
+		entry, _, _ = strings.Cut(entry, ":")
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+
+		entry = strings.ToUpper(entry)
+		if _, err := hex.DecodeString(padHex(entry)); err != nil ||
+			len(entry) > sha1.Size*2 {
+			return nil, fmt.Errorf("line %d: invalid SHA-1 prefix", line)
+		}
+
+		if breached.prefixLength == 0 {
+			breached.prefixLength = len(entry)
+		}
+		if len(entry) != breached.prefixLength {
+			return nil, fmt.Errorf("line %d: inconsistent prefix length", line)
+		}
+
+		breached.prefixes[entry] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return breached, nil
+}
+
+// Contains reports whether the password's SHA-1 matches a breached entry
+func (b *BreachedPasswords) Contains(password string) bool {
+	if b.prefixLength == 0 {
+		return false
+	}
+
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	_, found := b.prefixes[hash[:b.prefixLength]]
+	return found
+}
+
+// padHex pads odd-length hex strings so they can be decoded for validation
+func padHex(s string) string {
+	if len(s)%2 == 1 {
+		return s + "0"
+	}
+	return s
+}
@@ -0,0 +1,60 @@
+package utils
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+)
+
+// GenerateRandomToken returns a URL-safe random token of n random bytes
+func GenerateRandomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// APIKeyPrefix starts every API key so that keys can be told apart from JWTs
+const APIKeyPrefix = "gad_"
+
+// GenerateAPIKey returns a new API key and its prefix, which identifies the
+// key without revealing its secret part
+func GenerateAPIKey() (key, prefix string, err error) {
+	id := make([]byte, 4)
+	if _, err := rand.Read(id); err != nil {
+		return "", "", err
+	}
+
+	secret, err := GenerateRandomToken(32)
+	if err != nil {
+		return "", "", err
+	}
+
+	prefix = APIKeyPrefix + hex.EncodeToString(id)
+	return prefix + "_" + secret, prefix, nil
+}
+
+// GenerateRecoveryCode returns a random single-use code formatted as
+// "xxxxx-xxxxx" for easy transcription
+func GenerateRecoveryCode() (string, error) {
+	b := make([]byte, 7)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	code := strings.ToLower(
+		base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b),
+	)
+	return code[:5] + "-" + code[5:10], nil
+}
+
+// NormalizeRecoveryCode strips formatting so that codes compare regardless of
+// case, spaces and dashes
+func NormalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.ReplaceAll(code, "-", "")
+	return strings.ReplaceAll(code, " ", "")
+}
@@ -0,0 +1,101 @@
+package utils
+
+import (
+	"sync"
+	"time"
+)
+
+// RateLimiter is an in-memory fixed window rate limiter keyed by string
+type RateLimiter struct {
+	Limit  int
+	Window time.Duration
+
+	mu        sync.Mutex
+	windows   map[string]*rateWindow
+	lastPrune time.Time
+}
+
+// rateWindow tracks the hits of a key within the current window
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+// NewRateLimiter creates a new RateLimiter allowing limit hits per window
+func NewRateLimiter(limit int, window time.Duration) *RateLimiter {
+	return &RateLimiter{
+		Limit:   limit,
+		Window:  window,
+		windows: make(map[string]*rateWindow),
+	}
+}
+
+// Allow records a hit for the key. When the limit is exceeded it returns
+// false and the time until the current window ends.
+func (l *RateLimiter) Allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	allowed, retryAfter := l.check(key, time.Now())
+	if allowed {
+		l.windows[key].count++
+	}
+	return allowed, retryAfter
+}
+
+// Check reports whether the key is still below the limit without recording
+// a hit. When it is not, the time until the current window ends is returned.
+func (l *RateLimiter) Check(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.check(key, time.Now())
+}
+
+// Hit records a hit for the key, even past the limit
+func (l *RateLimiter) Hit(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.check(key, time.Now())
+	l.windows[key].count++
+}
+
+// Reset forgets all hits of the key
+func (l *RateLimiter) Reset(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.windows, key)
+}
+
+// check makes sure the key has a current window and compares it to the limit
+func (l *RateLimiter) check(key string, now time.Time) (bool, time.Duration) {
+	l.prune(now)
+
+	window, exists := l.windows[key]
+	if !exists || now.Sub(window.start) >= l.Window {
+		window = &rateWindow{start: now}
+		l.windows[key] = window
+	}
+
+	if window.count >= l.Limit {
+		return false, window.start.Add(l.Window).Sub(now)
+	}
+
+	return true, 0
+}
+
+// prune drops windows that have ended, at most once per window
+func (l *RateLimiter) prune(now time.Time) {
+	if now.Sub(l.lastPrune) < l.Window {
+		return
+	}
+	l.lastPrune = now
+
+	for key, window := range l.windows {
+		if now.Sub(window.start) >= l.Window {
+			delete(l.windows, key)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package utils
+
+import (
+	"errors"
+	"strings"
+)
+
+const (
+	// ScopeUsersRead allows reading user records, including the caller's own
+	ScopeUsersRead = "users:read"
+	// ScopeUsersWrite allows creating, updating and deleting user records
+	ScopeUsersWrite = "users:write"
+	// ScopeAccount allows managing the caller's credentials and sessions
+	ScopeAccount = "account"
+	// ScopeOpenID requests an OpenID Connect ID token
+	ScopeOpenID = "openid"
+	// ScopeProfile allows reading the basic profile at /userinfo
+	ScopeProfile = "profile"
+	// ScopeEmail allows reading the email address at /userinfo
+	ScopeEmail = "email"
+)
+
+// DefaultScopes are granted when a token is requested without a scope
+var DefaultScopes = []string{ScopeUsersRead, ScopeUsersWrite, ScopeAccount}
+
+// MachineScopes may be granted to API keys and OAuth clients. Managing
+// credentials and sessions always requires an interactive login.
+var MachineScopes = []string{ScopeUsersRead, ScopeUsersWrite}
+
+// OIDCScopes are the OpenID Connect scopes that OAuth clients may request
+// through the authorization code flow
+var OIDCScopes = []string{ScopeOpenID, ScopeProfile, ScopeEmail}
+
+// ErrInvalidScope is returned when a requested scope is unknown or exceeds
+// the scopes available to the requester
+var ErrInvalidScope = errors.New("invalid scope")
+
+// ParseScope splits a space separated scope string as used in OAuth2
+func ParseScope(scope string) []string {
+	return strings.Fields(scope)
+}
+
+// FormatScope joins scopes into a space separated scope string
+func FormatScope(scopes []string) string {
+	return strings.Join(scopes, " ")
+}
+
+// HasScope reports whether the granted scopes include the required one
+func HasScope(granted []string, required string) bool {
+	for _, scope := range granted {
+		if scope == required {
+			return true
+		}
+	}
+	return false
+}
+
+// NarrowScope returns the requested scopes, which must all be available.
+// An empty request yields every available scope.
+func NarrowScope(requested string, available []string) ([]string, error) {
+	scopes := ParseScope(requested)
+	if len(scopes) == 0 {
+		return available, nil
+	}
+
+	var narrowed []string
+	for _, scope := range scopes {
+		if !HasScope(available, scope) {
+			return nil, ErrInvalidScope
+		}
+		if !HasScope(narrowed, scope) {
+			narrowed = append(narrowed, scope)
+		}
+	}
+
+	return narrowed, nil
+}
+
+// Scopes returns the scopes granted by the claims. Tokens issued before
+// scopes were introduced carry none and are granted the default scopes.
+func (c *Claims) Scopes() []string {
+	if c.Scope == "" {
+		return DefaultScopes
+	}
+	return ParseScope(c.Scope)
+}
@@ -0,0 +1,83 @@
+package utils
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+// sealedSecretPrefix marks secrets encrypted by a SecretBox
+const sealedSecretPrefix = "enc:v1:"
+
+// SecretBox encrypts secrets that have to be stored in a recoverable form,
+// such as TOTP secrets, with AES-256-GCM
+type SecretBox struct {
+	aead cipher.AEAD
+}
+
+// NewSecretBox creates a new SecretBox whose key is derived from the
+// configured key string
+func NewSecretBox(key string) (*SecretBox, error) {
+	if key == "" {
+		return nil, errors.New("secret encryption key is required")
+	}
+
+	sum := sha256.Sum256([]byte(key))
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SecretBox{aead: aead}, nil
+}
+
+// Seal encrypts a secret. The context, e.g. the ID of the owning record, is
+// authenticated along with it so that sealed secrets cannot be moved between
+// records.
+func (b *SecretBox) Seal(secret, context string) (string, error) {
+	nonce := make([]byte, b.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := b.aead.Seal(nonce, nonce, []byte(secret), []byte(context))
+	return sealedSecretPrefix +
+		base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// Open decrypts a secret sealed with the same context
+func (b *SecretBox) Open(sealed, context string) (string, error) {
+	if !IsSealedSecret(sealed) {
+		return "", errors.New("secret is not sealed")
+	}
+
+	data, err := base64.RawStdEncoding.DecodeString(
+		strings.TrimPrefix(sealed, sealedSecretPrefix),
+	)
+	if err != nil {
+		return "", err
+	}
+	if len(data) < b.aead.NonceSize() {
+		return "", errors.New("sealed secret is too short")
+	}
+
+	nonce, ciphertext := data[:b.aead.NonceSize()], data[b.aead.NonceSize():]
+	secret, err := b.aead.Open(nil, nonce, ciphertext, []byte(context))
+	if err != nil {
+		return "", err
+	}
+	return string(secret), nil
+}
+
+// IsSealedSecret reports whether a stored secret is encrypted
+func IsSealedSecret(secret string) bool {
+	return strings.HasPrefix(secret, sealedSecretPrefix)
+}
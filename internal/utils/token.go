@@ -10,22 +10,26 @@ import (
 
 // TokenManager provides methods for creating and validating JWT tokens
 type TokenManager struct {
-	Secret                []byte
+	Keyring               *Keyring
 	Issuer                string
 	AccessTokenExpiresIn  time.Duration
 	RefreshTokenExpiresIn time.Duration
+	MFATokenExpiresIn     time.Duration
 }
 
 // NewTokenManager creates a new TokenManager
 func NewTokenManager(
-	secret, issuer string,
+	keyring *Keyring,
+	issuer string,
 	accessTokenExpiresIn, refreshTokenExpiresIn time.Duration,
+	mfaTokenExpiresIn time.Duration,
 ) *TokenManager {
 	return &TokenManager{
-		Secret:                []byte(secret),
+		Keyring:               keyring,
 		Issuer:                issuer,
 		AccessTokenExpiresIn:  accessTokenExpiresIn,
 		RefreshTokenExpiresIn: refreshTokenExpiresIn,
+		MFATokenExpiresIn:     mfaTokenExpiresIn,
 	}
 }
 
@@ -37,67 +41,321 @@ const (
 	AccessToken TokenType = "access"
 	// RefreshToken is a long-lived token used to get new access tokens
 	RefreshToken TokenType = "refresh"
+	// MFAToken is a short-lived token proving the password step of a login
+	// that still has to be completed with a second factor
+	MFAToken TokenType = "mfa"
+	// OIDCStateToken carries the state of a federated login from its start
+	// to the callback, bound to the browser in a cookie
+	OIDCStateToken TokenType = "oidc_state"
 )
 
 // Claims represents JWT claims
 type Claims struct {
-	UserID    uuid.UUID `json:"user_id"`
-	Email     string    `json:"email"`
-	TokenType TokenType `json:"token_type"`
+	UserID          uuid.UUID `json:"user_id"`
+	Email           string    `json:"email"`
+	Role            string    `json:"role,omitempty"`      // user access tokens only
+	Scope           string    `json:"scope,omitempty"`     // space separated
+	ClientID        string    `json:"client_id,omitempty"` // client credentials only
+	AuthorizedParty string    `json:"azp,omitempty"`       // OAuth client a user signed in to
+	SessionID       string    `json:"sid,omitempty"`       // refresh token family, access tokens of a login only
+	Method          string    `json:"method,omitempty"`    // MFA tokens only, how the first factor was checked
+	Actor           *Actor    `json:"act,omitempty"`       // impersonation tokens only
+	TokenType       TokenType `json:"token_type"`
 	jwt.RegisteredClaims
 }
 
-// GenerateAccessToken generates a short-lived access token for a user
-func (m *TokenManager) GenerateAccessToken(userID uuid.UUID, email string) (
-	string,
-	error,
-) {
+// Actor identifies the admin acting as the user of an impersonation token
+// (RFC 8693 section 4.1)
+type Actor struct {
+	Subject string `json:"sub"`
+	Email   string `json:"email,omitempty"`
+}
+
+// OIDCStateClaims represents the claims of a federated login state token.
+// The token ID is the state parameter sent to the identity provider.
+type OIDCStateClaims struct {
+	Provider     string    `json:"provider"`
+	Nonce        string    `json:"nonce"`
+	CodeVerifier string    `json:"code_verifier"`
+	LinkUserID   uuid.UUID `json:"link_user_id"` // signed in user linking the identity, if any
+	TokenType    TokenType `json:"token_type"`
+	jwt.RegisteredClaims
+}
+
+// IDClaims represents the claims of an OpenID Connect ID token
+type IDClaims struct {
+	AuthTime      int64  `json:"auth_time"`
+	Nonce         string `json:"nonce,omitempty"`
+	Email         string `json:"email,omitempty"`
+	EmailVerified *bool  `json:"email_verified,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// GenerateIDToken generates an OpenID Connect ID token for a user, issued to
+// the client. The email claims are only set when email is not empty.
+func (m *TokenManager) GenerateIDToken(
+	userID uuid.UUID,
+	clientID, nonce string,
+	authTime time.Time,
+	email string,
+	emailVerified bool,
+) (string, error) {
+	now := time.Now()
+	claims := IDClaims{
+		AuthTime: authTime.Unix(),
+		Nonce:    nonce,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   userID.String(),
+			Audience:  jwt.ClaimStrings{clientID},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(m.AccessTokenExpiresIn)),
+			Issuer:    m.Issuer,
+		},
+	}
+	if email != "" {
+		claims.Email = email
+		claims.EmailVerified = &emailVerified
+	}
+
+	return m.sign(claims)
+}
+
+// IssuedAtTime returns when the token was issued. Tokens without an iat claim
+// are treated as infinitely old so that any revocation watermark applies.
+func (c *Claims) IssuedAtTime() time.Time {
+	if c.IssuedAt == nil {
+		return time.Time{}
+	}
+	return c.IssuedAt.Time
+}
+
+// Client returns the OAuth client the token was issued to, which is empty for
+// tokens of first-party logins
+func (c *Claims) Client() string {
+	if c.ClientID != "" {
+		return c.ClientID
+	}
+	return c.AuthorizedParty
+}
+
+// GenerateAccessToken generates a short-lived access token for a user,
+// limited to the given scopes. clientID names the OAuth client the user
+// signed in to and is empty for first-party logins. sessionID is the refresh
+// token family of the login, whose revocation also revokes the token.
+func (m *TokenManager) GenerateAccessToken(
+	userID uuid.UUID,
+	email, role string,
+	scopes []string,
+	clientID, sessionID string,
+) (string, error) {
+	now := time.Now()
+	claims := Claims{
+		UserID:          userID,
+		Email:           email,
+		Role:            role,
+		Scope:           FormatScope(scopes),
+		AuthorizedParty: clientID,
+		SessionID:       sessionID,
+		TokenType:       AccessToken,
+		RegisteredClaims: jwt.RegisteredClaims{
+			// The ID allows revoking the token before it expires
+			ID:        uuid.NewString(),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(m.AccessTokenExpiresIn)),
+			Issuer:    m.Issuer,
+		},
+	}
+
+	return m.sign(claims)
+}
+
+// GenerateImpersonationToken generates an access token that lets an admin
+// act as a user. It names the admin in its act claim, is limited to the
+// given scopes and lives only as long as expiresIn.
+func (m *TokenManager) GenerateImpersonationToken(
+	userID uuid.UUID,
+	email, role string,
+	scopes []string,
+	actor Actor,
+	expiresIn time.Duration,
+) (string, error) {
 	now := time.Now()
 	claims := Claims{
 		UserID:    userID,
 		Email:     email,
+		Role:      role,
+		Scope:     FormatScope(scopes),
+		Actor:     &actor,
 		TokenType: AccessToken,
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.NewString(),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(expiresIn)),
+			Issuer:    m.Issuer,
+		},
+	}
+
+	return m.sign(claims)
+}
+
+// GenerateClientAccessToken generates a short-lived access token for an
+// OAuth client acting on its own behalf. The client is the token's subject
+// and neither a user nor a role is set.
+func (m *TokenManager) GenerateClientAccessToken(
+	clientID string,
+	scopes []string,
+) (string, error) {
+	now := time.Now()
+	claims := Claims{
+		ClientID:  clientID,
+		Scope:     FormatScope(scopes),
+		TokenType: AccessToken,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.NewString(),
+			Subject:   clientID,
 			IssuedAt:  jwt.NewNumericDate(now),
 			ExpiresAt: jwt.NewNumericDate(now.Add(m.AccessTokenExpiresIn)),
 			Issuer:    m.Issuer,
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(m.Secret)
+	return m.sign(claims)
 }
 
-// GenerateRefreshToken generates a long-lived refresh token for a user
-func (m *TokenManager) GenerateRefreshToken(userID uuid.UUID, email string) (
-	string,
-	error,
-) {
+// GenerateRefreshToken generates a long-lived refresh token for a user. The
+// scopes bound the access tokens it can be exchanged for, and clientID is
+// passed on to them.
+func (m *TokenManager) GenerateRefreshToken(
+	userID uuid.UUID,
+	email string,
+	scopes []string,
+	clientID string,
+) (string, error) {
+	now := time.Now()
+	claims := Claims{
+		UserID:          userID,
+		Email:           email,
+		Scope:           FormatScope(scopes),
+		AuthorizedParty: clientID,
+		TokenType:       RefreshToken,
+		RegisteredClaims: jwt.RegisteredClaims{
+			// A unique ID keeps tokens issued within the same second distinct
+			ID:        uuid.NewString(),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(m.RefreshTokenExpiresIn)),
+			Issuer:    m.Issuer,
+		},
+	}
+
+	return m.sign(claims)
+}
+
+// GenerateMFAToken generates a short-lived MFA challenge token for a user,
+// carrying the scopes requested at login and the login method. Its ID lets
+// the token be revoked once it was used.
+func (m *TokenManager) GenerateMFAToken(
+	userID uuid.UUID,
+	email string,
+	scopes []string,
+	method string,
+) (string, error) {
 	now := time.Now()
 	claims := Claims{
 		UserID:    userID,
 		Email:     email,
-		TokenType: RefreshToken,
+		Scope:     FormatScope(scopes),
+		Method:    method,
+		TokenType: MFAToken,
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.NewString(),
 			IssuedAt:  jwt.NewNumericDate(now),
-			ExpiresAt: jwt.NewNumericDate(now.Add(m.RefreshTokenExpiresIn)),
+			ExpiresAt: jwt.NewNumericDate(now.Add(m.MFATokenExpiresIn)),
 			Issuer:    m.Issuer,
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(m.Secret)
+	return m.sign(claims)
+}
+
+// GenerateOIDCStateToken generates a token holding the state, nonce and PKCE
+// code verifier of a federated login with the provider. linkUserID is set
+// when a signed in user links the identity instead of logging in.
+func (m *TokenManager) GenerateOIDCStateToken(
+	provider, state, nonce, codeVerifier string,
+	linkUserID uuid.UUID,
+	expiresIn time.Duration,
+) (string, error) {
+	now := time.Now()
+	claims := OIDCStateClaims{
+		Provider:     provider,
+		Nonce:        nonce,
+		CodeVerifier: codeVerifier,
+		LinkUserID:   linkUserID,
+		TokenType:    OIDCStateToken,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        state,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(expiresIn)),
+			Issuer:    m.Issuer,
+		},
+	}
+
+	return m.sign(claims)
+}
+
+// ValidateOIDCStateToken validates a federated login state token
+func (m *TokenManager) ValidateOIDCStateToken(tokenString string) (
+	*OIDCStateClaims,
+	error,
+) {
+	var claims OIDCStateClaims
+	_, err := jwt.ParseWithClaims(
+		tokenString, &claims, m.verificationKey,
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.TokenType != OIDCStateToken {
+		return nil, errors.New("token is not an OIDC state token")
+	}
+
+	return &claims, nil
+}
+
+// sign signs claims with the active key and sets its key ID header
+func (m *TokenManager) sign(claims jwt.Claims) (string, error) {
+	key := m.Keyring.Active
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.SignKey)
+}
+
+// verificationKey selects the key that verifies a parsed token by its kid
+func (m *TokenManager) verificationKey(token *jwt.Token) (interface{}, error) {
+	// Tokens issued before key IDs were introduced carry no kid
+	key := m.Keyring.Active
+	if kid, ok := token.Header["kid"].(string); ok {
+		if key, ok = m.Keyring.Get(kid); !ok {
+			return nil, errors.New("unknown signing key")
+		}
+	}
+
+	// Never let the token pick an algorithm other than the key's own
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, errors.New("unexpected signing method")
+	}
+
+	return key.VerifyKey, nil
 }
 
 // Validate validates a JWT token
 func (m *TokenManager) Validate(tokenString string) (*Claims, error) {
+	// Revocation relies on every token expiring
 	token, err := jwt.ParseWithClaims(
-		tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, errors.New("unexpected signing method")
-			}
-			return m.Secret, nil
-		},
+		tokenString, &Claims{}, m.verificationKey,
+		jwt.WithExpirationRequired(),
 	)
 
 	if err != nil {
@@ -111,6 +369,18 @@ func (m *TokenManager) Validate(tokenString string) (*Claims, error) {
 	return nil, errors.New("invalid token")
 }
 
+// JWKS returns the public keys that verify tokens issued by this manager,
+// including verification-only keys
+func (m *TokenManager) JWKS() JWKSet {
+	set := JWKSet{Keys: []JWK{}}
+	for _, key := range m.Keyring.Keys() {
+		if jwk, ok := key.JWK(); ok {
+			set.Keys = append(set.Keys, jwk)
+		}
+	}
+	return set
+}
+
 // ValidateAccessToken validates an access token
 func (m *TokenManager) ValidateAccessToken(tokenString string) (
 	*Claims,
@@ -144,3 +414,20 @@ func (m *TokenManager) ValidateRefreshToken(tokenString string) (
 
 	return claims, nil
 }
+
+// ValidateMFAToken validates an MFA challenge token
+func (m *TokenManager) ValidateMFAToken(tokenString string) (
+	*Claims,
+	error,
+) {
+	claims, err := m.Validate(tokenString)
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.TokenType != MFAToken {
+		return nil, errors.New("token is not an MFA token")
+	}
+
+	return claims, nil
+}